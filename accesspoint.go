@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// parseS3URL parses the s3://bucket/key url. The bucket may also be the ARN of
+// an access point, s3://arn:aws:s3:us-east-1:123456789012:accesspoint/name/key,
+// which url.Parse can't take as a host, so it's split at the access point name.
+func parseS3URL(s string) (*url.URL, error) {
+	if !strings.HasPrefix(s, "s3://arn:") {
+		return url.Parse(s)
+	}
+	rest := strings.TrimPrefix(s, "s3://")
+	i := strings.Index(rest, ":accesspoint/")
+	if i < 0 {
+		return nil, fmt.Errorf("bucket ARN of %s must be an access point ARN, e.g. arn:aws:s3:us-east-1:123456789012:accesspoint/name", s)
+	}
+	end := i + len(":accesspoint/")
+	if j := strings.Index(rest[end:], "/"); j >= 0 {
+		end += j
+	} else {
+		end = len(rest)
+	}
+	bucket, key := rest[:end], rest[end:]
+	if err := validateAccessPoint(bucket); err != nil {
+		return nil, err
+	}
+	return &url.URL{Scheme: "s3", Host: bucket, Path: key}, nil
+}
+
+// validateAccessPoint checks the access point ARN. The multi-region access points
+// have no region and are signed with SigV4A, which the SDK doesn't support.
+func validateAccessPoint(s string) error {
+	a, err := arn.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid access point ARN %s: %v", s, err)
+	}
+	name := strings.TrimPrefix(a.Resource, "accesspoint/")
+	switch {
+	case a.Service != "s3":
+		return fmt.Errorf("invalid access point ARN %s: service must be s3", s)
+	case name == "" || strings.Contains(name, "/"):
+		return fmt.Errorf("invalid access point ARN %s: expected accesspoint/NAME", s)
+	case len(a.AccountID) != 12 || strings.Trim(a.AccountID, "0123456789") != "":
+		return fmt.Errorf("invalid access point ARN %s: account ID must be 12 digits", s)
+	case a.Region == "" && strings.HasSuffix(name, ".mrap"):
+		return fmt.Errorf("multi-region access point %s isn't supported, use the ARN of a regional access point", s)
+	case a.Region == "":
+		return fmt.Errorf("invalid access point ARN %s: region is required", s)
+	}
+	return nil
+}
+
+// accessPoint reports whether the bucket is an access point ARN.
+func accessPoint(bucket string) bool {
+	return strings.HasPrefix(bucket, "arn:")
+}
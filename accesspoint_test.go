@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestParseS3URL(t *testing.T) {
+	ap := "arn:aws:s3:us-east-1:123456789012:accesspoint/reports"
+	tests := []struct {
+		s          string
+		host, path string
+	}{
+		{"s3://bucket1/dir/key", "bucket1", "/dir/key"},
+		{"s3://bucket1/", "bucket1", "/"},
+		{"s3://" + ap + "/dir/key", ap, "/dir/key"},
+		{"s3://" + ap + "/", ap, "/"},
+		{"s3://" + ap, ap, ""},
+	}
+	for _, test := range tests {
+		u, err := parseS3URL(test.s)
+		if err != nil {
+			t.Errorf("parseS3URL(%s): %v", test.s, err)
+			continue
+		}
+		if u.Scheme != "s3" || u.Host != test.host || u.Path != test.path {
+			t.Errorf("parseS3URL(%s) = %s %s %s, want s3 %s %s", test.s, u.Scheme, u.Host, u.Path, test.host, test.path)
+		}
+	}
+
+	errs := map[string]string{
+		"s3://arn:aws:s3:::bucket1/key":                                   "must be an access point ARN",
+		"s3://arn:aws:s3::123456789012:accesspoint/global.mrap/key":       "multi-region access point",
+		"s3://arn:aws:sns:us-east-1:123456789012:accesspoint/reports/key": "service must be s3",
+	}
+	for s, want := range errs {
+		if _, err := parseS3URL(s); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseS3URL(%s) = %v, want %q", s, err, want)
+		}
+	}
+}
+
+func TestValidateAccessPoint(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:s3:us-east-1:123456789012:accesspoint/reports":   "",
+		"arn:aws-cn:s3:cn-north-1:123456789012:accesspoint/a-b-1": "",
+		"accesspoint/reports":                                   "invalid access point ARN",
+		"arn:aws:s3:us-east-1:123456789012:accesspoint/":        "expected accesspoint/NAME",
+		"arn:aws:s3:us-east-1:123456789012:accesspoint/a/b":     "expected accesspoint/NAME",
+		"arn:aws:s3:us-east-1:1234:accesspoint/reports":         "account ID must be 12 digits",
+		"arn:aws:s3:us-east-1:12345678901x:accesspoint/reports": "account ID must be 12 digits",
+		"arn:aws:s3::123456789012:accesspoint/reports":          "region is required",
+		"arn:aws:s3::123456789012:accesspoint/global.mrap":      "isn't supported",
+	}
+	for s, want := range tests {
+		err := validateAccessPoint(s)
+		switch {
+		case want == "" && err != nil:
+			t.Errorf("validateAccessPoint(%s): %v", s, err)
+		case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+			t.Errorf("validateAccessPoint(%s) = %v, want %q", s, err, want)
+		}
+	}
+}
+
+func TestAccessPointCopySource(t *testing.T) {
+	ap := "arn:aws:s3:us-east-1:123456789012:accesspoint/reports"
+	if !accessPoint(ap) || accessPoint("bucket1") {
+		t.Error("accessPoint() doesn't tell the ARNs from the bucket names")
+	}
+	want := "arn%3Aaws%3As3%3Aus-east-1%3A123456789012%3Aaccesspoint%2Freports%2Fobject%2Fdir%2Fa.txt"
+	if got := copySource(ap, "dir/a.txt", ""); got != want {
+		t.Errorf("copySource(%s) = %q, want %q", ap, got, want)
+	}
+}
+
+func TestAccessPointDestination(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:         aws.String("us-west-2"),
+		Credentials:    credentials.NewStaticCredentials("id", "secret", ""),
+		S3UseARNRegion: aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dest string
+		// host is the endpoint of the copy, the access point in its own region.
+		host, path string
+	}{
+		{"s3://bucket1/dir/", "bucket1.s3.us-west-2.amazonaws.com", "/dir/a.txt"},
+		{"s3://arn:aws:s3:us-east-1:123456789012:accesspoint/reports/dir/", "reports-123456789012.s3-accesspoint.us-east-1.amazonaws.com", "/dir/a.txt"},
+	}
+	for _, test := range tests {
+		u, err := parseS3URL(test.dest)
+		if err != nil {
+			t.Errorf("parseS3URL(%s): %v", test.dest, err)
+			continue
+		}
+		req, _ := s3.New(sess).CopyObjectRequest(&s3.CopyObjectInput{
+			Bucket:     aws.String(u.Host),
+			Key:        aws.String(strings.TrimPrefix(u.Path, "/") + "a.txt"),
+			CopySource: aws.String(copySource("bucket2", "a.txt", "")),
+		})
+		if err := req.Build(); err != nil {
+			t.Errorf("copy to %s: %v", test.dest, err)
+			continue
+		}
+		if got := req.HTTPRequest.URL; got.Host != test.host || got.Path != test.path {
+			t.Errorf("copy to %s = %s%s, want %s%s", test.dest, got.Host, got.Path, test.host, test.path)
+		}
+	}
+}
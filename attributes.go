@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// objectAttributes are the source object attributes captured by --head-before-copy.
+type objectAttributes struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	StorageClass string    `json:"storageClass"`
+	ContentType  string    `json:"contentType"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// newObjectAttributes captures the attributes from the HEAD response of the object.
+func newObjectAttributes(bucket, key string, head *s3.HeadObjectOutput) objectAttributes {
+	// HEAD omits the storage class of STANDARD objects.
+	storageClass := aws.StringValue(head.StorageClass)
+	if storageClass == "" {
+		storageClass = s3.StorageClassStandard
+	}
+	return objectAttributes{
+		Bucket:       bucket,
+		Key:          key,
+		Size:         aws.Int64Value(head.ContentLength),
+		ETag:         aws.StringValue(head.ETag),
+		StorageClass: storageClass,
+		ContentType:  aws.StringValue(head.ContentType),
+		LastModified: aws.TimeValue(head.LastModified),
+	}
+}
+
+// jsonLinesWriter writes JSON records one per line, safe for concurrent use.
+type jsonLinesWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// newJSONLinesWriter returns a JSON lines writer to w.
+func newJSONLinesWriter(w io.Writer) *jsonLinesWriter {
+	return &jsonLinesWriter{enc: json.NewEncoder(w)}
+}
+
+// write appends the record as a line.
+func (w *jsonLinesWriter) write(v interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(v)
+}
+
+// metadataExport writes the records of the source objects headed for --export-metadata,
+// as CSV for the files with the .csv extension and as JSON lines otherwise.
+type metadataExport struct {
+	out *output
+}
+
+// newMetadataExport returns the export of the file name to w.
+func newMetadataExport(filename string, w io.Writer) *metadataExport {
+	format := formatJSON
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		format = formatCSV
+	}
+	return &metadataExport{out: &output{format: format, w: w, csv: csv.NewWriter(w)}}
+}
+
+// write writes the record of the object with its user metadata,
+// encoded as a JSON object in the CSV cell.
+func (e *metadataExport) write(attrs objectAttributes, metadata map[string]*string) error {
+	values := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		values[strings.ToLower(k)] = aws.StringValue(v)
+	}
+	var cell interface{} = values
+	if e.out.format == formatCSV {
+		encoded, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		cell = string(encoded)
+	}
+	return e.out.write([]string{"key", "size", "etag", "storage_class", "content_type", "metadata"},
+		attrs.Key, attrs.Size, attrs.ETag, attrs.StorageClass, attrs.ContentType, cell)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestObjectAttributesJSONLines(t *testing.T) {
+	modified := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	head := &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(10),
+		ETag:          aws.String(`"abc"`),
+		ContentType:   aws.String("text/plain"),
+		LastModified:  aws.Time(modified),
+	}
+	var buf bytes.Buffer
+	w := newJSONLinesWriter(&buf)
+	for _, key := range []string{"a.txt", "b.txt"} {
+		if err := w.write(newObjectAttributes("bucket1", key, head)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dec := json.NewDecoder(&buf)
+	for _, key := range []string{"a.txt", "b.txt"} {
+		var got objectAttributes
+		if err := dec.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		want := objectAttributes{Bucket: "bucket1", Key: key, Size: 10, ETag: `"abc"`, StorageClass: s3.StorageClassStandard,
+			ContentType: "text/plain", LastModified: modified}
+		if got != want {
+			t.Errorf("line = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestMetadataExport(t *testing.T) {
+	attrs := objectAttributes{Key: "a,b.txt", Size: 10, ETag: `"abc"`, StorageClass: "STANDARD", ContentType: "text/plain"}
+	metadata := map[string]*string{"Owner": aws.String("data"), "job": aws.String("1")}
+	tests := map[string]string{
+		"export.CSV": "key,size,etag,storage_class,content_type,metadata\n" +
+			`"a,b.txt",10,"""abc""",STANDARD,text/plain,"{""job"":""1"",""owner"":""data""}"` + "\n",
+		"export.jsonl": `{"content_type":"text/plain","etag":"\"abc\"","key":"a,b.txt","metadata":{"job":"1","owner":"data"},"size":10,"storage_class":"STANDARD"}` + "\n",
+	}
+	for filename, want := range tests {
+		var buf bytes.Buffer
+		e := newMetadataExport(filename, &buf)
+		if err := e.write(attrs, metadata); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != want {
+			t.Errorf("export of %s = %q, want %q", filename, buf.String(), want)
+		}
+	}
+}
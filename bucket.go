@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// createBucketInput returns the input creating the bucket in the region.
+// The us-east-1 region is the default and is rejected as a LocationConstraint.
+func createBucketInput(bucket, region string) *s3.CreateBucketInput {
+	input := &s3.CreateBucketInput{Bucket: aws.String(bucket)}
+	if region != "" && region != defaultRegion {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(region),
+		}
+	}
+	return input
+}
+
+// ensureBucket creates the bucket in the region unless it already exists
+// and reports whether it was created.
+func ensureBucket(ctx context.Context, svc *s3.S3, bucket, region string) (bool, error) {
+	_, err := svc.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		return false, nil
+	}
+	var rerr awserr.RequestFailure
+	if !errors.As(err, &rerr) || rerr.StatusCode() != 404 {
+		return false, fmt.Errorf("check bucket: %w", err)
+	}
+	_, err = svc.CreateBucketWithContext(ctx, createBucketInput(bucket, region))
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == "AccessDenied" {
+			return false, fmt.Errorf("create bucket denied, s3:CreateBucket permission is required: %w", err)
+		}
+		return false, fmt.Errorf("create bucket: %w", err)
+	}
+	return true, nil
+}
+
+// ensureVersioning checks that the versioning of the bucket is enabled, without it
+// the versions copied by --all-versions overwrite each other and only the last one
+// is kept. With enable it's enabled instead, and it reports whether it was.
+func ensureVersioning(ctx context.Context, svc *s3.S3, bucket string, enable bool) (bool, error) {
+	out, err := svc.GetBucketVersioningWithContext(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return false, fmt.Errorf("get bucket versioning: %w", err)
+	}
+	status := aws.StringValue(out.Status)
+	if status == s3.BucketVersioningStatusEnabled {
+		return false, nil
+	}
+	if !enable {
+		if status == "" {
+			status = "not enabled"
+		}
+		return false, fmt.Errorf("versioning of bucket %s is %s, the copied versions would collapse into the latest one, "+
+			"enable it or run with --enable-dest-versioning", bucket, strings.ToLower(status))
+	}
+	_, err = svc.PutBucketVersioningWithContext(ctx, &s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String(s3.BucketVersioningStatusEnabled)},
+	})
+	if err != nil {
+		return false, fmt.Errorf("enable bucket versioning: %w", err)
+	}
+	return true, nil
+}
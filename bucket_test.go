@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestCreateBucketInput(t *testing.T) {
+	if input := createBucketInput("bucket1", defaultRegion); input.CreateBucketConfiguration != nil {
+		t.Errorf("createBucketInput(%s) = %v, want no LocationConstraint", defaultRegion, input)
+	}
+	if input := createBucketInput("bucket1", ""); input.CreateBucketConfiguration != nil {
+		t.Errorf("createBucketInput() = %v, want no LocationConstraint", input)
+	}
+	input := createBucketInput("bucket1", "eu-west-1")
+	if input.CreateBucketConfiguration == nil || aws.StringValue(input.CreateBucketConfiguration.LocationConstraint) != "eu-west-1" {
+		t.Errorf("createBucketInput(eu-west-1) = %v, want the LocationConstraint", input)
+	}
+}
+
+func TestEnsureBucket(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	fake.put("existing", "key", 1)
+	ctx := context.Background()
+
+	created, err := ensureBucket(ctx, svc, "existing", "eu-west-1")
+	if err != nil || created {
+		t.Errorf("ensureBucket() of an existing bucket = %v, %v, want false, nil", created, err)
+	}
+	created, err = ensureBucket(ctx, svc, "bucket2", "eu-west-1")
+	if err != nil || !created {
+		t.Errorf("ensureBucket() of a missing bucket = %v, %v, want true, nil", created, err)
+	}
+	last := fake.requests[len(fake.requests)-1]
+	if !strings.HasPrefix(last, "CREATE bucket2") || !strings.Contains(last, "<LocationConstraint>eu-west-1</LocationConstraint>") {
+		t.Errorf("request = %q, want the bucket created in eu-west-1", last)
+	}
+	if _, ok := fake.buckets["bucket2"]; !ok {
+		t.Error("bucket2 wasn't created")
+	}
+}
+
+func TestEnsureVersioning(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	ctx := context.Background()
+	fake.put("bucket1", "a", 1)
+	fake.versioning["bucket1"] = "Enabled"
+	if enabled, err := ensureVersioning(ctx, svc, "bucket1", true); err != nil || enabled {
+		t.Errorf("ensureVersioning() of a versioned bucket = %v, %v, want it kept", enabled, err)
+	}
+
+	for status, want := range map[string]string{"": "is not enabled", "Suspended": "is suspended"} {
+		fake.versioning["bucket1"] = status
+		if _, err := ensureVersioning(ctx, svc, "bucket1", false); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ensureVersioning() of versioning %q = %v, want %q", status, err, want)
+		}
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("requests = %q, want no versioning changes", fake.requests)
+	}
+
+	enabled, err := ensureVersioning(ctx, svc, "bucket1", true)
+	if err != nil || !enabled {
+		t.Errorf("ensureVersioning() with enable = %v, %v, want it enabled", enabled, err)
+	}
+	if fake.versioning["bucket1"] != "Enabled" || len(fake.requests) != 1 || fake.requests[0] != "VERSIONING Enabled" {
+		t.Errorf("requests = %q, want the versioning enabled", fake.requests)
+	}
+
+	if _, err := ensureVersioning(ctx, svc, "missing", true); err == nil || !strings.Contains(err.Error(), "get bucket versioning") {
+		t.Errorf("ensureVersioning() of a missing bucket = %v, want the versioning error", err)
+	}
+}
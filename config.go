@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	arg "github.com/alexflint/go-arg"
+	"github.com/alexflint/go-scalar"
+	"gopkg.in/yaml.v3"
+)
+
+// givenFlags returns the long names of the flags given on the command line or by
+// their environment variables, which take precedence over the profile and the
+// config file. go-arg parses the command line again into pointers to the fields,
+// without the defaults and the environment, so only the given flags are set.
+func givenFlags(argv []string, dest interface{}) (map[string]bool, error) {
+	t := reflect.TypeOf(dest).Elem()
+	given := make(map[string]bool)
+	fields := make([]reflect.StructField, t.NumField())
+	longs := make([]string, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		var env string
+		var opts []string
+		for _, opt := range strings.Split(field.Tag.Get("arg"), ",") {
+			switch {
+			case strings.HasPrefix(opt, "--"):
+				longs[i] = strings.TrimPrefix(opt, "--")
+				opts = append(opts, opt)
+			case strings.HasPrefix(opt, "env:"):
+				env = strings.TrimPrefix(opt, "env:")
+			case opt != "" && opt != "required":
+				opts = append(opts, opt)
+			}
+		}
+		if _, ok := os.LookupEnv(env); env != "" && longs[i] != "" && ok {
+			given[longs[i]] = true
+		}
+		typ := field.Type
+		if typ.Kind() != reflect.Slice && typ.Kind() != reflect.Map {
+			typ = reflect.PtrTo(typ)
+		}
+		fields[i] = reflect.StructField{Name: field.Name, Type: typ, Tag: reflect.StructTag(`arg:"` + strings.Join(opts, ",") + `"`)}
+	}
+	v := reflect.New(reflect.StructOf(fields))
+	p, err := arg.NewParser(arg.Config{IgnoreEnv: true}, v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Parse(argv); err != nil {
+		return nil, err
+	}
+	for i, long := range longs {
+		if long != "" && !v.Elem().Field(i).IsNil() {
+			given[long] = true
+		}
+	}
+	return given, nil
+}
+
+// loadConfig reads a YAML or TOML file into dest after parsing. The file keys are
+// the long flag names (e.g. storage-class). The values replace the defaults and the
+// profile, including the false, zero and empty ones, but not the given flags.
+func loadConfig(filename string, dest interface{}, given map[string]bool) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unsupported config format %q, use .yaml, .yml or .toml", filepath.Ext(filename))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config %s: %v", filename, err)
+	}
+
+	fields := configFields(dest)
+	for key, value := range values {
+		name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		field, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("unknown option %q in config %s", key, filename)
+		}
+		if given[name] {
+			continue
+		}
+		if err := setConfigValue(field, value); err != nil {
+			return fmt.Errorf("invalid value for %q in config %s: %v", key, filename, err)
+		}
+	}
+	return nil
+}
+
+// configFields maps the long flag names of the struct to its fields.
+// Positional arguments can't be set from the config file, nor --config and --profile
+// that choose the values.
+func configFields(dest interface{}) map[string]reflect.Value {
+	v := reflect.ValueOf(dest).Elem()
+	fields := make(map[string]reflect.Value)
+	for i := 0; i < v.NumField(); i++ {
+		for _, opt := range strings.Split(v.Type().Field(i).Tag.Get("arg"), ",") {
+			if strings.HasPrefix(opt, "--") && opt != "--config" && opt != "--profile" {
+				fields[strings.TrimPrefix(opt, "--")] = v.Field(i)
+			}
+		}
+	}
+	return fields
+}
+
+// setConfigValue parses a decoded config value into the field, a list replaces the list of the field.
+func setConfigValue(field reflect.Value, value interface{}) error {
+	if field.Kind() == reflect.Slice {
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+		items := reflect.MakeSlice(field.Type(), 0, len(list))
+		for _, item := range list {
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := scalar.ParseValue(elem, fmt.Sprint(item)); err != nil {
+				return err
+			}
+			items = reflect.Append(items, elem)
+		}
+		field.Set(items)
+		return nil
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return fmt.Errorf("expected a single value")
+	}
+	return scalar.ParseValue(field, fmt.Sprint(value))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	arg "github.com/alexflint/go-arg"
+)
+
+type configArgs struct {
+	Source      string   `arg:"positional"`
+	Config      string   `arg:"--config,env:TEST_CONFIG"`
+	Concurrency int      `arg:"-c,--concurrency,env:TEST_CONCURRENCY" default:"10"`
+	Keep        bool     `arg:"--keep,env:TEST_KEEP" default:"true"`
+	Class       string   `arg:"--storage-class,env:TEST_STORAGE_CLASS" default:"STANDARD"`
+	Exclude     []string `arg:"--exclude,env:TEST_EXCLUDE"`
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// parseWithConfig parses the command line and applies the config file like main.
+func parseWithConfig(t *testing.T, argv []string) configArgs {
+	t.Helper()
+	var dest configArgs
+	p, err := arg.NewParser(arg.Config{}, &dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Parse(argv); err != nil {
+		t.Fatal(err)
+	}
+	given, err := givenFlags(argv, &dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(dest.Config, &dest, given); err != nil {
+		t.Fatal(err)
+	}
+	return dest
+}
+
+func TestLoadConfigOverridesDefaults(t *testing.T) {
+	filename := writeConfig(t, "config.yaml", "concurrency: 0\nkeep: false\nstorage-class: \"\"\nexclude: [a/, b/]\n")
+	got := parseWithConfig(t, []string{"--config", filename})
+	want := configArgs{Config: filename, Exclude: []string{"a/", "b/"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	filename := writeConfig(t, "config.toml", "concurrency = 50\nkeep = false\nstorage_class = \"GLACIER\"\n")
+	os.Setenv("TEST_KEEP", "true")
+	defer os.Unsetenv("TEST_KEEP")
+	got := parseWithConfig(t, []string{"--config=" + filename, "-c", "7"})
+	want := configArgs{Config: filename, Concurrency: 7, Keep: true, Class: "GLACIER"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown.yaml": "concurrenc: 5\n",
+		"invalid.yaml": "concurrency: many\n",
+		"nested.yaml":  "concurrency: {a: 1}\n",
+		"config.json":  "{}",
+		"broken.toml":  "concurrency = \n",
+	}
+	for name, content := range tests {
+		var dest configArgs
+		if err := loadConfig(writeConfig(t, name, content), &dest, nil); err == nil {
+			t.Errorf("loadConfig(%s) succeeded, want an error", name)
+		}
+	}
+	var dest configArgs
+	if err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml"), &dest, nil); err == nil {
+		t.Error("loadConfig of a missing file succeeded, want an error")
+	}
+}
+
+func TestSetConfigValueReplacesList(t *testing.T) {
+	dest := configArgs{Exclude: []string{"tmp/"}}
+	if err := setConfigValue(reflect.ValueOf(&dest).Elem().FieldByName("Exclude"), "logs/"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"logs/"}; !reflect.DeepEqual(dest.Exclude, want) {
+		t.Errorf("Exclude = %q, want %q", dest.Exclude, want)
+	}
+}
+
+func TestGivenFlags(t *testing.T) {
+	os.Setenv("TEST_STORAGE_CLASS", "GLACIER")
+	defer os.Unsetenv("TEST_STORAGE_CLASS")
+	tests := []struct {
+		argv []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"-c", "5", "--keep=false", "src"}, []string{"concurrency", "keep"}},
+		{[]string{"-c=5"}, []string{"concurrency"}},
+		{[]string{"--concurrency=5"}, []string{"concurrency"}},
+		// The values taking the shape of flags aren't flags.
+		{[]string{"--exclude=-tmp", "--config=-c"}, []string{"config", "exclude"}},
+		{[]string{"--concurrency", "-1"}, []string{"concurrency"}},
+		{[]string{"--exclude", "a/", "b/", "--keep"}, []string{"exclude", "keep"}},
+		{[]string{"--", "--exclude"}, nil},
+	}
+	for _, test := range tests {
+		got, err := givenFlags(test.argv, &configArgs{})
+		if err != nil {
+			t.Errorf("givenFlags(%q): %v", test.argv, err)
+			continue
+		}
+		// The environment gives --storage-class.
+		want := map[string]bool{"storage-class": true}
+		for _, name := range test.want {
+			want[name] = true
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("givenFlags(%q) = %v, want %v", test.argv, got, want)
+		}
+	}
+	// The flags of the command, with the defaults, short names and positionals of go-arg.
+	dest := args
+	got, err := givenFlags([]string{"--recursive", "--exclude-prefix=-tmp/", "--retry-jitter", "0.5", "s3://bucket1/", "s3://bucket2/"}, &dest)
+	if want := map[string]bool{"recursive": true, "exclude-prefix": true, "retry-jitter": true}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("givenFlags() of the command = %v, %v, want %v", got, err, want)
+	}
+	if _, err := givenFlags([]string{"--unknown"}, &configArgs{}); err == nil {
+		t.Error("givenFlags(--unknown) succeeded, want an error")
+	}
+}
+
+func TestConfigFieldsSkipsConfig(t *testing.T) {
+	fields := configFields(&configArgs{})
+	if _, ok := fields["config"]; ok {
+		t.Error("configFields() includes --config")
+	}
+	if _, ok := fields["concurrency"]; !ok {
+		t.Error("configFields() misses --concurrency")
+	}
+}
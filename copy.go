@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// copier copies the objects with the clients and the options of the run
+// and records every result in the summary.
+type copier struct {
+	ctx            context.Context
+	svc, sourceSvc *s3.S3
+	// The clients in the regions of the buckets of --pairs with --region-map.
+	sourceRegions, destRegions *regionClients
+	// Existing destination objects, checked with HEAD requests unless prelisted.
+	index      *destIndex
+	prefixRate *prefixLimiter
+	stats      *summary
+	// The copied keys reconciled by --verify-count.
+	copied            *keySet
+	failures          *jsonLinesWriter
+	attributes        *jsonLinesWriter
+	export            *metadataExport
+	out               *output
+	hook              *webhook
+	replicas          *replicator
+	grants            objectGrants
+	lock              objectLock
+	tags              map[string]string
+	metadata          map[string]string
+	metadataFilter    map[string]string
+	metadataDirective string
+	byteRange         string
+	rangeSize         int64
+	loginfo, logerr   *log.Logger
+	// Set once a copy with tags is rejected, the later copies are tagged after copying.
+	taggingFallback int32
+}
+
+// recordFailure appends the failed object to --failures-file.
+func (c *copier) recordFailure(bucket, key, versionID string, err error) {
+	if c.failures == nil {
+		return
+	}
+	if werr := c.failures.write(newFailureRecord(bucket, key, versionID, err)); werr != nil {
+		c.logerr.Printf("Failed to write failure of object %s: %v\n", key, werr)
+	}
+}
+
+// copyObject copies the object of the task, returns false when the object failed to copy.
+func (c *copier) copyObject(sourceBucket string, task copyTask, targetBucket string) bool {
+	object, targetPath := task.object, task.targetPath
+	sourcePath := aws.StringValue(object.Key)
+	svc, sourceSvc := c.svc, c.sourceSvc
+	// fail records the failure of the object in the summary and --failures-file.
+	fail := func(err error) {
+		c.stats.failure(err)
+		c.recordFailure(sourceBucket, sourcePath, task.versionID, err)
+	}
+	// With --on-not-found skip the keys of the manifest or jobs file missing in the source are skipped.
+	skipMissing := func(err error) bool {
+		if args.OnNotFound != onNotFoundSkip || !missingSource(err) {
+			return false
+		}
+		c.stats.skip()
+		c.loginfo.Printf("Item %q doesn't exist in bucket %q, skipping\n", sourcePath, sourceBucket)
+		return true
+	}
+	if c.sourceRegions != nil {
+		// Use the clients in the regions of the buckets of the pair
+		var err error
+		if sourceSvc, err = c.sourceRegions.client(c.ctx, sourceBucket); err == nil {
+			svc, err = c.destRegions.client(c.ctx, targetBucket)
+		}
+		if err != nil {
+			c.logerr.Printf("Failed to copy object %s: %s\n", sourcePath, describeError(err))
+			fail(err)
+			return false
+		}
+	}
+	var versionID *string
+	if task.versionID != "" {
+		versionID = aws.String(task.versionID)
+	}
+	if c.prefixRate != nil {
+		if err := c.prefixRate.wait(c.ctx, sourcePath); err != nil {
+			c.logerr.Printf("Failed to copy object %s: %s\n", sourcePath, describeError(err))
+			fail(err)
+			return false
+		}
+	}
+	if args.SkipExisting {
+		existing, err := c.index.lookup(c.ctx, svc, targetBucket, strings.TrimPrefix(targetPath, "/"))
+		if err != nil {
+			c.logerr.Printf("Failed to check object %s: %s\n", targetPath, describeError(err))
+			fail(err)
+			return false
+		}
+		if existing != nil {
+			c.stats.skip()
+			c.loginfo.Printf("Item %q already exists in bucket %q, skipping\n", targetPath, targetBucket)
+			return true
+		}
+	}
+	input := &s3.CopyObjectInput{
+		CopySource:   aws.String(copySource(sourceBucket, sourcePath, task.versionID)),
+		Bucket:       aws.String(targetBucket),
+		Key:          aws.String(targetPath),
+		ACL:          aws.String(args.ACL),
+		StorageClass: aws.String(args.StorageClass),
+	}
+	c.grants.apply(input)
+	if args.Verify {
+		// The copy gets a SHA256 checksum that verifyContent compares with the source.
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	}
+	if args.PreserveStorageClass && object.StorageClass != nil {
+		class, preserved := preservedStorageClass(object.StorageClass, args.StorageClass)
+		if !preserved {
+			c.loginfo.Printf("Item %q storage class %s can't be preserved, copying as %s\n", sourcePath, aws.StringValue(object.StorageClass), class)
+		}
+		input.StorageClass = aws.String(class)
+	}
+	if task.storageClass != "" {
+		input.StorageClass = aws.String(task.storageClass)
+	}
+	if args.PreserveTimestamp || args.MergeMetadata || args.PreserveObjectLock || args.HeadBeforeCopy || c.export != nil || len(c.metadataFilter) > 0 ||
+		((args.CopyIfNewer || args.OverwriteOnlyIfOlderDest) && object.LastModified == nil) ||
+		// The tasks of a manifest, jobs, pairs or retry file have no size and ETag to verify the copy with.
+		(args.Verify && (object.Size == nil || object.ETag == nil)) {
+		head, err := sourceSvc.HeadObjectWithContext(c.ctx, &s3.HeadObjectInput{
+			Bucket:    aws.String(sourceBucket),
+			Key:       aws.String(sourcePath),
+			VersionId: versionID,
+		})
+		if err != nil && skipMissing(err) {
+			return true
+		}
+		if err != nil {
+			c.logerr.Printf("Failed to head object %s: %s\n", sourcePath, describeError(err))
+			fail(err)
+			return false
+		}
+		if !matchMetadata(head.Metadata, c.metadataFilter) {
+			c.stats.skip()
+			c.loginfo.Printf("Item %q doesn't match the metadata filter, skipping\n", sourcePath)
+			return true
+		}
+		if object.Size == nil {
+			object.Size = head.ContentLength
+		}
+		if object.LastModified == nil {
+			object.LastModified = head.LastModified
+		}
+		if object.ETag == nil {
+			object.ETag = head.ETag
+		}
+		if args.HeadBeforeCopy {
+			attrs := newObjectAttributes(sourceBucket, sourcePath, head)
+			if c.attributes != nil {
+				if err := c.attributes.write(attrs); err != nil {
+					c.logerr.Printf("Failed to write attributes of object %s: %v\n", sourcePath, err)
+				}
+			} else {
+				c.loginfo.Printf("Item %q attributes: size %d, storage class %s, ETag %s, content type %q\n",
+					sourcePath, attrs.Size, attrs.StorageClass, attrs.ETag, attrs.ContentType)
+			}
+		}
+		if c.export != nil {
+			if err := c.export.write(newObjectAttributes(sourceBucket, sourcePath, head), head.Metadata); err != nil {
+				c.logerr.Printf("Failed to c.export metadata of object %s: %v\n", sourcePath, err)
+			}
+		}
+		if args.PreserveTimestamp || args.MergeMetadata {
+			// Carry over the source metadata, since REPLACE drops everything not specified.
+			replaceMetadata(input, head)
+		}
+		if args.PreserveTimestamp {
+			// LastModified can't be set on the copy, so keep it in the user metadata.
+			lastModified := object.LastModified
+			if lastModified == nil {
+				lastModified = head.LastModified
+			}
+			setMetadata(input.Metadata, originalLastModifiedKey, aws.TimeValue(lastModified).UTC().Format(time.RFC3339))
+		}
+		if args.PreserveObjectLock {
+			applySourceObjectLock(input, head)
+		}
+	}
+	c.lock.apply(input)
+	if args.ListOnly {
+		c.stats.skip()
+		if c.export != nil {
+			return true
+		}
+		if c.out.text() {
+			c.loginfo.Printf("Item %q of %d bytes listed, not copying\n", sourcePath, aws.Int64Value(object.Size))
+			return true
+		}
+		var lastModified interface{}
+		if object.LastModified != nil {
+			lastModified = object.LastModified.UTC().Format(time.RFC3339)
+		}
+		err := c.out.write([]string{"key", "size", "last_modified", "storage_class"},
+			sourcePath, aws.Int64Value(object.Size), lastModified, aws.StringValue(object.StorageClass))
+		if err != nil {
+			c.logerr.Printf("Failed to write the listing: %v\n", err)
+		}
+		return true
+	}
+	if args.CopyIfNewer {
+		existing, err := c.index.lookup(c.ctx, svc, targetBucket, strings.TrimPrefix(targetPath, "/"))
+		if err != nil {
+			c.logerr.Printf("Failed to check object %s: %s\n", targetPath, describeError(err))
+			fail(err)
+			return false
+		}
+		if !isNewer(object, existing, args.NewerGrace) {
+			c.stats.skip()
+			c.loginfo.Printf("Item %q is not newer than the destination object %q, skipping\n", sourcePath, targetPath)
+			return true
+		}
+	}
+	if args.OverwriteOnlyIfOlderDest {
+		existing, err := c.index.lookup(c.ctx, svc, targetBucket, strings.TrimPrefix(targetPath, "/"))
+		if err != nil {
+			c.logerr.Printf("Failed to check object %s: %s\n", targetPath, describeError(err))
+			fail(err)
+			return false
+		}
+		if destNewer(object, existing) {
+			c.stats.skip()
+			c.loginfo.Printf("Item %q is older than the destination object %q, skipping\n", sourcePath, targetPath)
+			return true
+		}
+	}
+	if args.SkipIfDestMatches {
+		existing, err := c.index.lookup(c.ctx, svc, targetBucket, strings.TrimPrefix(targetPath, "/"))
+		if err != nil {
+			c.logerr.Printf("Failed to check object %s: %s\n", targetPath, describeError(err))
+			fail(err)
+			return false
+		}
+		if existing != nil && existing.ETag != nil {
+			// S3 refuses the copy when the source still has the ETag of the destination.
+			input.CopySourceIfNoneMatch = existing.ETag
+		}
+	}
+	objectTags := c.tags
+	if len(task.tags) > 0 {
+		objectTags = overrideTags(c.tags, task.tags)
+	}
+	// Tags set with a PutObjectTagging request after the copy.
+	var tagging, afterTags map[string]string
+	if len(objectTags) > 0 {
+		tagging = objectTags
+		if args.CopyTags {
+			out, err := sourceSvc.GetObjectTaggingWithContext(c.ctx, &s3.GetObjectTaggingInput{
+				Bucket:    aws.String(sourceBucket),
+				Key:       aws.String(sourcePath),
+				VersionId: versionID,
+			})
+			if err != nil {
+				c.logerr.Printf("Failed to get tags of object %s: %s\n", sourcePath, describeError(err))
+				fail(err)
+				return false
+			}
+			tagging = mergeTags(out.TagSet, objectTags)
+		}
+		if args.TagAfterCopy || atomic.LoadInt32(&c.taggingFallback) == 1 {
+			afterTags = tagging
+		} else {
+			input.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+			input.Tagging = aws.String(encodeTags(tagging))
+		}
+	}
+	if c.metadataDirective == s3.MetadataDirectiveReplace {
+		input.MetadataDirective = aws.String(s3.MetadataDirectiveReplace)
+		applyMetadataOverrides(input, c.metadata)
+	}
+	// Copy the item from the source bucket to the destination bucket.
+	// ETag of the copied object, checked by --verify.
+	var etag *string
+	runCopy := func() error {
+		if c.byteRange != "" {
+			object.Size = aws.Int64(c.rangeSize)
+			return copyObjectRange(c.ctx, svc, input, c.byteRange)
+		}
+		out, err := svc.CopyObjectWithContext(c.ctx, input)
+		if err == nil && out.CopyObjectResult != nil {
+			etag = out.CopyObjectResult.ETag
+		}
+		return err
+	}
+	copyStart := time.Now()
+	err := runCopy()
+	if err != nil && input.Tagging != nil && taggingUnsupported(err) {
+		// The backend rejects the tags of the copy, tag the copies separately from now on
+		if atomic.CompareAndSwapInt32(&c.taggingFallback, 0, 1) {
+			c.logerr.Printf("Destination doesn't support tagging in CopyObject (%s), tagging the copies after copying\n", describeError(err))
+		}
+		input.Tagging, input.TaggingDirective = nil, nil
+		afterTags = tagging
+		err = runCopy()
+	}
+	if elapsed := time.Since(copyStart); args.SlowObjectWarn > 0 && elapsed > args.SlowObjectWarn {
+		c.logerr.Printf("Warning: copy of object %s (%d bytes) took %s, longer than --slow-object-warn %s\n",
+			sourcePath, aws.Int64Value(object.Size), elapsed.Round(time.Millisecond), args.SlowObjectWarn)
+	}
+	if err != nil && input.CopySourceIfNoneMatch != nil && preconditionFailed(err) {
+		c.stats.skip()
+		c.loginfo.Printf("Item %q matches the destination object %q, skipping\n", sourcePath, targetPath)
+		return true
+	}
+	if err != nil && skipMissing(err) {
+		return true
+	}
+	if err != nil {
+		c.logerr.Printf("Failed to copy object %s: %s\n", sourcePath, describeError(err))
+		if copyTooLarge(err) {
+			c.logerr.Println(copyTooLargeGuidance(sourcePath, aws.Int64Value(object.Size)))
+		}
+		if kmsAccessDenied(err) {
+			c.logerr.Println(kmsGuidance(sourcePath, ""))
+		}
+		fail(err)
+		return false
+	}
+	// Wait for the item to be copied
+	if args.Wait {
+		head := &s3.HeadObjectInput{
+			Bucket: aws.String(targetBucket),
+			Key:    aws.String(targetPath),
+		}
+		if args.WaitMode == waitModeHead {
+			_, err = svc.HeadObjectWithContext(c.ctx, head)
+		} else {
+			err = svc.WaitUntilObjectExistsWithContext(c.ctx, head,
+				request.WithWaiterDelay(request.ConstantWaiterDelay(args.WaitInterval)),
+				request.WithWaiterMaxAttempts(args.WaitMaxAttempts))
+		}
+		if err != nil {
+			c.logerr.Printf("Failed to wait for object %s: %s\n", targetPath, describeError(err))
+			fail(err)
+			return false
+		}
+	}
+	if afterTags != nil {
+		_, err = svc.PutObjectTaggingWithContext(c.ctx, &s3.PutObjectTaggingInput{
+			Bucket:  aws.String(targetBucket),
+			Key:     aws.String(targetPath),
+			Tagging: &s3.Tagging{TagSet: tagSet(afterTags)},
+		})
+		if err != nil {
+			c.logerr.Printf("Failed to tag object %s: %s\n", targetPath, describeError(err))
+			fail(err)
+			return false
+		}
+	}
+	// Move mode: copy, then verify, then delete the source.
+	verified := true
+	if args.Verify {
+		dest, err := svc.HeadObjectWithContext(c.ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(targetBucket),
+			Key:    aws.String(targetPath),
+		})
+		if err == nil {
+			err = verifyCopy(object, etag, dest)
+		}
+		if err == nil && object.ETag != nil && c.byteRange == "" {
+			err = verifyContent(c.ctx, sourceSvc, svc,
+				&s3.GetObjectAttributesInput{Bucket: aws.String(sourceBucket), Key: aws.String(sourcePath), VersionId: versionID},
+				&s3.GetObjectAttributesInput{Bucket: aws.String(targetBucket), Key: aws.String(targetPath)},
+				aws.StringValue(object.ETag), dest)
+		}
+		// A copy verified only by its size is reported copied, but a move keeps its source.
+		unverifiable := errors.Is(err, errUnverifiable)
+		if unverifiable && !args.DeleteSource {
+			c.logerr.Printf("Item %q is verified only by its size: %v\n", targetPath, err)
+			err = nil
+		}
+		if err != nil {
+			c.logerr.Printf("Failed to verify object %s: %s\n", targetPath, describeError(err))
+			fail(err)
+			if !args.DeleteSource || args.KeepSourceOnVerifyFail || unverifiable {
+				return false
+			}
+			verified = false
+		}
+	}
+	if args.DeleteSource {
+		// The copied version is deleted, not the current version of the key.
+		_, err = sourceSvc.DeleteObjectWithContext(c.ctx, &s3.DeleteObjectInput{
+			Bucket:    aws.String(sourceBucket),
+			Key:       aws.String(sourcePath),
+			VersionId: versionID,
+		})
+		switch {
+		case err != nil && missingSource(err):
+			// Deleted by another process since the copy, e.g. a concurrent move, the source is gone either way.
+			c.loginfo.Printf("Item %q was already deleted from bucket %q\n", sourcePath, sourceBucket)
+		case err != nil:
+			c.logerr.Printf("Failed to delete source object %s: %s\n", sourcePath, describeError(err))
+			if verified {
+				// The failed verify is already recorded, the object counts once.
+				fail(err)
+			}
+			return false
+		default:
+			c.loginfo.Printf("Item %q deleted from bucket %q\n", sourcePath, sourceBucket)
+		}
+	}
+	if !verified {
+		return false
+	}
+	if c.replicas != nil {
+		c.replicas.add(input, object.Size, afterTags, func(err error) {
+			c.recordFailure(sourceBucket, sourcePath, task.versionID, err)
+			if args.SummaryJSON != "" {
+				c.stats.failedKey(sourceBucket, sourcePath, task.versionID)
+			}
+		})
+	}
+	if c.hook != nil {
+		event := webhookEvent{
+			Source:    "s3://" + sourceBucket + "/" + sourcePath,
+			Target:    "s3://" + targetBucket + "/" + strings.TrimPrefix(targetPath, "/"),
+			Bytes:     aws.Int64Value(object.Size),
+			Timestamp: time.Now().UTC(),
+		}
+		if args.WebhookRequired {
+			if err := c.hook.send(c.ctx, event); err != nil {
+				c.logerr.Printf("Failed to notify the webhook of object %s: %v\n", targetPath, err)
+				fail(err)
+				return false
+			}
+		} else {
+			c.hook.notify(event, func(err error) {
+				c.logerr.Printf("Failed to notify the webhook of object %s: %v\n", targetPath, err)
+			})
+		}
+	}
+	c.stats.success(object.Size)
+	if args.VerifyCount {
+		c.copied.add(strings.TrimPrefix(targetPath, "/"))
+	}
+	c.loginfo.Printf("Item %q successfully copied from bucket %q to bucket %q\n", sourcePath, sourceBucket, targetBucket)
+	return true
+}
+
+// copyHistory copies the versions of the key oldest first, so the destination
+// gets them in the same order, and recreates the listed delete markers with a
+// DeleteObject. A failure stops the history, so no later version is copied out of order,
+// and the ID of the failed version is returned with false.
+func (c *copier) copyHistory(sourceBucket string, task copyTask, targetBucket string) (string, bool) {
+	for _, v := range task.versions {
+		if v.deleteMarker {
+			_, err := c.svc.DeleteObjectWithContext(c.ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(targetBucket),
+				Key:    aws.String(task.targetPath),
+			})
+			if err != nil {
+				c.logerr.Printf("Failed to recreate delete marker of %s: %s\n", task.targetPath, describeError(err))
+				c.stats.failure(err)
+				c.recordFailure(sourceBucket, aws.StringValue(v.object.Key), v.versionID, err)
+				return v.versionID, false
+			}
+			c.loginfo.Printf("Delete marker %q of item %q recreated in bucket %q\n", v.versionID, task.targetPath, targetBucket)
+			continue
+		}
+		version := task
+		version.object, version.versionID, version.versions = v.object, v.versionID, nil
+		if !c.copyObject(sourceBucket, version, targetBucket) {
+			return v.versionID, false
+		}
+	}
+	return "", true
+}
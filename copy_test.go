@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// newTestCopier returns a copier of the client with the text output,
+// logging to the returned buffer.
+func newTestCopier(t *testing.T, svc *s3.S3) (*copier, *bytes.Buffer) {
+	t.Helper()
+	var logs bytes.Buffer
+	out, err := newOutput(formatText, &logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &copier{
+		ctx:               context.Background(),
+		svc:               svc,
+		sourceSvc:         svc,
+		index:             &destIndex{},
+		stats:             &summary{},
+		copied:            &keySet{},
+		out:               out,
+		metadataDirective: s3.MetadataDirectiveCopy,
+		loginfo:           log.New(&logs, "", 0),
+		logerr:            log.New(&logs, "", 0),
+	}, &logs
+}
+
+// listedTask returns the task of the object with the fields of its listing.
+func listedTask(f *fakeS3, bucket, key, targetPath string) copyTask {
+	o := f.buckets[bucket][key]
+	return copyTask{
+		object:     &s3.Object{Key: aws.String(key), Size: aws.Int64(o.size), ETag: aws.String(o.etag), LastModified: aws.Time(o.modified)},
+		targetPath: targetPath,
+	}
+}
+
+func TestCopierMove(t *testing.T) {
+	parseArgs(t, "--verify", "--delete-source")
+	fake, svc := newFakeS3(t)
+	fake.put("src", "a", 10)
+	fake.put("dst", "other", 1)
+	c, logs := newTestCopier(t, svc)
+
+	if !c.copyObject("src", listedTask(fake, "src", "a", "backup/a"), "dst") {
+		t.Fatalf("copyObject() failed: %s", logs)
+	}
+	if want := []string{"COPY backup/a", "HEAD backup/a", "HEAD a", "DELETE a"}; !reflect.DeepEqual(fake.requests, want) {
+		t.Errorf("requests = %q, want %q", fake.requests, want)
+	}
+	if _, ok := fake.buckets["dst"]["backup/a"]; !ok {
+		t.Error("backup/a wasn't copied")
+	}
+	if _, ok := fake.buckets["src"]["a"]; ok {
+		t.Error("the source of the verified copy wasn't deleted")
+	}
+	if c.stats.copied != 1 || c.stats.bytes != 10 || c.stats.failed != 0 {
+		t.Errorf("summary = %d copied (%d bytes), %d failed, want 1 (10 bytes), 0", c.stats.copied, c.stats.bytes, c.stats.failed)
+	}
+}
+
+func TestCopierMoveVersion(t *testing.T) {
+	parseArgs(t, "--delete-source")
+	fake, svc := newFakeS3(t)
+	fake.put("src", "a", 10)
+	fake.put("dst", "other", 1)
+	c, logs := newTestCopier(t, svc)
+
+	// A versioned manifest task deletes the copied version, not the current one.
+	if !c.copyObject("src", copyTask{object: &s3.Object{Key: aws.String("a")}, targetPath: "a", versionID: "v1"}, "dst") {
+		t.Fatalf("copyObject() failed: %s", logs)
+	}
+	if want := []string{"COPY a", "DELETE a?versionId=v1"}; !reflect.DeepEqual(fake.requests, want) {
+		t.Errorf("requests = %q, want %q", fake.requests, want)
+	}
+}
+
+func TestCopierMoveVerifyFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		argv    []string
+		corrupt bool
+		// sse is the encryption of the source, SSE-KMS leaves the copy unverifiable.
+		sse     string
+		deleted bool
+	}{
+		{"corrupt copy", nil, true, "", false},
+		{"corrupt copy with --keep-source-on-verify-fail=false", []string{"--keep-source-on-verify-fail=false"}, true, "", true},
+		{"unverifiable copy", nil, false, "aws:kms", false},
+		{"unverifiable copy with --keep-source-on-verify-fail=false", []string{"--keep-source-on-verify-fail=false"}, false, "aws:kms", false},
+	}
+	for _, test := range tests {
+		parseArgs(t, append([]string{"--verify", "--delete-source"}, test.argv...)...)
+		fake, svc := newFakeS3(t)
+		fake.put("src", "a", 10)
+		fake.buckets["src"]["a"].sse = test.sse
+		fake.put("dst", "other", 1)
+		fake.corruptCopies = test.corrupt
+		c, logs := newTestCopier(t, svc)
+
+		// A manifest task has only the key, the size and ETag come from the source.
+		if c.copyObject("src", copyTask{object: &s3.Object{Key: aws.String("a")}, targetPath: "a"}, "dst") {
+			t.Errorf("%s: copyObject() succeeded", test.name)
+		}
+		if !strings.Contains(logs.String(), "Failed to verify object a") {
+			t.Errorf("%s: logs = %q, want the failed verify", test.name, logs)
+		}
+		if _, ok := fake.buckets["src"]["a"]; ok == test.deleted {
+			t.Errorf("%s: source deleted = %t, want %t", test.name, !ok, test.deleted)
+		}
+		if c.stats.failed != 1 || c.stats.copied != 0 {
+			t.Errorf("%s: summary = %d copied, %d failed, want 0 and 1", test.name, c.stats.copied, c.stats.failed)
+		}
+	}
+}
+
+func TestCopierSkipChecks(t *testing.T) {
+	tests := []struct {
+		argv []string
+		// dest is the modification time of the destination object, none when zero.
+		dest   time.Time
+		copied bool
+	}{
+		{[]string{"--skip-existing"}, time.Time{}, true},
+		{[]string{"--skip-existing"}, time.Unix(1500000000, 0), false},
+		{[]string{"--copy-if-newer"}, time.Unix(1500000000, 0), true},
+		{[]string{"--copy-if-newer"}, time.Unix(1700000000, 0), false},
+		{[]string{"--overwrite-only-if-older-dest"}, time.Unix(1500000000, 0), true},
+		{[]string{"--overwrite-only-if-older-dest"}, time.Unix(1700000000, 0), false},
+		{[]string{"--skip-if-dest-matches"}, time.Unix(1500000000, 0), false},
+	}
+	for _, test := range tests {
+		parseArgs(t, test.argv...)
+		fake, svc := newFakeS3(t)
+		fake.put("src", "a", 10)
+		fake.put("dst", "other", 1)
+		if !test.dest.IsZero() {
+			// Same content as the source, so --skip-if-dest-matches finds the same ETag.
+			fake.put("dst", "a", 10)
+			fake.buckets["dst"]["a"].modified = test.dest
+		}
+		c, logs := newTestCopier(t, svc)
+		if !c.copyObject("src", listedTask(fake, "src", "a", "a"), "dst") {
+			t.Errorf("copyObject() with %q failed: %s", test.argv, logs)
+			continue
+		}
+		copies := 0
+		for _, r := range fake.requests {
+			if r == "COPY a" {
+				copies++
+			}
+		}
+		switch {
+		case test.copied && (c.stats.copied != 1 || copies != 1):
+			t.Errorf("copyObject() with %q and the destination of %s = %d copied with %d copies, want the copy", test.argv, test.dest, c.stats.copied, copies)
+		case !test.copied && (c.stats.skipped != 1 || c.stats.copied != 0):
+			t.Errorf("copyObject() with %q and the destination of %s = %d copied, %d skipped, want skipped", test.argv, test.dest, c.stats.copied, c.stats.skipped)
+		}
+	}
+}
+
+func TestCopierTagging(t *testing.T) {
+	tests := []struct {
+		name          string
+		argv          []string
+		noCopyTagging bool
+		// requests are the requests of the second copy.
+		requests []string
+	}{
+		{"copy with tags", nil, false, []string{"COPY b"}},
+		{"--tag-after-copy", []string{"--tag-after-copy"}, false, []string{"COPY b", "TAGGING b"}},
+		{"rejected copy with tags", nil, true, []string{"COPY b", "TAGGING b"}},
+	}
+	for _, test := range tests {
+		parseArgs(t, test.argv...)
+		fake, svc := newFakeS3(t)
+		fake.put("src", "a", 10)
+		fake.put("src", "b", 20)
+		fake.put("dst", "other", 1)
+		fake.noCopyTagging = test.noCopyTagging
+		c, logs := newTestCopier(t, svc)
+		c.tags = map[string]string{"team": "data"}
+
+		for _, key := range []string{"a", "b"} {
+			fake.requests = nil
+			if !c.copyObject("src", listedTask(fake, "src", key, key), "dst") {
+				t.Errorf("%s: copyObject(%s) failed: %s", test.name, key, logs)
+			}
+			if o := fake.buckets["dst"][key]; o == nil || o.tags != "team=data" {
+				t.Errorf("%s: copy of %s isn't tagged", test.name, key)
+			}
+		}
+		// The rejected copy falls back to tagging after copying, the next copy tags after copying right away.
+		if !reflect.DeepEqual(fake.requests, test.requests) {
+			t.Errorf("%s: requests = %q, want %q", test.name, fake.requests, test.requests)
+		}
+		if test.noCopyTagging && !strings.Contains(logs.String(), "tagging the copies after copying") {
+			t.Errorf("%s: logs = %q, want the fallback", test.name, logs)
+		}
+	}
+}
+
+func TestCopierPreserveTimestamp(t *testing.T) {
+	parseArgs(t, "--preserve-timestamp")
+	fake, svc := newFakeS3(t)
+	fake.put("src", "a", 10)
+	fake.buckets["src"]["a"].metadata = map[string]string{"owner": "ops"}
+	fake.put("dst", "other", 1)
+	c, logs := newTestCopier(t, svc)
+	directive, _, err := resolveMetadataDirective(args.MetadataDirective)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.metadataDirective = directive
+
+	task := listedTask(fake, "src", "a", "a")
+	// The listing wins over the HEAD of the source.
+	task.object.LastModified = aws.Time(time.Unix(1500000000, 0))
+	if !c.copyObject("src", task, "dst") {
+		t.Fatalf("copyObject() failed: %s", logs)
+	}
+	copied := fake.buckets["dst"]["a"]
+	if want := task.object.LastModified.UTC().Format(time.RFC3339); copied.metadata[originalLastModifiedKey] != want {
+		t.Errorf("%s = %q, want the listed LastModified %q", originalLastModifiedKey, copied.metadata[originalLastModifiedKey], want)
+	}
+	if copied.metadata["owner"] != "ops" {
+		t.Errorf("metadata = %v, want the source metadata kept", copied.metadata)
+	}
+}
+
+func TestCopierReplica(t *testing.T) {
+	for _, argv := range [][]string{nil, {"--tag-after-copy"}} {
+		parseArgs(t, argv...)
+		fake, svc := newFakeS3(t)
+		fake.put("src", "a", 10)
+		fake.put("dst", "other", 1)
+		fake.put("replica", "other", 1)
+		c, logs := newTestCopier(t, svc)
+		c.tags = map[string]string{"team": "data"}
+		c.replicas = newReplicator(context.Background(), svc, "replica", 1, c.loginfo, c.logerr)
+
+		if !c.copyObject("src", listedTask(fake, "src", "a", "backup/a"), "dst") {
+			t.Fatalf("copyObject() with %q failed: %s", argv, logs)
+		}
+		c.replicas.wait()
+		if o := fake.buckets["replica"]["backup/a"]; o == nil || o.tags != "team=data" {
+			t.Errorf("backup/a wasn't replicated with its tags with %q: %s", argv, logs)
+		}
+		if c.stats.copied != 1 || c.replicas.stats.copied != 1 {
+			t.Errorf("summaries with %q = %d copied, %d replicated, want 1 and 1", argv, c.stats.copied, c.replicas.stats.copied)
+		}
+	}
+}
+
+func TestCopierReplicaFailure(t *testing.T) {
+	parseArgs(t, "--summary-json", "summary.json")
+	fake, svc := newFakeS3(t)
+	fake.put("src", "a", 10)
+	fake.put("dst", "other", 1)
+	c, logs := newTestCopier(t, svc)
+	var failures bytes.Buffer
+	c.failures = newJSONLinesWriter(&failures)
+	// The replica bucket doesn't exist.
+	c.replicas = newReplicator(context.Background(), svc, "replica", 1, c.loginfo, c.logerr)
+
+	if !c.copyObject("src", listedTask(fake, "src", "a", "backup/a"), "dst") {
+		t.Fatalf("copyObject() failed: %s", logs)
+	}
+	c.replicas.wait()
+	if c.replicas.stats.failed != 1 {
+		t.Errorf("replica summary = %d failed, want 1", c.replicas.stats.failed)
+	}
+	if !strings.Contains(failures.String(), `"key":"a"`) {
+		t.Errorf("failures = %q, want the source of the failed replica", failures.String())
+	}
+	if want := []failedObject{{Bucket: "src", Key: "a"}}; !reflect.DeepEqual(c.stats.objects, want) {
+		t.Errorf("failed objects = %v, want %v", c.stats.objects, want)
+	}
+}
+
+func TestCopierFailures(t *testing.T) {
+	parseArgs(t)
+	fake, svc := newFakeS3(t)
+	fake.put("src", "a", 10)
+	fake.put("dst", "other", 1)
+	c, _ := newTestCopier(t, svc)
+	var failures bytes.Buffer
+	c.failures = newJSONLinesWriter(&failures)
+
+	if c.copyObject("src", copyTask{object: &s3.Object{Key: aws.String("missing")}, targetPath: "missing"}, "dst") {
+		t.Error("copyObject() of a missing source succeeded")
+	}
+	if c.stats.failed != 1 || c.stats.failures[categoryNotFound] != 1 {
+		t.Errorf("summary = %d failed (%v), want 1 not found", c.stats.failed, c.stats.failures)
+	}
+	if !strings.Contains(failures.String(), `"key":"missing"`) {
+		t.Errorf("failures = %q, want the missing key", failures.String())
+	}
+
+	parseArgs(t, "--on-not-found", "skip")
+	failures.Reset()
+	if !c.copyObject("src", copyTask{object: &s3.Object{Key: aws.String("missing")}, targetPath: "missing"}, "dst") {
+		t.Error("copyObject() of a missing source with --on-not-found skip failed")
+	}
+	if c.stats.skipped != 1 || c.stats.failed != 1 || failures.Len() != 0 {
+		t.Errorf("summary = %d skipped, %d failed, failures %q, want the missing key skipped", c.stats.skipped, c.stats.failed, failures.String())
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Actions of the --dry-run-diff plan.
+const (
+	planNew       = "NEW"
+	planOverwrite = "OVERWRITE"
+	planUnchanged = "UNCHANGED"
+)
+
+// planAction classifies the source object against the existing destination
+// object, which is nil when the destination doesn't have it yet.
+func planAction(source, dest *s3.Object) string {
+	if dest == nil {
+		return planNew
+	}
+	if aws.Int64Value(source.Size) != aws.Int64Value(dest.Size) ||
+		strings.Trim(aws.StringValue(source.ETag), `"`) != strings.Trim(aws.StringValue(dest.ETag), `"`) {
+		return planOverwrite
+	}
+	return planUnchanged
+}
+
+// isNewer reports whether the source object was modified after the existing
+// destination object by more than the grace period, which absorbs the clock skew.
+// A missing destination object is always behind the source.
+func isNewer(source, dest *s3.Object, grace time.Duration) bool {
+	if dest == nil {
+		return true
+	}
+	return aws.TimeValue(source.LastModified).After(aws.TimeValue(dest.LastModified).Add(grace))
+}
+
+// destNewer reports whether the existing destination object was modified after
+// the source object, e.g. updated independently since the last sync.
+// Equal times and a missing destination object are not newer.
+func destNewer(source, dest *s3.Object) bool {
+	return dest != nil && aws.TimeValue(dest.LastModified).After(aws.TimeValue(source.LastModified))
+}
+
+// listExisting lists the objects under the prefix of the bucket
+// and returns them indexed by key.
+func listExisting(ctx context.Context, svc *s3.S3, bucket, prefix string) (map[string]*s3.Object, error) {
+	existing := make(map[string]*s3.Object)
+	err := listObjects(ctx, svc, bucket, prefix, func(o *s3.Object) {
+		existing[aws.StringValue(o.Key)] = o
+	})
+	return existing, err
+}
+
+// headExisting returns the destination object as a listing entry,
+// or nil when it doesn't exist.
+func headExisting(ctx context.Context, svc *s3.S3, bucket, key string) (*s3.Object, error) {
+	head, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var rerr awserr.RequestFailure
+		if errors.As(err, &rerr) && rerr.StatusCode() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s3.Object{
+		Key:          aws.String(key),
+		Size:         head.ContentLength,
+		ETag:         head.ETag,
+		LastModified: head.LastModified,
+		StorageClass: head.StorageClass,
+	}, nil
+}
+
+// preconditionFailed reports whether the copy was refused by its precondition,
+// e.g. the CopySourceIfNoneMatch of --skip-if-dest-matches.
+func preconditionFailed(err error) bool {
+	var rerr awserr.RequestFailure
+	return errors.As(err, &rerr) && (rerr.StatusCode() == http.StatusPreconditionFailed || rerr.Code() == "PreconditionFailed")
+}
+
+// destIndex looks up the existing destination objects. Keys are found in the
+// prelisted objects, and only when the listing is incomplete (or wasn't done)
+// the missing keys are checked with a HEAD request.
+type destIndex struct {
+	objects  map[string]*s3.Object
+	complete bool
+}
+
+// lookup returns the existing destination object, or nil when it doesn't exist.
+func (d *destIndex) lookup(ctx context.Context, svc *s3.S3, bucket, key string) (*s3.Object, error) {
+	if o, ok := d.objects[key]; ok {
+		return o, nil
+	}
+	if d.complete {
+		return nil, nil
+	}
+	return headExisting(ctx, svc, bucket, key)
+}
+
+// plan counts the actions of the --dry-run-diff mode.
+type plan map[string]int
+
+// print writes the totals of the plan.
+func (p plan) print(l *log.Logger) {
+	l.Printf("Plan: %d new, %d overwrite, %d unchanged\n", p[planNew], p[planOverwrite], p[planUnchanged])
+}
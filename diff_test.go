@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestPlanAction(t *testing.T) {
+	source := &s3.Object{Size: aws.Int64(10), ETag: aws.String(`"abc"`)}
+	tests := []struct {
+		dest *s3.Object
+		want string
+	}{
+		{nil, planNew},
+		{&s3.Object{Size: aws.Int64(10), ETag: aws.String("abc")}, planUnchanged},
+		{&s3.Object{Size: aws.Int64(10), ETag: aws.String(`"abd"`)}, planOverwrite},
+		{&s3.Object{Size: aws.Int64(11), ETag: aws.String(`"abc"`)}, planOverwrite},
+	}
+	for _, test := range tests {
+		if got := planAction(source, test.dest); got != test.want {
+			t.Errorf("planAction(%v) = %s, want %s", test.dest, got, test.want)
+		}
+	}
+}
+
+func TestPlanPrint(t *testing.T) {
+	p := plan{planNew: 2, planUnchanged: 1}
+	var buf bytes.Buffer
+	p.print(log.New(&buf, "", 0))
+	if want := "Plan: 2 new, 0 overwrite, 1 unchanged\n"; buf.String() != want {
+		t.Errorf("print() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestListAndHeadExisting(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	for i, key := range []string{"backup/a", "backup/b", "backup/c", "backup/d", "other/e"} {
+		fake.put("dest", key, int64(i))
+	}
+	existing, err := listExisting(context.Background(), svc, "dest", "backup/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(existing) != 4 || aws.Int64Value(existing["backup/d"].Size) != 3 {
+		t.Errorf("listExisting() = %v, want the 4 objects of the prefix", existing)
+	}
+
+	o, err := headExisting(context.Background(), svc, "dest", "backup/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(o.Key) != "backup/b" || aws.Int64Value(o.Size) != 1 || o.LastModified == nil {
+		t.Errorf("headExisting() = %v, want backup/b of 1 byte", o)
+	}
+	o, err = headExisting(context.Background(), svc, "dest", "backup/missing")
+	if err != nil || o != nil {
+		t.Errorf("headExisting() of a missing key = %v, %v, want nil, nil", o, err)
+	}
+}
+
+func TestDestIndexLookup(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	fake.put("dest", "backup/a", 1)
+	fake.put("dest", "backup/b", 2)
+	ctx := context.Background()
+
+	index := destIndex{objects: map[string]*s3.Object{"backup/a": {Key: aws.String("backup/a")}}, complete: true}
+	for _, key := range []string{"backup/a", "backup/b"} {
+		o, err := index.lookup(ctx, svc, "dest", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (o != nil) != (key == "backup/a") {
+			t.Errorf("lookup(%s) of the complete listing = %v", key, o)
+		}
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("lookup() of the complete listing made the requests %q", fake.requests)
+	}
+
+	index.complete = false
+	o, err := index.lookup(ctx, svc, "dest", "backup/b")
+	if err != nil || aws.Int64Value(o.Size) != 2 {
+		t.Errorf("lookup(backup/b) of the incomplete listing = %v, %v, want the HEAD of backup/b", o, err)
+	}
+	if want := []string{"HEAD backup/b"}; len(fake.requests) != 1 || fake.requests[0] != want[0] {
+		t.Errorf("requests = %q, want %q", fake.requests, want)
+	}
+}
+
+func TestIsNewer(t *testing.T) {
+	base := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	source := &s3.Object{LastModified: aws.Time(base)}
+	tests := []struct {
+		dest  *s3.Object
+		grace time.Duration
+		want  bool
+	}{
+		{nil, 0, true},
+		{&s3.Object{LastModified: aws.Time(base.Add(-time.Minute))}, 0, true},
+		{&s3.Object{LastModified: aws.Time(base.Add(-time.Minute))}, 2 * time.Minute, false},
+		{&s3.Object{LastModified: aws.Time(base)}, 0, false},
+		{&s3.Object{LastModified: aws.Time(base.Add(time.Minute))}, 0, false},
+	}
+	for _, test := range tests {
+		if got := isNewer(source, test.dest, test.grace); got != test.want {
+			t.Errorf("isNewer(%v, %s) = %v, want %v", test.dest, test.grace, got, test.want)
+		}
+	}
+}
+
+func TestDestNewer(t *testing.T) {
+	base := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	source := &s3.Object{LastModified: aws.Time(base)}
+	tests := []struct {
+		dest *s3.Object
+		want bool
+	}{
+		{nil, false},
+		{&s3.Object{LastModified: aws.Time(base.Add(-time.Minute))}, false},
+		{&s3.Object{LastModified: aws.Time(base)}, false},
+		{&s3.Object{LastModified: aws.Time(base.Add(time.Second))}, true},
+	}
+	for _, test := range tests {
+		if got := destNewer(source, test.dest); got != test.want {
+			t.Errorf("destNewer(%v) = %v, want %v", test.dest, got, test.want)
+		}
+	}
+}
+
+func TestPreconditionFailed(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	fake.put("src", "a", 1)
+	fake.put("dst", "other", 1)
+	_, err := svc.CopyObjectWithContext(context.Background(), &s3.CopyObjectInput{
+		Bucket:            aws.String("dst"),
+		Key:               aws.String("a"),
+		CopySource:        aws.String(copySource("src", "a", "")),
+		CopySourceIfMatch: aws.String(`"stale"`),
+	})
+	if !preconditionFailed(err) {
+		t.Errorf("preconditionFailed(%v) = false, want true", err)
+	}
+	tests := []error{
+		nil,
+		errors.New("PreconditionFailed"),
+		awserr.NewRequestFailure(awserr.New("NoSuchKey", "missing", nil), http.StatusNotFound, "REQ"),
+	}
+	for _, err := range tests {
+		if preconditionFailed(err) {
+			t.Errorf("preconditionFailed(%v) = true, want false", err)
+		}
+	}
+	if err := awserr.NewRequestFailure(awserr.New("UnknownError", "", nil), http.StatusPreconditionFailed, "REQ"); !preconditionFailed(err) {
+		t.Errorf("preconditionFailed(%v) of the 412 status = false, want true", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// countEstimateWindow is how far back the NumberOfObjects datapoints are looked up,
+// S3 publishes the storage metrics once a day and up to two days late.
+const countEstimateWindow = 3 * 24 * time.Hour
+
+// objectCountEstimate returns the object count of the latest datapoint,
+// or false when there is none.
+func objectCountEstimate(datapoints []*cloudwatch.Datapoint) (int64, bool) {
+	var latest *cloudwatch.Datapoint
+	for _, d := range datapoints {
+		if d.Maximum != nil && (latest == nil || aws.TimeValue(d.Timestamp).After(aws.TimeValue(latest.Timestamp))) {
+			latest = d
+		}
+	}
+	if latest == nil {
+		return 0, false
+	}
+	return int64(aws.Float64Value(latest.Maximum)), true
+}
+
+// estimateObjectCount returns the object count of the whole bucket from its daily
+// NumberOfObjects metric in CloudWatch, which costs a single request instead of a
+// full listing. It returns false when the bucket has no datapoints yet.
+func estimateObjectCount(ctx context.Context, sess *session.Session, svc *s3.S3, roleARN, bucket string) (int64, bool, error) {
+	// The storage metrics are published in the region of the bucket.
+	location, err := svc.GetBucketLocationWithContext(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return 0, false, err
+	}
+	cfg := &aws.Config{Region: aws.String(s3.NormalizeBucketLocation(aws.StringValue(location.LocationConstraint)))}
+	if roleARN != "" {
+		cfg.Credentials = stscreds.NewCredentials(sess, roleARN)
+	}
+	now := time.Now()
+	out, err := cloudwatch.New(sess, cfg).GetMetricStatisticsWithContext(ctx, &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/S3"),
+		MetricName: aws.String("NumberOfObjects"),
+		Dimensions: []*cloudwatch.Dimension{
+			{Name: aws.String("BucketName"), Value: aws.String(bucket)},
+			{Name: aws.String("StorageType"), Value: aws.String("AllStorageTypes")},
+		},
+		StartTime:  aws.Time(now.Add(-countEstimateWindow)),
+		EndTime:    aws.Time(now),
+		Period:     aws.Int64(int64((24 * time.Hour).Seconds())),
+		Statistics: []*string{aws.String(cloudwatch.StatisticMaximum)},
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	count, ok := objectCountEstimate(out.Datapoints)
+	return count, ok, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+func TestObjectCountEstimate(t *testing.T) {
+	base := time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC)
+	datapoints := []*cloudwatch.Datapoint{
+		{Timestamp: aws.Time(base), Maximum: aws.Float64(100)},
+		{Timestamp: aws.Time(base.Add(48 * time.Hour))},
+		{Timestamp: aws.Time(base.Add(24 * time.Hour)), Maximum: aws.Float64(120)},
+	}
+	if count, ok := objectCountEstimate(datapoints); !ok || count != 120 {
+		t.Errorf("objectCountEstimate() = %d, %v, want the latest count 120", count, ok)
+	}
+	if count, ok := objectCountEstimate(datapoints[1:2]); ok {
+		t.Errorf("objectCountEstimate() without a maximum = %d, want none", count)
+	}
+	if count, ok := objectCountEstimate(nil); ok {
+		t.Errorf("objectCountEstimate() without datapoints = %d, want none", count)
+	}
+}
+
+func TestEstimateObjectCount(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	fake.put("bucket1", "a", 1)
+	fake.put("bucket2", "a", 1)
+	fake.objectCounts["bucket1"] = 1500
+	sess, err := session.NewSession(&svc.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	count, ok, err := estimateObjectCount(ctx, sess, svc, "", "bucket1")
+	if err != nil || !ok || count != 1500 {
+		t.Errorf("estimateObjectCount(bucket1) = %d, %v, %v, want 1500", count, ok, err)
+	}
+	if count, ok, err := estimateObjectCount(ctx, sess, svc, "", "bucket2"); err != nil || ok {
+		t.Errorf("estimateObjectCount() without a metric = %d, %v, %v, want none", count, ok, err)
+	}
+	if _, _, err := estimateObjectCount(ctx, sess, svc, "", "missing"); err == nil {
+		t.Error("estimateObjectCount() of a missing bucket succeeded, want an error")
+	}
+	want := []string{"LOCATION bucket1", "METRICS bucket1", "LOCATION bucket2", "METRICS bucket2"}
+	if !reflect.DeepEqual(fake.requests, want) {
+		t.Errorf("requests = %q, want %q", fake.requests, want)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fakeObject is an object stored by fakeS3.
+type fakeObject struct {
+	size     int64
+	etag     string
+	modified time.Time
+	sse      string
+	kmsKeyID string
+	sha256   string
+	parts    int64
+	// kmsDenied fails the GET requests as if the caller may not decrypt the object.
+	kmsDenied bool
+	metadata  map[string]string
+	// tags are the URL-encoded tags, as in the x-amz-tagging header.
+	tags string
+}
+
+// fakeS3 is an in-memory S3 endpoint with the requests of the buckets, the listings
+// and the object copies, paging the listings by pageSize keys.
+type fakeS3 struct {
+	mu         sync.Mutex
+	buckets    map[string]map[string]*fakeObject
+	versioning map[string]string
+	locations  map[string]string
+	// ownership is the ObjectOwnership of the buckets with ownership controls.
+	ownership map[string]string
+	// versionPages are the ListObjectVersions pages of the buckets, the key
+	// marker of a request is the index of its page.
+	versionPages map[string][]string
+	// objectCounts are the NumberOfObjects metrics of the buckets in CloudWatch,
+	// which shares the endpoint of the fake.
+	objectCounts map[string]float64
+	// strictDelete fails the deletes of the missing keys with NoSuchKey,
+	// as some S3-compatible stores do.
+	strictDelete bool
+	// noCopyTagging rejects the copies with tags, as some S3-compatible stores do.
+	noCopyTagging bool
+	// corruptCopies stores the copies with another content and ETag than their source.
+	corruptCopies bool
+	requests      []string
+	pageSize      int
+}
+
+// newFakeS3 starts the fake endpoint and returns a client of it.
+func newFakeS3(t *testing.T) (*fakeS3, *s3.S3) {
+	t.Helper()
+	f := &fakeS3{buckets: make(map[string]map[string]*fakeObject), versioning: make(map[string]string),
+		locations: make(map[string]string), ownership: make(map[string]string), versionPages: make(map[string][]string), objectCounts: make(map[string]float64), pageSize: 3}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:       aws.Int(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, s3.New(sess)
+}
+
+// put stores an object of the size, creating the bucket.
+func (f *fakeS3) put(bucket, key string, size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.buckets[bucket] == nil {
+		f.buckets[bucket] = make(map[string]*fakeObject)
+	}
+	f.buckets[bucket][key] = &fakeObject{size: size, etag: fmt.Sprintf(`"%032x"`, size), modified: time.Unix(1600000000, 0).UTC()}
+}
+
+// listRequests returns the number of listing requests served.
+func (f *fakeS3) listRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, "LIST ") {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := path[0], ""
+	if len(path) > 1 {
+		key = path[1]
+	}
+	q := r.URL.Query()
+	if r.Method == http.MethodPost && bucket == "" {
+		f.metrics(w, r)
+		return
+	}
+	objects, ok := f.buckets[bucket]
+	if r.Method == http.MethodPut && key == "" && len(q) == 0 {
+		body, _ := io.ReadAll(r.Body)
+		f.requests = append(f.requests, strings.TrimSpace("CREATE "+bucket+" "+string(body)))
+		if ok {
+			writeError(w, http.StatusConflict, "BucketAlreadyOwnedByYou")
+			return
+		}
+		f.buckets[bucket] = make(map[string]*fakeObject)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	_, versioning := q["versioning"]
+	_, location := q["location"]
+	_, versions := q["versions"]
+	_, attributes := q["attributes"]
+	_, ownershipControls := q["ownershipControls"]
+	_, tagging := q["tagging"]
+	switch {
+	case r.Method == http.MethodHead && key == "":
+		f.requests = append(f.requests, "HEAD "+bucket)
+	case r.Method == http.MethodGet && key == "" && versioning:
+		fmt.Fprintf(w, "<VersioningConfiguration><Status>%s</Status></VersioningConfiguration>", f.versioning[bucket])
+	case r.Method == http.MethodPut && key == "" && versioning:
+		var config struct {
+			Status string
+		}
+		xml.NewDecoder(r.Body).Decode(&config)
+		f.requests = append(f.requests, "VERSIONING "+config.Status)
+		f.versioning[bucket] = config.Status
+	case r.Method == http.MethodGet && key == "" && location:
+		f.requests = append(f.requests, "LOCATION "+bucket)
+		fmt.Fprintf(w, "<LocationConstraint>%s</LocationConstraint>", f.locations[bucket])
+	case r.Method == http.MethodGet && key == "" && ownershipControls:
+		f.requests = append(f.requests, "OWNERSHIP "+bucket)
+		ownership, ok := f.ownership[bucket]
+		if !ok {
+			writeError(w, http.StatusNotFound, "OwnershipControlsNotFoundError")
+			return
+		}
+		fmt.Fprintf(w, "<OwnershipControls><Rule><ObjectOwnership>%s</ObjectOwnership></Rule></OwnershipControls>", ownership)
+	case r.Method == http.MethodGet && key == "" && versions:
+		f.requests = append(f.requests, "VERSIONS "+q.Get("key-marker"))
+		page, _ := strconv.Atoi(q.Get("key-marker"))
+		io.WriteString(w, f.versionPages[bucket][page])
+	case r.Method == http.MethodGet && key == "":
+		f.requests = append(f.requests, "LIST "+r.URL.RawQuery)
+		f.list(w, objects, q)
+	case r.Method == http.MethodGet && key != "" && attributes:
+		f.requests = append(f.requests, "ATTRIBUTES "+key)
+		o, ok := objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		io.WriteString(w, "<GetObjectAttributesResponse>")
+		if o.sha256 != "" {
+			fmt.Fprintf(w, "<Checksum><ChecksumSHA256>%s</ChecksumSHA256></Checksum>", o.sha256)
+		}
+		if o.parts > 0 {
+			fmt.Fprintf(w, "<ObjectParts><PartsCount>%d</PartsCount></ObjectParts>", o.parts)
+		}
+		io.WriteString(w, "</GetObjectAttributesResponse>")
+	case r.Method == http.MethodPut && key != "" && tagging:
+		f.requests = append(f.requests, "TAGGING "+key)
+		o, ok := objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		var body struct {
+			TagSet []struct {
+				Key, Value string
+			} `xml:"TagSet>Tag"`
+		}
+		xml.NewDecoder(r.Body).Decode(&body)
+		tags := make(url.Values)
+		for _, tag := range body.TagSet {
+			tags.Set(tag.Key, tag.Value)
+		}
+		o.tags = tags.Encode()
+	case r.Method == http.MethodGet && key != "" && tagging:
+		f.requests = append(f.requests, "GET TAGGING "+key)
+		o, ok := objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		tags, _ := url.ParseQuery(o.tags)
+		io.WriteString(w, "<Tagging><TagSet>")
+		for k := range tags {
+			fmt.Fprintf(w, "<Tag><Key>%s</Key><Value>%s</Value></Tag>", k, tags.Get(k))
+		}
+		io.WriteString(w, "</TagSet></Tagging>")
+	case (r.Method == http.MethodHead || r.Method == http.MethodGet) && key != "":
+		f.requests = append(f.requests, r.Method+" "+key)
+		o, ok := objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(o.size, 10))
+		w.Header().Set("ETag", o.etag)
+		w.Header().Set("Last-Modified", o.modified.Format(http.TimeFormat))
+		if o.sse != "" {
+			w.Header().Set("X-Amz-Server-Side-Encryption", o.sse)
+		}
+		if o.kmsKeyID != "" {
+			w.Header().Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", o.kmsKeyID)
+		}
+		for k, v := range o.metadata {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
+		if r.Method == http.MethodGet && o.kmsDenied {
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "<Error><Code>AccessDenied</Code><Message>The ciphertext refers to a customer master key that does not exist or the caller is not allowed to use it (KMS)</Message></Error>")
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Write(make([]byte, o.size))
+		}
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.requests = append(f.requests, "COPY "+key)
+		source, _ := url.QueryUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		// The versions aren't stored, the copy of a version copies the object.
+		source = strings.SplitN(source, "?versionId=", 2)[0]
+		parts := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
+		o, ok := f.buckets[parts[0]][parts[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if match := r.Header.Get("X-Amz-Copy-Source-If-Match"); match != "" && match != o.etag {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if match := r.Header.Get("X-Amz-Copy-Source-If-None-Match"); match != "" && match == o.etag {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		copied := *o
+		if r.Header.Get("X-Amz-Metadata-Directive") == s3.MetadataDirectiveReplace {
+			copied.metadata = make(map[string]string)
+			for k := range r.Header {
+				if strings.HasPrefix(k, "X-Amz-Meta-") {
+					copied.metadata[strings.ToLower(strings.TrimPrefix(k, "X-Amz-Meta-"))] = r.Header.Get(k)
+				}
+			}
+		}
+		if r.Header.Get("X-Amz-Tagging-Directive") == s3.TaggingDirectiveReplace {
+			if f.noCopyTagging {
+				writeError(w, http.StatusNotImplemented, "NotImplemented")
+				return
+			}
+			copied.tags = r.Header.Get("X-Amz-Tagging")
+		}
+		if f.corruptCopies {
+			copied.etag = fmt.Sprintf(`"%032x"`, o.size+1)
+		}
+		objects[key] = &copied
+		fmt.Fprintf(w, "<CopyObjectResult><ETag>%s</ETag></CopyObjectResult>", copied.etag)
+	case r.Method == http.MethodDelete && key != "":
+		if version := q.Get("versionId"); version != "" {
+			f.requests = append(f.requests, "DELETE "+key+"?versionId="+version)
+		} else {
+			f.requests = append(f.requests, "DELETE "+key)
+		}
+		if _, ok := objects[key]; !ok && f.strictDelete {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// list serves a ListObjectsV2 page, rolling the keys up to the common
+// prefixes of the delimiter.
+func (f *fakeS3) list(w http.ResponseWriter, objects map[string]*fakeObject, q url.Values) {
+	type content struct {
+		Key          string
+		Size         int64
+		ETag         string
+		LastModified string
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		CommonPrefixes        []commonPrefix
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	after := q.Get("start-after") + q.Get("marker")
+	if token := q.Get("continuation-token"); token != "" {
+		after = token
+	}
+	pageSize := f.pageSize
+	if n, err := strconv.Atoi(q.Get("max-keys")); err == nil && n < pageSize {
+		pageSize = n
+	}
+	var entries []string
+	seen := make(map[string]bool)
+	for k := range objects {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				k = k[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if !seen[k] {
+			seen[k] = true
+			entries = append(entries, k)
+		}
+	}
+	sort.Strings(entries)
+	n := 0
+	for _, k := range entries {
+		if k <= after {
+			continue
+		}
+		if n == pageSize {
+			result.IsTruncated = true
+			result.NextContinuationToken = after
+			break
+		}
+		if o, ok := objects[k]; ok && (delimiter == "" || !strings.HasSuffix(k, delimiter) || k == prefix) {
+			result.Contents = append(result.Contents, content{k, o.size, o.etag, o.modified.Format(time.RFC3339), "STANDARD"})
+		} else {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{k})
+		}
+		after = k
+		n++
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+// metrics serves a CloudWatch GetMetricStatistics query of the NumberOfObjects
+// metric, with a single datapoint when the bucket has an object count.
+func (f *fakeS3) metrics(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	bucket := r.PostForm.Get("Dimensions.member.1.Value")
+	f.requests = append(f.requests, "METRICS "+bucket)
+	io.WriteString(w, "<GetMetricStatisticsResponse><GetMetricStatisticsResult><Datapoints>")
+	if count, ok := f.objectCounts[bucket]; ok {
+		fmt.Fprintf(w, "<member><Timestamp>%s</Timestamp><Maximum>%v</Maximum></member>", time.Now().UTC().Format(time.RFC3339), count)
+	}
+	io.WriteString(w, "</Datapoints><Label>NumberOfObjects</Label></GetMetricStatisticsResult></GetMetricStatisticsResponse>")
+}
+
+// writeError writes the S3 error with the request and host IDs of the S3 headers.
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("X-Amz-Request-Id", "REQ123")
+	w.Header().Set("X-Amz-Id-2", "HOST456")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
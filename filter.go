@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// filterExpr is a parsed --filter expression evaluated against the listed objects.
+type filterExpr interface {
+	match(o *s3.Object) bool
+}
+
+type andExpr struct{ left, right filterExpr }
+
+func (e andExpr) match(o *s3.Object) bool { return e.left.match(o) && e.right.match(o) }
+
+type orExpr struct{ left, right filterExpr }
+
+func (e orExpr) match(o *s3.Object) bool { return e.left.match(o) || e.right.match(o) }
+
+type notExpr struct{ expr filterExpr }
+
+func (e notExpr) match(o *s3.Object) bool { return !e.expr.match(o) }
+
+// stringCompare compares the key, storageClass, etag or owner of the object.
+type stringCompare struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (e stringCompare) match(o *s3.Object) bool {
+	var v string
+	switch e.field {
+	case "key":
+		v = aws.StringValue(o.Key)
+	case "storageClass":
+		v = aws.StringValue(o.StorageClass)
+		if v == "" {
+			v = s3.StorageClassStandard
+		}
+	case "etag":
+		v = strings.Trim(aws.StringValue(o.ETag), `"`)
+	case "owner":
+		if o.Owner != nil {
+			v = aws.StringValue(o.Owner.ID)
+		}
+	}
+	switch e.op {
+	case "==":
+		return v == e.value
+	case "!=":
+		return v != e.value
+	case "~":
+		return e.re.MatchString(v)
+	case "!~":
+		return !e.re.MatchString(v)
+	}
+	return false
+}
+
+// filterFields returns the fields of the object compared by the expression.
+func filterFields(e filterExpr) map[string]bool {
+	fields := make(map[string]bool)
+	var walk func(e filterExpr)
+	walk = func(e filterExpr) {
+		switch e := e.(type) {
+		case andExpr:
+			walk(e.left)
+			walk(e.right)
+		case orExpr:
+			walk(e.left)
+			walk(e.right)
+		case notExpr:
+			walk(e.expr)
+		case stringCompare:
+			fields[e.field] = true
+		case numberCompare:
+			fields[e.field] = true
+		}
+	}
+	walk(e)
+	return fields
+}
+
+// numberCompare compares the size or the lastModified time (in Unix seconds) of the object.
+type numberCompare struct {
+	field string
+	op    string
+	value int64
+}
+
+func (e numberCompare) match(o *s3.Object) bool {
+	var v int64
+	switch e.field {
+	case "size":
+		v = aws.Int64Value(o.Size)
+	case "lastModified":
+		v = aws.TimeValue(o.LastModified).Unix()
+	}
+	switch e.op {
+	case "==":
+		return v == e.value
+	case "!=":
+		return v != e.value
+	case "<":
+		return v < e.value
+	case "<=":
+		return v <= e.value
+	case ">":
+		return v > e.value
+	case ">=":
+		return v >= e.value
+	}
+	return false
+}
+
+// keyPatterns are the regular expressions of --match-regex and --reject-regex.
+type keyPatterns struct {
+	match  []*regexp.Regexp
+	reject []*regexp.Regexp
+}
+
+// compileKeyPatterns compiles the patterns once for all keys,
+// it returns nil when there are none.
+func compileKeyPatterns(match, reject []string) (*keyPatterns, error) {
+	if len(match) == 0 && len(reject) == 0 {
+		return nil, nil
+	}
+	p := &keyPatterns{}
+	for _, s := range match {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --match-regex %q: %v", s, err)
+		}
+		p.match = append(p.match, re)
+	}
+	for _, s := range reject {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --reject-regex %q: %v", s, err)
+		}
+		p.reject = append(p.reject, re)
+	}
+	return p, nil
+}
+
+// allow reports whether the key is copied: it must match one of the match patterns,
+// if any, and none of the reject patterns, which take precedence.
+func (p *keyPatterns) allow(key string) bool {
+	if p == nil {
+		return true
+	}
+	for _, re := range p.reject {
+		if re.MatchString(key) {
+			return false
+		}
+	}
+	if len(p.match) == 0 {
+		return true
+	}
+	for _, re := range p.match {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// sizeUnits are the binary multipliers of the size values, e.g. 10MB.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1 << 10,
+	"KIB": 1 << 10,
+	"MB":  1 << 20,
+	"MIB": 1 << 20,
+	"GB":  1 << 30,
+	"GIB": 1 << 30,
+	"TB":  1 << 40,
+	"TIB": 1 << 40,
+}
+
+// parseFilter parses the expression of comparisons of the object fields key,
+// size, storageClass, lastModified, etag and owner (the canonical ID listed
+// with --fetch-owner) joined with &&, || and !, e.g.
+//
+//	size > 10MB && key ~ "^logs/" && storageClass == "STANDARD"
+//
+// Strings are compared with == and != or matched with the regular expressions
+// of ~ and !~, sizes and dates (2006-01-02 or RFC 3339) with ==, !=, <, <=, > and >=.
+func parseFilter(s string) (filterExpr, error) {
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+// tokenizeFilter splits the expression into the operators, parentheses,
+// quoted strings (still quoted) and bare words.
+func tokenizeFilter(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		case strings.ContainsRune("=!<>~&|", rune(c)):
+			j := i + 1
+			for j < len(s) && j-i < 2 && strings.ContainsRune("=~&|", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || strings.ContainsRune(".:-+_", rune(s[j]))) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// filterParser is a recursive descent parser of the filter tokens.
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+// next returns the next token without consuming it, or an empty string at the end.
+func (p *filterParser) next() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// take consumes and returns the next token.
+func (p *filterParser) take() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("unexpected end of the expression")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *filterParser) or() (filterExpr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == "||" {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (filterExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == "&&" {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) unary() (filterExpr, error) {
+	switch p.next() {
+	case "!":
+		p.pos++
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	case "(":
+		p.pos++
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if tok, err := p.take(); err != nil || tok != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return expr, nil
+	}
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (filterExpr, error) {
+	field, err := p.take()
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.take()
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.take()
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(value, `"`) {
+		if value, err = strconv.Unquote(value); err != nil {
+			return nil, fmt.Errorf("invalid string %s: %v", value, err)
+		}
+	}
+	switch field {
+	case "key", "storageClass", "etag", "owner":
+		e := stringCompare{field: field, op: op, value: value}
+		switch op {
+		case "==", "!=":
+		case "~", "!~":
+			if e.re, err = regexp.Compile(value); err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %v", value, err)
+			}
+		default:
+			return nil, fmt.Errorf("operator %q can't compare %s", op, field)
+		}
+		return e, nil
+	case "size", "lastModified":
+		e := numberCompare{field: field, op: op}
+		switch op {
+		case "==", "!=", "<", "<=", ">", ">=":
+		default:
+			return nil, fmt.Errorf("operator %q can't compare %s", op, field)
+		}
+		if field == "size" {
+			e.value, err = parseSize(value)
+		} else {
+			e.value, err = parseDate(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown field %q, use key, size, storageClass, lastModified, etag or owner", field)
+}
+
+// parseSize parses the size with an optional binary unit, e.g. 10MB.
+func parseSize(s string) (int64, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := sizeUnits[strings.ToUpper(s[i:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// parseDate parses the date or RFC 3339 time into Unix seconds.
+func parseDate(s string) (int64, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid date %q, use 2006-01-02 or RFC 3339", s)
+}
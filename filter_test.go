@@ -0,0 +1,187 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestTokenizeFilter(t *testing.T) {
+	got, err := tokenizeFilter(`!(size>=10MB&&key ~ "^logs/\"x\"") || lastModified < 2020-01-02T00:00:00Z`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"!", "(", "size", ">=", "10MB", "&&", "key", "~", `"^logs/\"x\""`, ")", "||", "lastModified", "<", "2020-01-02T00:00:00Z"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenizeFilter() = %q, want %q", got, want)
+	}
+	for _, s := range []string{`key == "logs`, "size > 10 ; key"} {
+		if _, err := tokenizeFilter(s); err == nil {
+			t.Errorf("tokenizeFilter(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"0":     0,
+		"100":   100,
+		"10B":   10,
+		"1KB":   1 << 10,
+		"1.5kb": 1536,
+		"10MiB": 10 << 20,
+		"2GB":   2 << 30,
+		"1TB":   1 << 40,
+	}
+	for s, want := range tests {
+		if got, err := parseSize(s); got != want || err != nil {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"10PB", "MB", "1.2.3KB"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("parseSize(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := map[string]int64{
+		"2020-01-02":                1577923200,
+		"2020-01-02T03:04:05Z":      1577934245,
+		"2020-01-02T03:04:05+01:00": 1577930645,
+	}
+	for s, want := range tests {
+		if got, err := parseDate(s); got != want || err != nil {
+			t.Errorf("parseDate(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	if _, err := parseDate("02/01/2020"); err == nil {
+		t.Error("parseDate(02/01/2020) succeeded, want an error")
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	object := &s3.Object{
+		Key:          aws.String("logs/2020/app.log"),
+		Size:         aws.Int64(20 << 20),
+		ETag:         aws.String(`"abc"`),
+		LastModified: aws.Time(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)),
+		Owner:        &s3.Owner{ID: aws.String("owner1")},
+	}
+	tests := map[string]bool{
+		`size > 10MB`:                                true,
+		`size <= 10MB`:                               false,
+		`size == 20MB && size != 1`:                  true,
+		`size >= 20MB && size < 21MB`:                true,
+		`key ~ "^logs/"`:                             true,
+		`key !~ "^logs/"`:                            false,
+		`key == "logs/2020/app.log"`:                 true,
+		`key != "logs/2020/app.log"`:                 false,
+		`storageClass == "STANDARD"`:                 true,
+		`etag == "abc"`:                              true,
+		`owner == "owner1"`:                          true,
+		`lastModified > 2020-01-01`:                  true,
+		`lastModified < 2020-06-01T00:00:01Z`:        true,
+		`!(size > 10MB)`:                             false,
+		`size < 1KB || key ~ "\\.log$"`:              true,
+		`size < 1KB || size > 1GB`:                   false,
+		`size > 1KB && (key ~ "x" || etag == "abc")`: true,
+		`!key ~ "x" && size > 1`:                     true,
+	}
+	for s, want := range tests {
+		expr, err := parseFilter(s)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", s, err)
+			continue
+		}
+		if got := expr.match(object); got != want {
+			t.Errorf("parseFilter(%q).match() = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := map[string]string{
+		`name == "a"`:          "unknown field",
+		`size ~ "1"`:           "can't compare size",
+		`key < "a"`:            "can't compare key",
+		`key ~ "("`:            "invalid regular expression",
+		`size > 10XB`:          "invalid size unit",
+		`lastModified > today`: "invalid date",
+		`(size > 1`:            "missing closing parenthesis",
+		`size >`:               "unexpected end",
+		`size > 1 size`:        "unexpected",
+	}
+	for s, want := range tests {
+		_, err := parseFilter(s)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseFilter(%q) = %v, want %q", s, err, want)
+		}
+	}
+}
+
+func TestFilterFields(t *testing.T) {
+	tests := map[string][]string{
+		`key ~ "^logs/"`: {"key"},
+		`key ~ "^logs/" && (size > 10MB || !(etag == "abc"))`:    {"etag", "key", "size"},
+		`lastModified < 2024-01-01 || storageClass == "GLACIER"`: {"lastModified", "storageClass"},
+	}
+	for s, want := range tests {
+		expr, err := parseFilter(s)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", s, err)
+			continue
+		}
+		var got []string
+		for field := range filterFields(expr) {
+			got = append(got, field)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("filterFields(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestKeyPatterns(t *testing.T) {
+	if p, err := compileKeyPatterns(nil, nil); p != nil || err != nil {
+		t.Errorf("compileKeyPatterns() without patterns = %v, %v, want nil, nil", p, err)
+	}
+	var none *keyPatterns
+	if !none.allow("any") {
+		t.Error("allow() without patterns = false, want true")
+	}
+
+	p, err := compileKeyPatterns([]string{`\.log$`, `^reports/`}, []string{`/tmp/`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"app/a.log":         true,
+		"reports/2020.csv":  true,
+		"app/tmp/a.log":     false,
+		"reports/tmp/a.csv": false,
+		"app/a.txt":         false,
+	}
+	for key, want := range tests {
+		if got := p.allow(key); got != want {
+			t.Errorf("allow(%q) = %v, want %v", key, got, want)
+		}
+	}
+	if p, _ := compileKeyPatterns(nil, []string{`\.tmp$`}); !p.allow("a.txt") || p.allow("a.tmp") {
+		t.Error("allow() with only the reject patterns doesn't copy the other keys")
+	}
+
+	if _, err := compileKeyPatterns([]string{"("}, nil); err == nil || !strings.Contains(err.Error(), "--match-regex") {
+		t.Errorf("compileKeyPatterns() of an invalid match = %v, want the --match-regex error", err)
+	}
+	if _, err := compileKeyPatterns(nil, []string{"["}); err == nil || !strings.Contains(err.Error(), "--reject-regex") {
+		t.Errorf("compileKeyPatterns() of an invalid reject = %v, want the --reject-regex error", err)
+	}
+}
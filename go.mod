@@ -3,6 +3,9 @@ module github.com/n0madic/s3-bulk-copy-object
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/alexflint/go-arg v1.4.3
+	github.com/alexflint/go-scalar v1.1.0
 	github.com/aws/aws-sdk-go v1.44.35
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// granteeTypes are the grantee identifiers of the explicit grants.
+var granteeTypes = map[string]bool{
+	"id":           true,
+	"uri":          true,
+	"emailAddress": true,
+}
+
+// parseGrantees validates the comma-separated grantees of a --grant-* flag,
+// e.g. id=1234,uri=http://acs.amazonaws.com/groups/global/AllUsers, and returns
+// them in the quoted form of the x-amz-grant-* headers.
+func parseGrantees(s string) (string, error) {
+	var grantees []string
+	for _, grantee := range strings.Split(s, ",") {
+		grantee = strings.TrimSpace(grantee)
+		i := strings.Index(grantee, "=")
+		if i < 0 {
+			return "", fmt.Errorf("grantee %q must be in the TYPE=VALUE format", grantee)
+		}
+		kind, value := strings.TrimSpace(grantee[:i]), strings.Trim(strings.TrimSpace(grantee[i+1:]), `"`)
+		if !granteeTypes[kind] {
+			return "", fmt.Errorf("unknown grantee type %q, use id, uri or emailAddress", kind)
+		}
+		if value == "" {
+			return "", fmt.Errorf("grantee %q has an empty value", grantee)
+		}
+		grantees = append(grantees, fmt.Sprintf("%s=%q", kind, value))
+	}
+	return strings.Join(grantees, ", "), nil
+}
+
+// objectGrants are the explicit grants of the copied objects.
+type objectGrants struct {
+	fullControl string
+	read        string
+	readACP     string
+	writeACP    string
+}
+
+// parseGrants validates the grantees of the --grant-* flags.
+func parseGrants() (objectGrants, error) {
+	var g objectGrants
+	for _, grant := range []struct {
+		flag  string
+		value string
+		dest  *string
+	}{
+		{"--grant-full-control", args.GrantFullControl, &g.fullControl},
+		{"--grant-read", args.GrantRead, &g.read},
+		{"--grant-read-acp", args.GrantReadACP, &g.readACP},
+		{"--grant-write-acp", args.GrantWriteACP, &g.writeACP},
+	} {
+		if grant.value == "" {
+			continue
+		}
+		grantees, err := parseGrantees(grant.value)
+		if err != nil {
+			return g, fmt.Errorf("%s: %v", grant.flag, err)
+		}
+		*grant.dest = grantees
+	}
+	return g, nil
+}
+
+// apply sets the grants on the copy.
+func (g objectGrants) apply(input *s3.CopyObjectInput) {
+	if g == (objectGrants{}) {
+		return
+	}
+	// Even an empty canned ACL header is rejected together with the grants.
+	input.ACL = nil
+	if g.fullControl != "" {
+		input.GrantFullControl = aws.String(g.fullControl)
+	}
+	if g.read != "" {
+		input.GrantRead = aws.String(g.read)
+	}
+	if g.readACP != "" {
+		input.GrantReadACP = aws.String(g.readACP)
+	}
+	if g.writeACP != "" {
+		input.GrantWriteACP = aws.String(g.writeACP)
+	}
+}
+
+// bucketOwnerEnforced reports whether the bucket enforces the bucket owner as
+// the owner of every object, which disables the ACLs. A bucket without
+// ownership controls still accepts them.
+func bucketOwnerEnforced(ctx context.Context, svc *s3.S3, bucket string) (bool, error) {
+	out, err := svc.GetBucketOwnershipControlsWithContext(ctx, &s3.GetBucketOwnershipControlsInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == "OwnershipControlsNotFoundError" {
+			return false, nil
+		}
+		return false, err
+	}
+	if out.OwnershipControls == nil {
+		return false, nil
+	}
+	for _, rule := range out.OwnershipControls.Rules {
+		if aws.StringValue(rule.ObjectOwnership) == s3.ObjectOwnershipBucketOwnerEnforced {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// stripACLFlags clears the ACL flags that a bucket with BucketOwnerEnforced
+// rejects with AccessControlListNotSupported and returns their names.
+// Only the bucket-owner-full-control canned ACL is still accepted there.
+func stripACLFlags() []string {
+	var stripped []string
+	if args.ACL != "" && args.ACL != s3.ObjectCannedACLBucketOwnerFullControl {
+		stripped = append(stripped, "--acl")
+		args.ACL = ""
+	}
+	for _, grant := range []struct {
+		flag  string
+		value *string
+	}{
+		{"--grant-full-control", &args.GrantFullControl},
+		{"--grant-read", &args.GrantRead},
+		{"--grant-read-acp", &args.GrantReadACP},
+		{"--grant-write-acp", &args.GrantWriteACP},
+	} {
+		if *grant.value != "" {
+			stripped = append(stripped, grant.flag)
+			*grant.value = ""
+		}
+	}
+	return stripped
+}
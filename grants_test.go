@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestParseGrantees(t *testing.T) {
+	tests := map[string]string{
+		"id=1234":                       `id="1234"`,
+		`id="1234", emailAddress=a@b.c`: `id="1234", emailAddress="a@b.c"`,
+		"uri=http://acs.amazonaws.com/groups/global/AllUsers": `uri="http://acs.amazonaws.com/groups/global/AllUsers"`,
+	}
+	for s, want := range tests {
+		if got, err := parseGrantees(s); got != want || err != nil {
+			t.Errorf("parseGrantees(%q) = %q, %v, want %q", s, got, err, want)
+		}
+	}
+	errs := map[string]string{
+		"1234":      "TYPE=VALUE",
+		"user=1234": "unknown grantee type",
+		"id=":       "empty value",
+		"id=1,uri=": "empty value",
+	}
+	for s, want := range errs {
+		if _, err := parseGrantees(s); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseGrantees(%q) = %v, want %q", s, err, want)
+		}
+	}
+}
+
+func TestParseGrants(t *testing.T) {
+	saveArgs(t)
+	args.GrantFullControl, args.GrantRead, args.GrantReadACP, args.GrantWriteACP = "id=1", "", "uri=u", ""
+	g, err := parseGrants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (objectGrants{fullControl: `id="1"`, readACP: `uri="u"`}); g != want {
+		t.Errorf("parseGrants() = %+v, want %+v", g, want)
+	}
+
+	input := &s3.CopyObjectInput{ACL: aws.String("")}
+	g.apply(input)
+	if input.ACL != nil || aws.StringValue(input.GrantFullControl) != `id="1"` || aws.StringValue(input.GrantReadACP) != `uri="u"` ||
+		input.GrantRead != nil || input.GrantWriteACP != nil {
+		t.Errorf("apply() = %v, want the grants without the ACL", input)
+	}
+	input = &s3.CopyObjectInput{ACL: aws.String("private")}
+	objectGrants{}.apply(input)
+	if aws.StringValue(input.ACL) != "private" {
+		t.Errorf("apply() without the grants = %v, want the ACL", input)
+	}
+
+	args.GrantWriteACP = "group=x"
+	if _, err := parseGrants(); err == nil || !strings.HasPrefix(err.Error(), "--grant-write-acp:") {
+		t.Errorf("parseGrants() = %v, want the error of --grant-write-acp", err)
+	}
+}
+
+func TestBucketOwnerEnforced(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	ctx := context.Background()
+	for _, bucket := range []string{"enforced", "preferred", "plain"} {
+		fake.put(bucket, "a", 1)
+	}
+	fake.ownership["enforced"] = s3.ObjectOwnershipBucketOwnerEnforced
+	fake.ownership["preferred"] = s3.ObjectOwnershipBucketOwnerPreferred
+	for bucket, want := range map[string]bool{"enforced": true, "preferred": false, "plain": false} {
+		if got, err := bucketOwnerEnforced(ctx, svc, bucket); err != nil || got != want {
+			t.Errorf("bucketOwnerEnforced(%s) = %v, %v, want %v", bucket, got, err, want)
+		}
+	}
+	if _, err := bucketOwnerEnforced(ctx, svc, "missing"); err == nil {
+		t.Error("bucketOwnerEnforced() of a missing bucket succeeded, want an error")
+	}
+}
+
+func TestStripACLFlags(t *testing.T) {
+	parseArgs(t, "--acl", "public-read", "--grant-read", "id=1234", "--grant-write-acp", "id=5678")
+	stripped := stripACLFlags()
+	if want := []string{"--acl", "--grant-read", "--grant-write-acp"}; !reflect.DeepEqual(stripped, want) {
+		t.Errorf("stripACLFlags() = %q, want %q", stripped, want)
+	}
+	if args.ACL != "" || args.GrantRead != "" || args.GrantWriteACP != "" {
+		t.Errorf("stripACLFlags() kept the flags %q, %q, %q", args.ACL, args.GrantRead, args.GrantWriteACP)
+	}
+
+	parseArgs(t, "--acl", s3.ObjectCannedACLBucketOwnerFullControl)
+	if stripped := stripACLFlags(); len(stripped) != 0 || args.ACL != s3.ObjectCannedACLBucketOwnerFullControl {
+		t.Errorf("stripACLFlags() of --acl %s = %q, want it kept", args.ACL, stripped)
+	}
+}
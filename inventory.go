@@ -0,0 +1,123 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// inventoryManifest is the manifest.json of an S3 Inventory report.
+type inventoryManifest struct {
+	SourceBucket string `json:"sourceBucket"`
+	FileFormat   string `json:"fileFormat"`
+	FileSchema   string `json:"fileSchema"`
+	Files        []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// readInventory reads the S3 Inventory manifest at the bucket and key and calls
+// fn for the latest version of every object under the prefix listed in its data files.
+// Only the CSV format is supported.
+func readInventory(ctx context.Context, svc *s3.S3, bucket, key, sourceBucket, prefix string, fn func(*s3.Object)) error {
+	out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get inventory manifest: %v", err)
+	}
+	var manifest inventoryManifest
+	err = json.NewDecoder(out.Body).Decode(&manifest)
+	out.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode inventory manifest: %v", err)
+	}
+	if manifest.FileFormat != "CSV" {
+		return fmt.Errorf("unsupported inventory format %q, only CSV is supported", manifest.FileFormat)
+	}
+	if manifest.SourceBucket != sourceBucket {
+		return fmt.Errorf("inventory is for bucket %q, not %q", manifest.SourceBucket, sourceBucket)
+	}
+	columns := strings.Split(manifest.FileSchema, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+
+	for _, file := range manifest.Files {
+		out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(file.Key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get inventory file %s: %v", file.Key, err)
+		}
+		err = readInventoryCSV(out.Body, columns, prefix, fn)
+		out.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read inventory file %s: %v", file.Key, err)
+		}
+	}
+	return nil
+}
+
+// readInventoryCSV parses a gzipped CSV data file of the inventory
+// with the columns of the manifest file schema.
+func readInventoryCSV(r io.Reader, columns []string, prefix string, fn func(*s3.Object)) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	reader := csv.NewReader(gz)
+	reader.FieldsPerRecord = len(columns)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			row[column] = record[i]
+		}
+		// Copy only the current version of the objects.
+		if row["IsDeleteMarker"] == "true" || row["IsLatest"] == "false" {
+			continue
+		}
+		// Object keys are URL-encoded in the inventory.
+		key, err := url.QueryUnescape(row["Key"])
+		if err != nil {
+			return fmt.Errorf("invalid key %q: %v", row["Key"], err)
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		object := &s3.Object{Key: aws.String(key)}
+		if size, err := strconv.ParseInt(row["Size"], 10, 64); err == nil {
+			object.Size = aws.Int64(size)
+		}
+		if modified, err := time.Parse(time.RFC3339, row["LastModifiedDate"]); err == nil {
+			object.LastModified = aws.Time(modified)
+		}
+		if etag := row["ETag"]; etag != "" {
+			object.ETag = aws.String(etag)
+		}
+		if class := row["StorageClass"]; class != "" {
+			object.StorageClass = aws.String(class)
+		}
+		fn(object)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func gzipped(t *testing.T, data string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestReadInventoryCSV(t *testing.T) {
+	columns := []string{"Bucket", "Key", "VersionId", "IsLatest", "IsDeleteMarker", "Size", "LastModifiedDate", "ETag", "StorageClass"}
+	data := `"bucket1","logs/a%20b.log","v1","true","false","10","2022-01-02T03:04:05.000Z","abc","STANDARD"
+"bucket1","logs/old.log","v0","false","false","5","2021-01-02T03:04:05.000Z","def","STANDARD"
+"bucket1","logs/deleted.log","v2","true","true","","","",""
+"bucket1","data/c.bin","v3","true","false","20","2022-01-02T03:04:05.000Z","ghi","GLACIER"
+"bucket1","logs/d.log","v4","true","false","","","",""
+`
+	var objects []*s3.Object
+	if err := readInventoryCSV(gzipped(t, data), columns, "logs/", func(o *s3.Object) {
+		objects = append(objects, o)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("read %d objects, want 2: %v", len(objects), objects)
+	}
+	o := objects[0]
+	if aws.StringValue(o.Key) != "logs/a b.log" || aws.Int64Value(o.Size) != 10 || aws.StringValue(o.ETag) != "abc" ||
+		aws.StringValue(o.StorageClass) != "STANDARD" || aws.TimeValue(o.LastModified).Year() != 2022 {
+		t.Errorf("first object = %v, want the decoded key with the columns", o)
+	}
+	if o := objects[1]; aws.StringValue(o.Key) != "logs/d.log" || o.Size != nil || o.ETag != nil || o.LastModified != nil {
+		t.Errorf("second object = %v, want only the key", o)
+	}
+}
+
+func TestReadInventoryCSVErrors(t *testing.T) {
+	columns := []string{"Bucket", "Key"}
+	for _, data := range []string{"\"bucket1\"\n", "\"bucket1\",\"bad%zzkey\"\n"} {
+		if err := readInventoryCSV(gzipped(t, data), columns, "", func(*s3.Object) {}); err == nil {
+			t.Errorf("readInventoryCSV(%q) succeeded, want an error", data)
+		}
+	}
+	if err := readInventoryCSV(bytes.NewBufferString("plain"), columns, "", func(*s3.Object) {}); err == nil {
+		t.Error("readInventoryCSV() of an uncompressed file succeeded, want an error")
+	}
+}
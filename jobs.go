@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// copyJob is a line of the --jobs file with the copy instructions of an object.
+// The omitted fields fall back to the global flags.
+type copyJob struct {
+	Source       string            `json:"source"`
+	Dest         string            `json:"dest"`
+	StorageClass string            `json:"storageClass"`
+	Tags         map[string]string `json:"tags"`
+}
+
+// readJobs reads the JSON lines of the jobs and calls fn for every job.
+// Empty lines are skipped.
+func readJobs(ctx context.Context, r io.Reader, fn func(copyJob)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var job copyJob
+		if err := json.Unmarshal([]byte(text), &job); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if job.Source == "" {
+			return fmt.Errorf("line %d: source key is required", line)
+		}
+		if job.StorageClass != "" {
+			if err := validStorageClass(job.StorageClass); err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+		fn(job)
+	}
+	return scanner.Err()
+}
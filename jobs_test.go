@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadJobs(t *testing.T) {
+	input := `{"source":"a.txt"}
+
+{"source":"b.txt","dest":"archive/b.txt","storageClass":"GLACIER","tags":{"team":"data"}}
+`
+	var jobs []copyJob
+	if err := readJobs(context.Background(), strings.NewReader(input), func(job copyJob) { jobs = append(jobs, job) }); err != nil {
+		t.Fatal(err)
+	}
+	want := []copyJob{
+		{Source: "a.txt"},
+		{Source: "b.txt", Dest: "archive/b.txt", StorageClass: "GLACIER", Tags: map[string]string{"team": "data"}},
+	}
+	if !reflect.DeepEqual(jobs, want) {
+		t.Errorf("readJobs() = %+v, want %+v", jobs, want)
+	}
+}
+
+func TestReadJobsErrors(t *testing.T) {
+	tests := map[string]string{
+		"{\"source\":\"a\"}\n{\"source\":":       "line 2:",
+		`{"dest":"b"}`:                           "line 1: source key is required",
+		`{"source":"a","storageClass":"FROZEN"}`: "line 1: unknown storage class \"FROZEN\"",
+	}
+	for input, want := range tests {
+		err := readJobs(context.Background(), strings.NewReader(input), func(copyJob) {})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("readJobs(%q) = %v, want %q", input, err, want)
+		}
+	}
+}
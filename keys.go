@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// keyTemplateData are the variables available to the --dest-key-template.
+type keyTemplateData struct {
+	Key  string // full source key
+	Base string // last element of the key
+	Dir  string // key without the last element, empty at the top level
+	Ext  string // extension of the last element including the dot
+}
+
+// newKeyTemplateData splits the source key into the template variables.
+func newKeyTemplateData(key string) keyTemplateData {
+	dir := path.Dir(key)
+	if dir == "." {
+		dir = ""
+	}
+	return keyTemplateData{
+		Key:  key,
+		Base: path.Base(key),
+		Dir:  dir,
+		Ext:  path.Ext(key),
+	}
+}
+
+// parseKeyTemplate parses the destination key template and
+// checks that it renders a key for a sample source key.
+func parseKeyTemplate(text string) (*template.Template, error) {
+	t, err := template.New("dest-key").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := renderKey(t, "dir/file.ext"); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// renderKey renders the destination key of the source key.
+func renderKey(t *template.Template, key string) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, newKeyTemplateData(key)); err != nil {
+		return "", err
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("template rendered an empty key for %q", key)
+	}
+	return b.String(), nil
+}
+
+// keyPipeline rewrites the source key into the destination key (under the
+// destination path) with its steps in order.
+type keyPipeline []func(key string) (string, error)
+
+// newKeyPipeline returns the steps of the key flags in the order strip, flatten,
+// add prefix: the prefix is stripped from the full source key, the remaining
+// directories are dropped, and the added prefix is kept by the flattening.
+// The template replaces the other steps, validateArgs rejects the combination.
+func newKeyPipeline(stripPrefix string, flatten bool, addPrefix string, t *template.Template) keyPipeline {
+	if t != nil {
+		return keyPipeline{func(key string) (string, error) { return renderKey(t, key) }}
+	}
+	var p keyPipeline
+	if stripPrefix = strings.TrimPrefix(stripPrefix, "/"); stripPrefix != "" {
+		p = append(p, func(key string) (string, error) { return strings.TrimPrefix(key, stripPrefix), nil })
+	}
+	if flatten {
+		p = append(p, func(key string) (string, error) { return path.Base(key), nil })
+	}
+	if addPrefix = strings.TrimPrefix(addPrefix, "/"); addPrefix != "" {
+		p = append(p, func(key string) (string, error) { return addPrefix + key, nil })
+	}
+	return p
+}
+
+// rewrite runs the key through the steps. A key rewritten to nothing,
+// e.g. the stripped prefix itself, is an error.
+func (p keyPipeline) rewrite(key string) (string, error) {
+	source := key
+	for _, step := range p {
+		var err error
+		if key, err = step(key); err != nil {
+			return "", err
+		}
+		if strings.Trim(key, "/") == "" {
+			return "", fmt.Errorf("key %q is rewritten to an empty key", source)
+		}
+	}
+	return key, nil
+}
+
+// joinKey joins the destination prefix and the key with a single slash. Double
+// slashes of the prefix, e.g. of s3://bucket//backup/ or s3://bucket/backup//,
+// are accidental and collapsed, while the key is kept as is, so the double
+// slashes inside the source keys and the trailing slash of the directory
+// markers are copied to the destination keys. The result has no leading slash.
+func joinKey(prefix, key string) string {
+	prefix = strings.Trim(path.Clean("/"+prefix), "/")
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+// copySource returns the URL-encoded CopySource of the object version,
+// or of the current version when the version ID is empty. The objects
+// of an access point are copied from its ARN followed by /object/.
+func copySource(bucket, key, versionID string) string {
+	if accessPoint(bucket) {
+		bucket += "/object"
+	}
+	source := url.QueryEscape(bucket + "/" + key)
+	if versionID != "" {
+		source += "?versionId=" + url.QueryEscape(versionID)
+	}
+	return source
+}
+
+// Policies of the --on-conflict flag.
+const (
+	conflictOverwrite = "overwrite"
+	conflictSkip      = "skip"
+	conflictHash      = "hash"
+)
+
+// conflictResolver handles source objects rewritten to the same destination key.
+// The skip and hash policies resolve the collisions after all the destination keys
+// were added, so the result doesn't depend on the listing order, which varies with
+// --parallel-listing. It isn't safe for concurrent use.
+type conflictResolver struct {
+	policy string
+	// Smallest source key of every destination key and the destination keys of several source keys.
+	sources  map[string]string
+	collides map[string]bool
+}
+
+// newConflictResolver returns the resolver of the policy.
+func newConflictResolver(policy string) (*conflictResolver, error) {
+	switch policy {
+	case conflictOverwrite, conflictSkip, conflictHash:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %q, use %s, %s or %s", policy, conflictOverwrite, conflictSkip, conflictHash)
+	}
+	return &conflictResolver{policy: policy, sources: make(map[string]string), collides: make(map[string]bool)}, nil
+}
+
+// holds reports whether the objects must be added before they are resolved.
+func (c *conflictResolver) holds() bool {
+	return c.policy != conflictOverwrite
+}
+
+// add records the destination key of a source object before the first resolve.
+func (c *conflictResolver) add(sourceKey, targetKey string) {
+	first, ok := c.sources[targetKey]
+	if !ok {
+		c.sources[targetKey] = sourceKey
+		return
+	}
+	if first != sourceKey {
+		c.collides[targetKey] = true
+		if sourceKey < first {
+			c.sources[targetKey] = sourceKey
+		}
+	}
+}
+
+// addTasks adds the destination keys of the tasks that copied reports as copied,
+// the ones skipped by the filters, the deduplication or --max-object-size can't win
+// a collision of the skip policy. copied is called in the order of the tasks, which
+// must be the order of their scheduling, so it finds the same duplicates. The
+// destination keys failing to render are skipped, the failures are reported when
+// the tasks are scheduled.
+func (c *conflictResolver) addTasks(tasks []copyTask, copied func(copyTask) bool, targetKey func(string) (string, error)) {
+	for _, task := range tasks {
+		if !copied(task) {
+			continue
+		}
+		targetPath := task.targetPath
+		if targetPath == "" {
+			var err error
+			if targetPath, err = targetKey(aws.StringValue(task.object.Key)); err != nil {
+				continue
+			}
+		}
+		c.add(aws.StringValue(task.object.Key), targetPath)
+	}
+}
+
+// resolve returns the destination key of the source object. When several added
+// objects share the key, the skip policy copies only the one with the smallest
+// source key, and the hash policy renames all of them, the first one included,
+// with a hash of the source. It returns false when the object is skipped.
+func (c *conflictResolver) resolve(sourceKey, etag, targetKey string) (string, bool) {
+	if c.policy == conflictOverwrite || !c.collides[targetKey] {
+		return targetKey, true
+	}
+	if c.policy == conflictSkip {
+		if sourceKey != c.sources[targetKey] {
+			return "", false
+		}
+		return targetKey, true
+	}
+	return hashedKey(sourceKey, etag, targetKey), true
+}
+
+// hashedKey inserts a short hash of the source key and ETag before the extension
+// of the destination key, so re-runs rename the same object to the same key.
+func hashedKey(sourceKey, etag, targetKey string) string {
+	sum := sha256.Sum256([]byte(sourceKey + "\x00" + strings.Trim(etag, `"`)))
+	ext := path.Ext(targetKey)
+	if strings.Contains(ext, "/") {
+		ext = ""
+	}
+	return strings.TrimSuffix(targetKey, ext) + "-" + hex.EncodeToString(sum[:4]) + ext
+}
+
+// Modes of the --dedup-by flag.
+const dedupETag = "etag"
+
+// dedupIndex remembers the first source key of every ETag, so the objects with
+// the same content are copied once. It's used by the single goroutine that
+// queues the objects, before they reach the copy workers.
+type dedupIndex struct {
+	keys map[string]string
+}
+
+// newDedupIndex returns the index of the mode, or nil when the mode is empty.
+func newDedupIndex(mode string) (*dedupIndex, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case dedupETag:
+		return &dedupIndex{keys: make(map[string]string)}, nil
+	}
+	return nil, fmt.Errorf("unknown dedup mode %q, use %s", mode, dedupETag)
+}
+
+// duplicate returns the key of the first object with the ETag and true when
+// the object is a duplicate of it. Objects without an ETag are never duplicates.
+func (d *dedupIndex) duplicate(key, etag string) (string, bool) {
+	if d == nil || etag == "" {
+		return "", false
+	}
+	if first, ok := d.keys[etag]; ok && first != key {
+		return first, true
+	}
+	d.keys[etag] = key
+	return "", false
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"path"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestRenderKey(t *testing.T) {
+	tests := []struct {
+		template, key, want string
+	}{
+		{"{{.Dir}}/archive/{{.Base}}", "logs/2022/app.log", "logs/2022/archive/app.log"},
+		{"{{.Key}}.bak", "app.log", "app.log.bak"},
+		{"by-ext/{{.Ext}}/{{.Base}}", "data/file.tar.gz", "by-ext/.gz/file.tar.gz"},
+		{"top{{.Dir}}/{{.Base}}", "app.log", "top/app.log"},
+	}
+	for _, test := range tests {
+		tmpl, err := parseKeyTemplate(test.template)
+		if err != nil {
+			t.Fatalf("parseKeyTemplate(%q): %v", test.template, err)
+		}
+		got, err := renderKey(tmpl, test.key)
+		if err != nil {
+			t.Errorf("renderKey(%q, %q): %v", test.template, test.key, err)
+		} else if got != test.want {
+			t.Errorf("renderKey(%q, %q) = %q, want %q", test.template, test.key, got, test.want)
+		}
+	}
+}
+
+func TestParseKeyTemplateErrors(t *testing.T) {
+	for _, text := range []string{"{{.Dir", "{{.Missing}}", "", "{{if false}}x{{end}}"} {
+		if _, err := parseKeyTemplate(text); err == nil {
+			t.Errorf("parseKeyTemplate(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestHashedKey(t *testing.T) {
+	got := hashedKey("logs/a/app.log", `"abc"`, "flat/app.log")
+	if !regexp.MustCompile(`^flat/app-[0-9a-f]{8}\.log$`).MatchString(got) {
+		t.Errorf("hashedKey() = %q, want the hash before the extension", got)
+	}
+	if again := hashedKey("logs/a/app.log", "abc", "flat/app.log"); again != got {
+		t.Errorf("hashedKey() = %q, then %q, want the same key on every run", got, again)
+	}
+	if other := hashedKey("logs/b/app.log", `"abc"`, "flat/app.log"); other == got {
+		t.Errorf("hashedKey() of another source = %q, want a different key", other)
+	}
+	if got := hashedKey("a.b/c", "abc", "flat.d/c"); !regexp.MustCompile(`^flat\.d/c-[0-9a-f]{8}$`).MatchString(got) {
+		t.Errorf("hashedKey() = %q, want the hash at the end of a key without an extension", got)
+	}
+}
+
+// resolveAll resolves the source keys in the order with the policy.
+func resolveAll(t *testing.T, policy string, order []string, targets map[string]string) map[string]string {
+	t.Helper()
+	c, err := newConflictResolver(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.holds() {
+		for _, key := range order {
+			c.add(key, targets[key])
+		}
+	}
+	resolved := make(map[string]string)
+	for _, key := range order {
+		if target, ok := c.resolve(key, `"etag-`+key+`"`, targets[key]); ok {
+			resolved[key] = target
+		}
+	}
+	return resolved
+}
+
+func TestConflictResolver(t *testing.T) {
+	targets := map[string]string{"a/app.log": "app.log", "b/app.log": "app.log", "c/app.log": "app.log", "c/db.log": "db.log"}
+	orders := [][]string{
+		{"a/app.log", "b/app.log", "c/app.log", "c/db.log"},
+		{"c/db.log", "c/app.log", "b/app.log", "a/app.log"},
+		{"b/app.log", "c/db.log", "a/app.log", "c/app.log"},
+	}
+	for _, policy := range []string{conflictOverwrite, conflictSkip, conflictHash} {
+		first := resolveAll(t, policy, orders[0], targets)
+		for _, order := range orders[1:] {
+			if got := resolveAll(t, policy, order, targets); !reflect.DeepEqual(got, first) {
+				t.Errorf("%s resolved %q to %v, and %q to %v, want the same keys in every order", policy, orders[0], first, order, got)
+			}
+		}
+		if first["c/db.log"] != "db.log" {
+			t.Errorf("%s resolved c/db.log to %q, want db.log", policy, first["c/db.log"])
+		}
+		switch policy {
+		case conflictOverwrite:
+			if len(first) != 4 || first["a/app.log"] != "app.log" {
+				t.Errorf("overwrite resolved %v, want all the keys unchanged", first)
+			}
+		case conflictSkip:
+			if want := map[string]string{"a/app.log": "app.log", "c/db.log": "db.log"}; !reflect.DeepEqual(first, want) {
+				t.Errorf("skip resolved %v, want %v", first, want)
+			}
+		case conflictHash:
+			names := make(map[string]bool)
+			for _, key := range []string{"a/app.log", "b/app.log", "c/app.log"} {
+				if first[key] == "app.log" || names[first[key]] {
+					t.Errorf("hash resolved %s to %q, want a unique hashed key", key, first[key])
+				}
+				names[first[key]] = true
+			}
+		}
+	}
+	if _, err := newConflictResolver("rename"); err == nil {
+		t.Error("newConflictResolver(rename) succeeded, want an error")
+	}
+}
+
+func TestConflictResolverAddTasks(t *testing.T) {
+	var tasks []copyTask
+	for _, key := range []string{"a/app.log", "b/app.log", "c/app.log", "d/app.log"} {
+		tasks = append(tasks, copyTask{object: &s3.Object{Key: aws.String(key), ETag: aws.String(`"etag-` + key + `"`)}})
+	}
+	// b/app.log has the content of the later c/app.log, which is the duplicate.
+	tasks[2].object.ETag = tasks[1].object.ETag
+	targetKey := func(key string) (string, error) { return path.Base(key), nil }
+
+	c, err := newConflictResolver(conflictSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicates, _ := newDedupIndex(dedupETag)
+	c.addTasks(tasks, func(task copyTask) bool {
+		// a/app.log is filtered out.
+		if aws.StringValue(task.object.Key) == "a/app.log" {
+			return false
+		}
+		_, ok := duplicates.duplicate(aws.StringValue(task.object.Key), aws.StringValue(task.object.ETag))
+		return !ok
+	}, targetKey)
+	for _, task := range tasks[1:] {
+		key := aws.StringValue(task.object.Key)
+		// The smallest key of the copied objects wins, not the filtered a/app.log.
+		if _, ok := c.resolve(key, aws.StringValue(task.object.ETag), "app.log"); ok != (key == "b/app.log") {
+			t.Errorf("resolve(%s) = %v, want %v", key, ok, key == "b/app.log")
+		}
+	}
+}
+
+func TestDedupIndex(t *testing.T) {
+	if d, err := newDedupIndex(""); d != nil || err != nil {
+		t.Errorf("newDedupIndex(\"\") = %v, %v, want nil, nil", d, err)
+	}
+	if _, err := newDedupIndex("size"); err == nil {
+		t.Error("newDedupIndex(size) succeeded, want an error")
+	}
+	d, err := newDedupIndex(dedupETag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key, etag string
+		first     string
+		duplicate bool
+	}{
+		{"a", `"1"`, "", false},
+		{"b", `"2"`, "", false},
+		{"c", `"1"`, "a", true},
+		{"a", `"1"`, "", false},
+		{"d", "", "", false},
+		{"e", "", "", false},
+	}
+	for _, test := range tests {
+		first, duplicate := d.duplicate(test.key, test.etag)
+		if first != test.first || duplicate != test.duplicate {
+			t.Errorf("duplicate(%q, %q) = %q, %v, want %q, %v", test.key, test.etag, first, duplicate, test.first, test.duplicate)
+		}
+	}
+	var none *dedupIndex
+	if _, duplicate := none.duplicate("a", `"1"`); duplicate {
+		t.Error("duplicate() of a nil index = true, want false")
+	}
+}
+
+func TestCopySource(t *testing.T) {
+	tests := []struct {
+		bucket, key, versionID string
+		want                   string
+	}{
+		{"bucket1", "dir/a b+c.txt", "", "bucket1%2Fdir%2Fa+b%2Bc.txt"},
+		{"bucket1", "a.txt", "3/L4kqtJl+", "bucket1%2Fa.txt?versionId=3%2FL4kqtJl%2B"},
+	}
+	for _, test := range tests {
+		if got := copySource(test.bucket, test.key, test.versionID); got != test.want {
+			t.Errorf("copySource(%q, %q, %q) = %q, want %q", test.bucket, test.key, test.versionID, got, test.want)
+		}
+	}
+}
+
+func TestKeyPipeline(t *testing.T) {
+	tests := []struct {
+		strip   string
+		flatten bool
+		add     string
+		key     string
+		want    string
+	}{
+		{"", false, "", "data/2020/a.log", "data/2020/a.log"},
+		{"/data/", false, "", "data/2020/a.log", "2020/a.log"},
+		{"data/", true, "", "data/2020/a.log", "a.log"},
+		{"", true, "/archive/", "data/2020/a.log", "archive/a.log"},
+		{"data/", false, "archive/", "data/2020/a.log", "archive/2020/a.log"},
+		{"other/", false, "", "data/a.log", "data/a.log"},
+	}
+	for _, test := range tests {
+		p := newKeyPipeline(test.strip, test.flatten, test.add, nil)
+		if got, err := p.rewrite(test.key); got != test.want || err != nil {
+			t.Errorf("rewrite(%q) with strip %q, flatten %v, add %q = %q, %v, want %q",
+				test.key, test.strip, test.flatten, test.add, got, err, test.want)
+		}
+	}
+
+	if _, err := newKeyPipeline("data/", false, "", nil).rewrite("data/"); err == nil {
+		t.Error("rewrite() of the stripped prefix succeeded, want an error")
+	}
+	tmpl, err := parseKeyTemplate("{{.Dir}}/copy-{{.Base}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := newKeyPipeline("data/", true, "archive/", tmpl).rewrite("data/a.log"); got != "data/copy-a.log" || err != nil {
+		t.Errorf("rewrite() with a template = %q, %v, want only the template applied", got, err)
+	}
+}
+
+func TestJoinKey(t *testing.T) {
+	tests := []struct {
+		prefix, key string
+		want        string
+	}{
+		{"", "a.txt", "a.txt"},
+		{"/", "a.txt", "a.txt"},
+		{"backup", "a.txt", "backup/a.txt"},
+		{"backup/", "a.txt", "backup/a.txt"},
+		{"/backup//", "a.txt", "backup/a.txt"},
+		{"//backup//daily/", "a.txt", "backup/daily/a.txt"},
+		{"backup/", "dir//a.txt", "backup/dir//a.txt"},
+		{"backup/", "dir/", "backup/dir/"},
+		{"", "/a.txt", "/a.txt"},
+	}
+	for _, test := range tests {
+		if got := joinKey(test.prefix, test.key); got != test.want {
+			t.Errorf("joinKey(%q, %q) = %q, want %q", test.prefix, test.key, got, test.want)
+		}
+	}
+}
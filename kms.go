@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// kmsAccessDenied reports whether the request failed because the caller may not
+// use the KMS key of the object, which S3 returns as a generic AccessDenied
+// mentioning KMS or as one of the KMS error codes.
+func kmsAccessDenied(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	if strings.HasPrefix(aerr.Code(), "KMS.") {
+		return true
+	}
+	return aerr.Code() == "AccessDenied" && strings.Contains(strings.ToLower(aerr.Message()), "kms")
+}
+
+// kmsGuidance explains the copy of the SSE-KMS object that failed on the permissions of its key.
+func kmsGuidance(key, keyID string) string {
+	if keyID == "" {
+		keyID = "of the object"
+	}
+	return fmt.Sprintf("Object %s is encrypted with the KMS key %s, the caller needs kms:Decrypt on it "+
+		"(and kms:GenerateDataKey on the key of the destination bucket), grant them in the key policy or the IAM policy of the caller", key, keyID)
+}
+
+// checkKMSDecrypt reads the first byte of the source object when it's encrypted
+// with SSE-KMS, since the permission to decrypt isn't checked by HEAD requests.
+func checkKMSDecrypt(ctx context.Context, svc *s3.S3, bucket, key string) error {
+	head, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("failed to head %s: %s", key, describeError(err))
+	}
+	if aws.StringValue(head.ServerSideEncryption) != s3.ServerSideEncryptionAwsKms {
+		return nil
+	}
+	out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String("bytes=0-0"),
+	})
+	if err != nil {
+		if kmsAccessDenied(err) {
+			return errors.New(kmsGuidance(key, aws.StringValue(head.SSEKMSKeyId)))
+		}
+		return fmt.Errorf("failed to read %s: %s", key, describeError(err))
+	}
+	out.Body.Close()
+	return nil
+}
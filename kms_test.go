@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestKMSAccessDenied(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.New("KMS.DisabledException", "disabled", nil), true},
+		{awserr.New("KMS.NotFoundException", "not found", nil), true},
+		{awserr.New("AccessDenied", "User is not authorized to perform: kms:Decrypt", nil), true},
+		{awserr.New("AccessDenied", "Access Denied", nil), false},
+		{awserr.New("NoSuchKey", "kms", nil), false},
+		{errors.New("KMS.DisabledException"), false},
+	}
+	for _, test := range tests {
+		if got := kmsAccessDenied(test.err); got != test.want {
+			t.Errorf("kmsAccessDenied(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestKMSGuidance(t *testing.T) {
+	if got := kmsGuidance("a.txt", "arn:aws:kms:us-east-1:123:key/1"); !strings.HasPrefix(got, "Object a.txt is encrypted with the KMS key arn:aws:kms:us-east-1:123:key/1, the caller needs kms:Decrypt") {
+		t.Errorf("kmsGuidance() = %q", got)
+	}
+	if got := kmsGuidance("a.txt", ""); !strings.HasPrefix(got, "Object a.txt is encrypted with the KMS key of the object,") {
+		t.Errorf("kmsGuidance() without the key ID = %q", got)
+	}
+}
+
+func TestCheckKMSDecrypt(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	fake.put("src", "plain", 1)
+	fake.put("src", "kms", 1)
+	fake.put("src", "denied", 1)
+	fake.buckets["src"]["kms"].sse = "aws:kms"
+	denied := fake.buckets["src"]["denied"]
+	denied.sse, denied.kmsKeyID, denied.kmsDenied = "aws:kms", "key-1", true
+	ctx := context.Background()
+
+	if err := checkKMSDecrypt(ctx, svc, "src", "plain"); err != nil {
+		t.Errorf("checkKMSDecrypt() of an unencrypted object: %v", err)
+	}
+	if err := checkKMSDecrypt(ctx, svc, "src", "kms"); err != nil {
+		t.Errorf("checkKMSDecrypt() of a readable SSE-KMS object: %v", err)
+	}
+	if want := []string{"HEAD plain", "HEAD kms", "GET kms"}; !reflect.DeepEqual(fake.requests, want) {
+		t.Errorf("requests = %q, want %q", fake.requests, want)
+	}
+	err := checkKMSDecrypt(ctx, svc, "src", "denied")
+	if err == nil || !strings.HasPrefix(err.Error(), "Object denied is encrypted with the KMS key key-1") {
+		t.Errorf("checkKMSDecrypt() of a denied object = %v, want the guidance", err)
+	}
+	if err := checkKMSDecrypt(ctx, svc, "src", "missing"); err == nil || !strings.HasPrefix(err.Error(), "failed to head missing") {
+		t.Errorf("checkKMSDecrypt() of a missing object = %v, want the HEAD error", err)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// partitionChars split the keyspace after the prefix for the parallel listing.
+// They must be in ascending byte order.
+const partitionChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// keyRange is a part of the keyspace under the prefix with the keys
+// after the exclusive start and up to the inclusive end, empty means unbounded.
+type keyRange struct {
+	start string
+	end   string
+}
+
+// partitionKeyspace splits the keyspace under the prefix into ranges
+// bounded by the prefix followed by each of the partition characters.
+// The ranges together cover every key exactly once.
+func partitionKeyspace(prefix string) []keyRange {
+	ranges := make([]keyRange, 0, len(partitionChars)+1)
+	start := ""
+	for _, c := range partitionChars {
+		end := prefix + string(c)
+		ranges = append(ranges, keyRange{start: start, end: end})
+		start = end
+	}
+	return append(ranges, keyRange{start: start})
+}
+
+// directoryMarker reports whether the object is a zero-byte "folder" object
+// of the consoles and sync tools, with the key ending in a slash.
+func directoryMarker(o *s3.Object) bool {
+	return strings.HasSuffix(aws.StringValue(o.Key), "/") && aws.Int64Value(o.Size) == 0
+}
+
+// Orders of the --sort flag.
+const (
+	sortNone     = "none"
+	sortLexical  = "lexical"
+	sortSize     = "size"
+	sortModified = "modified"
+)
+
+// Orders of the --traversal flag.
+const (
+	traversalBreadth = "breadth"
+	traversalDepth   = "depth"
+)
+
+// sortTasks orders the tasks by the keys, by the sizes largest first, so the
+// long copies start early, or by the modification times oldest first.
+// The ties are ordered by the keys, so every run gets the same order.
+func sortTasks(tasks []copyTask, order string) {
+	key := func(i int) string { return aws.StringValue(tasks[i].object.Key) }
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i].object, tasks[j].object
+		switch order {
+		case sortSize:
+			if sa, sb := aws.Int64Value(a.Size), aws.Int64Value(b.Size); sa != sb {
+				return sa > sb
+			}
+		case sortModified:
+			if ta, tb := aws.TimeValue(a.LastModified), aws.TimeValue(b.LastModified); !ta.Equal(tb) {
+				return ta.Before(tb)
+			}
+		}
+		return key(i) < key(j)
+	})
+}
+
+// bucketMissing reports whether the request failed because the bucket doesn't
+// exist, unlike the listing of an empty bucket that succeeds without objects.
+func bucketMissing(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchBucket
+}
+
+// listInput returns the listing input of the prefix of the bucket,
+// with the object owners for --fetch-owner and --owner-id.
+func listInput(bucket, prefix string) *s3.ListObjectsV2Input {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	if args.FetchOwner || args.OwnerID != "" {
+		input.FetchOwner = aws.Bool(true)
+	}
+	if args.EncodingType != "" {
+		input.EncodingType = aws.String(args.EncodingType)
+	}
+	return input
+}
+
+// decodeKey returns the listed key decoded from the URL encoding of --encoding-type url,
+// S3 encodes the characters that XML can't carry and the spaces as "+".
+// The key is kept as listed without the encoding or when it can't be decoded.
+func decodeKey(key *string) *string {
+	if args.EncodingType != s3.EncodingTypeUrl || key == nil {
+		return key
+	}
+	decoded, err := url.QueryUnescape(*key)
+	if err != nil {
+		return key
+	}
+	return aws.String(decoded)
+}
+
+// decodeKeys decodes the keys of the listed objects in place.
+func decodeKeys(objects []*s3.Object) {
+	for _, o := range objects {
+		o.Key = decodeKey(o.Key)
+	}
+}
+
+// listRange lists the objects of the key range under the prefix.
+func listRange(ctx context.Context, svc *s3.S3, bucket, prefix string, r keyRange, fn func(*s3.Object)) error {
+	input := listInput(bucket, prefix)
+	if r.start != "" {
+		input.StartAfter = aws.String(r.start)
+	}
+	return svc.ListObjectsV2PagesWithContext(ctx, input, func(p *s3.ListObjectsV2Output, lastPage bool) bool {
+		decodeKeys(p.Contents)
+		for _, o := range p.Contents {
+			if r.end != "" && aws.StringValue(o.Key) > r.end {
+				return false // end of the range
+			}
+			fn(o)
+		}
+		return true // continue paging
+	})
+}
+
+// listObjects lists all objects under the prefix of the bucket.
+func listObjects(ctx context.Context, svc *s3.S3, bucket, prefix string, fn func(*s3.Object)) error {
+	return listRange(ctx, svc, bucket, prefix, keyRange{}, fn)
+}
+
+// listPages lists the objects under the prefix of the bucket
+// and calls fn with every page of the listing.
+func listPages(ctx context.Context, svc *s3.S3, bucket, prefix string, fn func([]*s3.Object)) error {
+	input := listInput(bucket, prefix)
+	return svc.ListObjectsV2PagesWithContext(ctx, input, func(p *s3.ListObjectsV2Output, lastPage bool) bool {
+		decodeKeys(p.Contents)
+		fn(p.Contents)
+		return ctx.Err() == nil
+	})
+}
+
+// listDelimited lists the objects under the prefix of the bucket one level of the
+// delimiter at a time, descending into the common prefixes of every level. Breadth
+// lists all prefixes of a level before the next one, which spreads the load over
+// the subtrees, while depth finishes every subtree before its next sibling.
+// The common prefixes under the excluded prefixes aren't listed at all.
+func listDelimited(ctx context.Context, svc *s3.S3, bucket, prefix, delimiter, traversal string, excludes []string, fn func(*s3.Object)) error {
+	pending := []string{prefix}
+	for len(pending) > 0 {
+		var current string
+		if traversal == traversalBreadth {
+			current, pending = pending[0], pending[1:]
+		} else {
+			current, pending = pending[len(pending)-1], pending[:len(pending)-1]
+		}
+		input := listInput(bucket, current)
+		input.Delimiter = aws.String(delimiter)
+		var children []string
+		err := svc.ListObjectsV2PagesWithContext(ctx, input, func(p *s3.ListObjectsV2Output, lastPage bool) bool {
+			decodeKeys(p.Contents)
+			for _, o := range p.Contents {
+				fn(o)
+			}
+			for _, cp := range p.CommonPrefixes {
+				if child := aws.StringValue(decodeKey(cp.Prefix)); excludedPrefix(child, excludes) == "" {
+					children = append(children, child)
+				}
+			}
+			return ctx.Err() == nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if traversal == traversalBreadth {
+			pending = append(pending, children...)
+		} else {
+			// Push the children last first, so they are popped in the listed order.
+			for i := len(children) - 1; i >= 0; i-- {
+				pending = append(pending, children[i])
+			}
+		}
+	}
+	return nil
+}
+
+// prefixEnd is the highest code point, a key of a prefix followed by it sorts
+// after all the keys with the prefix that don't continue with it.
+const prefixEnd = "\U0010FFFF"
+
+// excludedPrefix returns the excluded prefix of the key, or an empty string.
+func excludedPrefix(key string, excludes []string) string {
+	for _, exclude := range excludes {
+		if strings.HasPrefix(key, exclude) {
+			return exclude
+		}
+	}
+	return ""
+}
+
+// listExcluding lists the objects under the prefix of the bucket except the ones
+// under the excluded prefixes. S3 can't exclude a prefix on the server, so when
+// the listing reaches an excluded prefix it's restarted after the end of it
+// instead of paging through the excluded keys.
+func listExcluding(ctx context.Context, svc *s3.S3, bucket, prefix string, excludes []string, fn func(*s3.Object)) error {
+	start := ""
+	for {
+		skipTo := ""
+		input := listInput(bucket, prefix)
+		if start != "" {
+			input.StartAfter = aws.String(start)
+		}
+		err := svc.ListObjectsV2PagesWithContext(ctx, input, func(p *s3.ListObjectsV2Output, lastPage bool) bool {
+			decodeKeys(p.Contents)
+			for _, o := range p.Contents {
+				if exclude := excludedPrefix(aws.StringValue(o.Key), excludes); exclude != "" {
+					skipTo = exclude + prefixEnd
+					return false // restart after the excluded prefix
+				}
+				fn(o)
+			}
+			return true // continue paging
+		})
+		if err != nil || skipTo == "" {
+			return err
+		}
+		start = skipTo
+	}
+}
+
+// listParallel lists the partitions of the keyspace under the prefix
+// with the number of concurrent workers. The objects are passed to fn
+// from the calling goroutine, so fn doesn't have to be concurrency-safe.
+func listParallel(ctx context.Context, svc *s3.S3, bucket, prefix string, workers int, fn func(*s3.Object)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ranges := make(chan keyRange)
+	objects := make(chan *s3.Object)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range ranges {
+				err := listRange(ctx, svc, bucket, prefix, r, func(o *s3.Object) {
+					select {
+					case objects <- o:
+					case <-ctx.Done():
+					}
+				})
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(ranges)
+		for _, r := range partitionKeyspace(prefix) {
+			select {
+			case ranges <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(objects)
+	}()
+
+	for o := range objects {
+		fn(o)
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return ctx.Err()
+	}
+}
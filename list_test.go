@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestPartitionKeyspace(t *testing.T) {
+	prefix := "logs/"
+	ranges := partitionKeyspace(prefix)
+	keys := []string{"logs/", "logs/ ", "logs/-", "logs/0", "logs/00", "logs/0~", "logs/9z", "logs/A",
+		"logs/Zz", "logs/_", "logs/a", "logs/m/n", "logs/z", "logs/zz", "logs/~", "logs/é", "logs/\U0010FFFF"}
+	for _, key := range keys {
+		n := 0
+		for _, r := range ranges {
+			if key > r.start && (r.end == "" || key <= r.end) {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Errorf("key %q is in %d ranges, want 1", key, n)
+		}
+	}
+	if first, last := ranges[0], ranges[len(ranges)-1]; first.start != "" || last.end != "" {
+		t.Errorf("ranges run from %q to %q, want unbounded ends", first.start, last.end)
+	}
+}
+
+func TestListParallel(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	var want []string
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("data/%c%d", "0aZz-~"[i%6], i)
+		fake.put("bucket1", key, int64(i))
+		want = append(want, key)
+	}
+	fake.put("bucket1", "other/key", 1)
+	sort.Strings(want)
+
+	var got []string
+	if err := listParallel(context.Background(), svc, "bucket1", "data/", 4, func(o *s3.Object) {
+		got = append(got, aws.StringValue(o.Key))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listParallel() = %q, want %q", got, want)
+	}
+
+	if err := listParallel(context.Background(), svc, "missing", "", 4, func(*s3.Object) {}); !bucketMissing(err) {
+		t.Errorf("listParallel() of a missing bucket = %v, want NoSuchBucket", err)
+	}
+}
+
+func TestExcludedPrefix(t *testing.T) {
+	excludes := []string{"data/tmp/", "data/cache"}
+	tests := map[string]string{
+		"data/tmp/a":     "data/tmp/",
+		"data/cache-old": "data/cache",
+		"data/tmp":       "",
+		"data/a":         "",
+	}
+	for key, want := range tests {
+		if got := excludedPrefix(key, excludes); got != want {
+			t.Errorf("excludedPrefix(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestListExcluding(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	for _, key := range []string{"data/a", "data/b", "data/t", "data/z", "data/skip", "other/a"} {
+		fake.put("bucket1", key, 1)
+	}
+	for i := 0; i < 10; i++ {
+		fake.put("bucket1", fmt.Sprintf("data/skip/%02d", i), 1)
+	}
+
+	var got []string
+	if err := listExcluding(context.Background(), svc, "bucket1", "data/", []string{"data/skip/"}, func(o *s3.Object) {
+		got = append(got, aws.StringValue(o.Key))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"data/a", "data/b", "data/skip", "data/t", "data/z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listExcluding() = %q, want %q", got, want)
+	}
+	// The second page reaches the excluded prefix, the listing restarts after it
+	// instead of paging through the 10 excluded keys.
+	if n := fake.listRequests(); n != 3 {
+		t.Errorf("listExcluding() made %d listing requests, want 3", n)
+	}
+}
+
+func TestListDelimited(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	for _, key := range []string{"data/a", "data/b", "data/x/1", "data/x/y/2", "data/z/3", "data/skip/4", "other/a"} {
+		fake.put("bucket1", key, 1)
+	}
+	tests := []struct {
+		traversal string
+		want      []string
+	}{
+		{traversalBreadth, []string{"data/a", "data/b", "data/x/1", "data/z/3", "data/x/y/2"}},
+		{traversalDepth, []string{"data/a", "data/b", "data/x/1", "data/x/y/2", "data/z/3"}},
+	}
+	for _, test := range tests {
+		parseArgs(t)
+		fake.requests = nil
+		var got []string
+		if err := listDelimited(context.Background(), svc, "bucket1", "data/", "/", test.traversal, []string{"data/skip/"}, func(o *s3.Object) {
+			got = append(got, aws.StringValue(o.Key))
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("listDelimited(%s) = %q, want %q", test.traversal, got, test.want)
+		}
+		for _, r := range fake.requests {
+			if strings.Contains(r, "prefix=data%2Fskip") {
+				t.Errorf("listDelimited(%s) listed the excluded prefix: %s", test.traversal, r)
+			}
+		}
+	}
+}
+
+func TestListPages(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	for i := 0; i < 7; i++ {
+		fake.put("bucket1", fmt.Sprintf("data/%d", i), 1)
+	}
+
+	var sizes []int
+	if err := listPages(context.Background(), svc, "bucket1", "data/", func(objects []*s3.Object) {
+		sizes = append(sizes, len(objects))
+		// The next page isn't listed before the objects of this one are handled.
+		if n := fake.listRequests(); n != len(sizes) {
+			t.Errorf("page %d handled after %d listing requests", len(sizes), n)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 3, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("page sizes = %v, want %v", sizes, want)
+	}
+
+	fake.requests = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pages := 0
+	listPages(ctx, svc, "bucket1", "data/", func([]*s3.Object) {
+		pages++
+		cancel()
+	})
+	if pages != 1 || fake.listRequests() != 1 {
+		t.Errorf("listPages() listed %d pages with %d requests after the cancel, want 1", pages, fake.listRequests())
+	}
+}
+
+func TestListInput(t *testing.T) {
+	tests := []struct {
+		argv       []string
+		fetchOwner bool
+		encoding   string
+	}{
+		{nil, false, ""},
+		{[]string{"--fetch-owner"}, true, ""},
+		{[]string{"--owner-id", "1234"}, true, ""},
+		{[]string{"--encoding-type", "url"}, false, "url"},
+	}
+	for _, test := range tests {
+		parseArgs(t, test.argv...)
+		input := listInput("bucket1", "data/")
+		if aws.StringValue(input.Bucket) != "bucket1" || aws.StringValue(input.Prefix) != "data/" ||
+			aws.BoolValue(input.FetchOwner) != test.fetchOwner || aws.StringValue(input.EncodingType) != test.encoding {
+			t.Errorf("listInput() with %q = %v", test.argv, input)
+		}
+	}
+}
+
+func TestDecodeKeys(t *testing.T) {
+	objects := []*s3.Object{{Key: aws.String("data/a+b%0A.txt")}, {Key: aws.String("data/100%")}, {}}
+	parseArgs(t)
+	decodeKeys(objects)
+	if got := aws.StringValue(objects[0].Key); got != "data/a+b%0A.txt" {
+		t.Errorf("decodeKeys() without --encoding-type = %q, want the listed key", got)
+	}
+
+	parseArgs(t, "--encoding-type", "url")
+	decodeKeys(objects)
+	for i, want := range []string{"data/a b\n.txt", "data/100%"} {
+		if got := aws.StringValue(objects[i].Key); got != want {
+			t.Errorf("decodeKeys() of key #%d = %q, want %q", i+1, got, want)
+		}
+	}
+	if objects[2].Key != nil {
+		t.Errorf("decodeKeys() of a missing key = %q, want nil", *objects[2].Key)
+	}
+}
+
+func TestDirectoryMarker(t *testing.T) {
+	tests := []struct {
+		key  string
+		size int64
+		want bool
+	}{
+		{"data/", 0, true},
+		{"data/2020/", 0, true},
+		{"data/", 10, false},
+		{"data/a", 0, false},
+		{"data", 0, false},
+	}
+	for _, test := range tests {
+		if got := directoryMarker(&s3.Object{Key: aws.String(test.key), Size: aws.Int64(test.size)}); got != test.want {
+			t.Errorf("directoryMarker(%q, %d) = %v, want %v", test.key, test.size, got, test.want)
+		}
+	}
+}
+
+func TestSortTasks(t *testing.T) {
+	at := func(day int) *time.Time { return aws.Time(time.Date(2020, 1, day, 0, 0, 0, 0, time.UTC)) }
+	tasks := func() []copyTask {
+		return []copyTask{
+			{object: &s3.Object{Key: aws.String("c"), Size: aws.Int64(10), LastModified: at(2)}},
+			{object: &s3.Object{Key: aws.String("a"), Size: aws.Int64(5), LastModified: at(3)}},
+			{object: &s3.Object{Key: aws.String("d"), Size: aws.Int64(10), LastModified: at(1)}},
+			{object: &s3.Object{Key: aws.String("b"), Size: aws.Int64(20), LastModified: at(2)}},
+		}
+	}
+	tests := map[string][]string{
+		sortLexical:  {"a", "b", "c", "d"},
+		sortSize:     {"b", "c", "d", "a"},
+		sortModified: {"d", "b", "c", "a"},
+	}
+	for order, want := range tests {
+		sorted := tasks()
+		sortTasks(sorted, order)
+		var got []string
+		for _, task := range sorted {
+			got = append(got, aws.StringValue(task.object.Key))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("sortTasks(%s) = %q, want %q", order, got, want)
+		}
+	}
+}
+
+func TestBucketMissing(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	fake.put("bucket1", "data/a", 1)
+	ctx := context.Background()
+	if err := listObjects(ctx, svc, "missing", "data/", func(*s3.Object) {}); !bucketMissing(err) {
+		t.Errorf("bucketMissing(%v) of a missing bucket = false, want true", err)
+	}
+	n := 0
+	if err := listObjects(ctx, svc, "bucket1", "none/", func(*s3.Object) { n++ }); err != nil || n != 0 {
+		t.Errorf("listObjects() of an empty prefix = %d objects, %v, want none without an error", n, err)
+	}
+	for _, err := range []error{nil, errors.New(s3.ErrCodeNoSuchBucket), awserr.New("AccessDenied", "denied", nil)} {
+		if bucketMissing(err) {
+			t.Errorf("bucketMissing(%v) = true, want false", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// listCacheHeader is the first line of the --list-cache file,
+// the listing it holds and when it was saved.
+type listCacheHeader struct {
+	Bucket  string    `json:"bucket"`
+	Prefix  string    `json:"prefix"`
+	Exclude []string  `json:"exclude,omitempty"`
+	Saved   time.Time `json:"saved"`
+}
+
+// matches reports whether the cache holds the listing of the same
+// bucket and prefix with the same excluded prefixes.
+func (h listCacheHeader) matches(bucket, prefix string, exclude []string) bool {
+	return h.Bucket == bucket && h.Prefix == prefix && strings.Join(h.Exclude, "\n") == strings.Join(exclude, "\n")
+}
+
+// cachedObject is a line of the listed objects in the --list-cache file.
+type cachedObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+	StorageClass string    `json:"storage_class,omitempty"`
+}
+
+// listCacheWriter saves the listed objects into a temporary file that
+// replaces the cache only when the listing is complete.
+type listCacheWriter struct {
+	filename string
+	f        *os.File
+	w        *bufio.Writer
+	enc      *json.Encoder
+}
+
+// createListCache starts the cache file of the listing with the header.
+func createListCache(filename string, header listCacheHeader) (*listCacheWriter, error) {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	c := &listCacheWriter{filename: filename, f: f, w: bufio.NewWriter(f)}
+	c.enc = json.NewEncoder(c.w)
+	if err := c.enc.Encode(header); err != nil {
+		c.discard()
+		return nil, err
+	}
+	return c, nil
+}
+
+// add writes the listed object.
+func (c *listCacheWriter) add(o *s3.Object) error {
+	return c.enc.Encode(cachedObject{
+		Key:          aws.StringValue(o.Key),
+		Size:         aws.Int64Value(o.Size),
+		ETag:         aws.StringValue(o.ETag),
+		LastModified: aws.TimeValue(o.LastModified),
+		StorageClass: aws.StringValue(o.StorageClass),
+	})
+}
+
+// commit replaces the cache file with the complete listing.
+func (c *listCacheWriter) commit() error {
+	if err := c.w.Flush(); err != nil {
+		c.discard()
+		return err
+	}
+	if err := c.f.Close(); err != nil {
+		os.Remove(c.f.Name())
+		return err
+	}
+	return os.Rename(c.f.Name(), c.filename)
+}
+
+// discard removes the incomplete listing and keeps the previous cache.
+func (c *listCacheWriter) discard() {
+	c.f.Close()
+	os.Remove(c.f.Name())
+}
+
+// readListCache reads the header of the cache and, if check accepts it,
+// calls fn for every cached object in the listing order.
+func readListCache(ctx context.Context, r io.Reader, check func(listCacheHeader) error, fn func(*s3.Object)) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header listCacheHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("invalid header: %v", err)
+	}
+	if err := check(header); err != nil {
+		return err
+	}
+	for line := 2; ; line++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var o cachedObject
+		if err := dec.Decode(&o); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		object := &s3.Object{
+			Key:          aws.String(o.Key),
+			Size:         aws.Int64(o.Size),
+			ETag:         aws.String(o.ETag),
+			LastModified: aws.Time(o.LastModified),
+		}
+		if o.StorageClass != "" {
+			object.StorageClass = aws.String(o.StorageClass)
+		}
+		fn(object)
+	}
+}
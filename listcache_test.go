@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestListCacheHeaderMatches(t *testing.T) {
+	h := listCacheHeader{Bucket: "bucket1", Prefix: "data/", Exclude: []string{"data/tmp/"}}
+	tests := []struct {
+		bucket, prefix string
+		exclude        []string
+		want           bool
+	}{
+		{"bucket1", "data/", []string{"data/tmp/"}, true},
+		{"bucket2", "data/", []string{"data/tmp/"}, false},
+		{"bucket1", "data", []string{"data/tmp/"}, false},
+		{"bucket1", "data/", nil, false},
+		{"bucket1", "data/", []string{"data/tmp/", "data/cache/"}, false},
+	}
+	for _, test := range tests {
+		if got := h.matches(test.bucket, test.prefix, test.exclude); got != test.want {
+			t.Errorf("matches(%s, %s, %q) = %v, want %v", test.bucket, test.prefix, test.exclude, got, test.want)
+		}
+	}
+}
+
+func TestListCacheRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "listing.jsonl")
+	saved := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	header := listCacheHeader{Bucket: "bucket1", Prefix: "data/", Saved: saved}
+	objects := []*s3.Object{
+		{Key: aws.String("data/a"), Size: aws.Int64(1), ETag: aws.String(`"1"`), LastModified: aws.Time(saved), StorageClass: aws.String("GLACIER")},
+		{Key: aws.String("data/b"), Size: aws.Int64(2), ETag: aws.String(`"2"`), LastModified: aws.Time(saved)},
+	}
+	c, err := createListCache(filename, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range objects {
+		if err := c.add(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("cache file exists before the commit: %v", err)
+	}
+	if err := c.commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []*s3.Object
+	if err := readListCache(context.Background(), f, func(h listCacheHeader) error {
+		if !reflect.DeepEqual(h, header) {
+			t.Errorf("header = %+v, want %+v", h, header)
+		}
+		return nil
+	}, func(o *s3.Object) { got = append(got, o) }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, objects) {
+		t.Errorf("readListCache() = %v, want %v", got, objects)
+	}
+}
+
+func TestListCacheDiscard(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "listing.jsonl")
+	if err := os.WriteFile(filename, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := createListCache(filename, listCacheHeader{Bucket: "bucket1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.add(&s3.Object{Key: aws.String("a")}); err != nil {
+		t.Fatal(err)
+	}
+	c.discard()
+	if data, err := os.ReadFile(filename); err != nil || string(data) != "previous" {
+		t.Errorf("cache = %q, %v, want the previous cache kept", data, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory has %d files, want the temporary file removed", len(entries))
+	}
+}
+
+func TestReadListCacheErrors(t *testing.T) {
+	accept := func(listCacheHeader) error { return nil }
+	stale := errors.New("stale")
+	tests := []struct {
+		input string
+		check func(listCacheHeader) error
+		want  string
+	}{
+		{"not json\n", accept, "invalid header"},
+		{`{"bucket":"bucket1"}` + "\n", func(listCacheHeader) error { return stale }, "stale"},
+		{`{"bucket":"bucket1"}` + "\n" + `{"key":"a"}` + "\n" + `{"key":` + "\n", accept, "line 3:"},
+	}
+	for _, test := range tests {
+		err := readListCache(context.Background(), strings.NewReader(test.input), test.check, func(*s3.Object) {})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("readListCache(%q) = %v, want %q", test.input, err, test.want)
+		}
+	}
+}
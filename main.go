@@ -2,64 +2,472 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
-	"path"
+	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
 var args struct {
-	Source       string `arg:"positional,required" help:"Source bucket"`
-	Destination  string `arg:"positional,required" help:"Destination bucket"`
-	ACL          string `arg:"-a,--acl" help:"ACL to apply to the copied object"`
-	Concurrency  int    `arg:"-c,--concurrency" placeholder:"NUM" help:"Number of concurrent transfers" default:"10"`
-	Recursive    bool   `arg:"-r,--recursive" help:"Recursively copy all objects in the source bucket"`
-	Region       string `arg:"--region" help:"AWS region" default:"us-east-1"`
-	StorageClass string `arg:"--storage-class" placeholder:"CLASS" help:"Storage class to apply to the copied object" default:"STANDARD"`
-	Timeout      int    `arg:"-t,--timeout" placeholder:"SECONDS" help:"Copy timeout in seconds" default:"60"`
-	Wait         bool   `arg:"-w,--wait" help:"Wait for the item to be copied"`
+	Source                   string        `arg:"positional,env:S3BCP_SOURCE" help:"Source bucket"`
+	Destination              string        `arg:"positional,env:S3BCP_DESTINATION" help:"Destination bucket"`
+	AbortIncompleteUploads   string        `arg:"--abort-incomplete-uploads,env:S3BCP_ABORT_INCOMPLETE_UPLOADS" placeholder:"URL" help:"Abort the multipart uploads under the s3:// bucket URL older than --older-than and exit without copying"`
+	AcceptRequesterPays      bool          `arg:"--accept-requester-pays,env:S3BCP_ACCEPT_REQUESTER_PAYS" help:"Acknowledge that --request-payer requester charges the requests and transfers to your account"`
+	ACL                      string        `arg:"-a,--acl,env:S3BCP_ACL" help:"ACL to apply to the copied object"`
+	AddPrefix                string        `arg:"--add-prefix,env:S3BCP_ADD_PREFIX" placeholder:"PREFIX" help:"Prepend the prefix to the destination keys, after --strip-prefix and --flatten"`
+	AddTag                   []string      `arg:"--add-tag,separate,env:S3BCP_ADD_TAG" placeholder:"KEY=VALUE" help:"Tag of the copied object, can be repeated; values may use {{.RunID}} and {{.Now}} (replaces the source tags unless --copy-tags is set)"`
+	AllowSame                bool          `arg:"--allow-same,env:S3BCP_ALLOW_SAME" help:"Allow copying objects onto themselves or under the source prefix"`
+	AllVersions              bool          `arg:"--all-versions,env:S3BCP_ALL_VERSIONS" help:"Copy every version of the listed objects oldest first, the destination bucket needs versioning to keep them (--recursive only)"`
+	AttributesFile           string        `arg:"--attributes-file,env:S3BCP_ATTRIBUTES_FILE" placeholder:"FILE" help:"Write the attributes captured by --head-before-copy to the file as JSON lines instead of the log"`
+	AutoThrottle             bool          `arg:"--auto-throttle,env:S3BCP_AUTO_THROTTLE" help:"Halve the --max-rate while over 5% of the requests fail with 5xx or throttling errors, and raise it back while they succeed"`
+	CacheControl             string        `arg:"--cache-control,env:S3BCP_CACHE_CONTROL" placeholder:"VALUE" help:"Cache-Control header of the copied object (sets the REPLACE metadata directive)"`
+	Concurrency              int           `arg:"-c,--concurrency,env:S3BCP_CONCURRENCY" placeholder:"NUM" help:"Number of concurrent transfers" default:"10"`
+	Config                   string        `arg:"--config,env:S3BCP_CONFIG" placeholder:"FILE" help:"Load options from a YAML or TOML file"`
+	ContentDisposition       string        `arg:"--content-disposition,env:S3BCP_CONTENT_DISPOSITION" placeholder:"VALUE" help:"Content-Disposition header of the copied object (sets the REPLACE metadata directive)"`
+	ContentEncoding          string        `arg:"--content-encoding,env:S3BCP_CONTENT_ENCODING" placeholder:"VALUE" help:"Content-Encoding header of the copied object (sets the REPLACE metadata directive)"`
+	ContentLanguage          string        `arg:"--content-language,env:S3BCP_CONTENT_LANGUAGE" placeholder:"VALUE" help:"Content-Language header of the copied object (sets the REPLACE metadata directive)"`
+	ContentType              string        `arg:"--content-type,env:S3BCP_CONTENT_TYPE" placeholder:"VALUE" help:"Content-Type header of the copied object (sets the REPLACE metadata directive)"`
+	CopyIfNewer              bool          `arg:"--copy-if-newer,env:S3BCP_COPY_IF_NEWER" help:"Copy only the objects that are missing in the destination or modified there earlier than in the source"`
+	CopyTags                 bool          `arg:"--copy-tags,env:S3BCP_COPY_TAGS" help:"Keep the source tags when --add-tag is set"`
+	CountOnly                bool          `arg:"--count-only,env:S3BCP_COUNT_ONLY" help:"Only print the count, total size and storage classes of the listed objects without copying"`
+	CountTolerance           float64       `arg:"--count-tolerance,env:S3BCP_COUNT_TOLERANCE" placeholder:"PCT" help:"Allowed deviation of the enumerated count from --expect-count in percent"`
+	CreateDestBucket         bool          `arg:"--create-dest-bucket,env:S3BCP_CREATE_DEST_BUCKET" help:"Create the destination bucket in the region if it doesn't exist"`
+	DedupBy                  string        `arg:"--dedup-by,env:S3BCP_DEDUP_BY" placeholder:"MODE" help:"Copy the objects with the same content once, by etag"`
+	DeleteSource             bool          `arg:"--delete-source,env:S3BCP_DELETE_SOURCE" help:"Delete the source objects after copying (move)"`
+	Delimiter                string        `arg:"--delimiter,env:S3BCP_DELIMITER" placeholder:"DELIMITER" help:"List the --recursive source one level of the delimiter (e.g. /) at a time, descending into the common prefixes in the --traversal order"`
+	DestKeyTemplate          string        `arg:"--dest-key-template,env:S3BCP_DEST_KEY_TEMPLATE" placeholder:"TEMPLATE" help:"Go template of the destination key under the destination path with {{.Key}}, {{.Base}}, {{.Dir}} and {{.Ext}} of the source key"`
+	DestRoleArn              string        `arg:"--dest-role-arn,env:S3BCP_DEST_ROLE_ARN" placeholder:"ARN" help:"Role assumed for the copies and other destination requests, it also needs read access to the source objects"`
+	DryRunDiff               bool          `arg:"--dry-run-diff,env:S3BCP_DRY_RUN_DIFF" help:"Print which objects would be new, overwritten or unchanged at the destination without copying"`
+	EnableDestVersioning     bool          `arg:"--enable-dest-versioning,env:S3BCP_ENABLE_DEST_VERSIONING" help:"Enable the versioning of the destination bucket for --all-versions instead of refusing to copy into an unversioned bucket"`
+	EncodingType             string        `arg:"--encoding-type,env:S3BCP_ENCODING_TYPE" placeholder:"TYPE" help:"Set to url to list the keys URL-encoded and decode them, for the keys with characters that XML can't carry"`
+	ErrorsOnly               bool          `arg:"--errors-only,env:S3BCP_ERRORS_ONLY" help:"Log only the errors and the final summary, e.g. for cron jobs"`
+	ExcludePrefix            []string      `arg:"--exclude-prefix,separate,env:S3BCP_EXCLUDE_PREFIX" placeholder:"PREFIX" help:"Don't copy the source keys starting with the prefix, can be repeated (the listing skips over the excluded keys)"`
+	ExpectCount              int64         `arg:"--expect-count,env:S3BCP_EXPECT_COUNT" placeholder:"N" help:"Enumerate all objects first and exit with code 9 before copying when their count isn't N (within --count-tolerance)"`
+	ExportMetadata           string        `arg:"--export-metadata,env:S3BCP_EXPORT_METADATA" placeholder:"FILE" help:"Head each source object and write its key, size, ETag, storage class, content type and user metadata to the file, as CSV for a .csv file and JSON lines otherwise"`
+	FailOnEmpty              bool          `arg:"--fail-on-empty,env:S3BCP_FAIL_ON_EMPTY" help:"Exit with code 10 when the filtered listing has no objects to copy, e.g. on a mistyped prefix"`
+	FailuresFile             string        `arg:"--failures-file,env:S3BCP_FAILURES_FILE" placeholder:"FILE" help:"Append the failed objects with their errors to the JSON lines file as they fail"`
+	FetchOwner               bool          `arg:"--fetch-owner,env:S3BCP_FETCH_OWNER" help:"List the owners of the objects for the owner field of --filter"`
+	Filter                   string        `arg:"--filter,env:S3BCP_FILTER" placeholder:"EXPR" help:"Copy only the listed objects matching the expression, e.g. 'size > 10MB && key ~ \"^logs/\" && storageClass == \"STANDARD\"'"`
+	Flatten                  bool          `arg:"--flatten,env:S3BCP_FLATTEN" help:"Drop the directories of the destination keys and keep the base names, after --strip-prefix and before --add-prefix"`
+	Format                   string        `arg:"--format,env:S3BCP_FORMAT" placeholder:"FORMAT" help:"Format of the --dry-run-diff plan, the --list-only objects, the --count-only count and the summary: text, csv or json" default:"text"`
+	GrantFullControl         string        `arg:"--grant-full-control,env:S3BCP_GRANT_FULL_CONTROL" placeholder:"GRANTEES" help:"Grant READ, READ_ACP and WRITE_ACP on the copied object to the comma-separated id=, uri= or emailAddress= grantees"`
+	GrantRead                string        `arg:"--grant-read,env:S3BCP_GRANT_READ" placeholder:"GRANTEES" help:"Grant reading the copied object and its metadata to the grantees"`
+	GrantReadACP             string        `arg:"--grant-read-acp,env:S3BCP_GRANT_READ_ACP" placeholder:"GRANTEES" help:"Grant reading the ACL of the copied object to the grantees"`
+	GrantWriteACP            string        `arg:"--grant-write-acp,env:S3BCP_GRANT_WRITE_ACP" placeholder:"GRANTEES" help:"Grant writing the ACL of the copied object to the grantees"`
+	HeadBeforeCopy           bool          `arg:"--head-before-copy,env:S3BCP_HEAD_BEFORE_COPY" help:"Head each source object and record its size, storage class, ETag and content type before copying"`
+	IncludeDeleteMarkers     bool          `arg:"--include-delete-markers,env:S3BCP_INCLUDE_DELETE_MARKERS" help:"Recreate the delete markers of --all-versions in the version history of the destination"`
+	InventoryManifest        string        `arg:"--inventory-manifest,env:S3BCP_INVENTORY_MANIFEST" placeholder:"URL" help:"Copy the objects listed in the S3 Inventory report with the s3:// manifest.json URL instead of listing the source bucket"`
+	Jobs                     string        `arg:"--jobs,env:S3BCP_JOBS" placeholder:"FILE" help:"Copy the objects of the JSON lines file with the source and optional dest key, storageClass and tags of each object"`
+	KeepSourceOnVerifyFail   bool          `arg:"--keep-source-on-verify-fail,env:S3BCP_KEEP_SOURCE_ON_VERIFY_FAIL" help:"Don't delete the source object when --verify fails, disable with =false" default:"true"`
+	ListCache                string        `arg:"--list-cache,env:S3BCP_LIST_CACHE" placeholder:"FILE" help:"Save the listing of the source (keys, sizes and ETags) to the file for the runs with --use-list-cache"`
+	ListCacheTTL             time.Duration `arg:"--list-cache-ttl,env:S3BCP_LIST_CACHE_TTL" placeholder:"DURATION" help:"Warn when the listing loaded with --use-list-cache is older than this" default:"24h"`
+	ListOnly                 bool          `arg:"--list-only,env:S3BCP_LIST_ONLY" help:"List (and head for --export-metadata) the objects without copying them"`
+	ListWorkers              int           `arg:"--list-workers,env:S3BCP_LIST_WORKERS" placeholder:"NUM" help:"Number of concurrent listings of --parallel-listing" default:"8"`
+	Manifest                 string        `arg:"--manifest,env:S3BCP_MANIFEST" placeholder:"FILE" help:"Copy the source keys of the file, one per line with an optional tab-separated version ID"`
+	MatchRegex               []string      `arg:"--match-regex,separate,env:S3BCP_MATCH_REGEX" placeholder:"REGEX" help:"Copy only the keys matching one of the regular expressions, can be repeated"`
+	MaxConnsPerHost          int           `arg:"--max-conns-per-host,env:S3BCP_MAX_CONNS_PER_HOST" placeholder:"NUM" help:"Maximum connections to the S3 endpoint, requests wait for a free connection (default: 2x --concurrency)"`
+	MaxIdleConns             int           `arg:"--max-idle-conns,env:S3BCP_MAX_IDLE_CONNS" placeholder:"NUM" help:"Idle connections kept open for reuse between requests (default: 2x --concurrency)"`
+	MaxObjectSize            string        `arg:"--max-object-size,env:S3BCP_MAX_OBJECT_SIZE" placeholder:"SIZE" help:"Refuse to copy the objects whose listed size is larger, e.g. 10GB, as a safety rail handled by --on-oversize"`
+	MaxRate                  float64       `arg:"--max-rate,env:S3BCP_MAX_RATE" placeholder:"PER_SECOND" help:"Limit the requests per second of the whole run, with the retries"`
+	MaxRetries               int           `arg:"--max-retries,env:S3BCP_MAX_RETRIES" placeholder:"NUM" help:"Maximum number of retries of each request" default:"3"`
+	MergeMetadata            bool          `arg:"--merge-metadata,env:S3BCP_MERGE_METADATA" help:"Keep the source headers and metadata on REPLACE and change only the ones given by the flags"`
+	Metadata                 []string      `arg:"--metadata,separate,env:S3BCP_METADATA" placeholder:"KEY=VALUE" help:"User metadata of the copied object, can be repeated (sets the REPLACE metadata directive)"`
+	MetadataDirective        string        `arg:"--metadata-directive,env:S3BCP_METADATA_DIRECTIVE" placeholder:"DIRECTIVE" help:"Metadata directive of the copy: auto (REPLACE with the content header, metadata, --merge-metadata and --preserve-timestamp flags, otherwise COPY), COPY or REPLACE" default:"auto"`
+	MetadataFilter           []string      `arg:"--metadata-filter,separate,env:S3BCP_METADATA_FILTER" placeholder:"KEY=VALUE" help:"Copy only the objects with the user metadata value, can be repeated (heads every object)"`
+	NewerGrace               time.Duration `arg:"--newer-grace,env:S3BCP_NEWER_GRACE" placeholder:"DURATION" help:"Consider the source newer only when modified this much later than the destination (e.g. 2s), to allow for clock skew"`
+	ObjectCountEstimate      bool          `arg:"--object-count-estimate,env:S3BCP_OBJECT_COUNT_ESTIMATE" help:"Estimate the total of --progress-file from the daily NumberOfObjects metric of the whole source bucket in CloudWatch until the listing completes"`
+	ObjectLockLegalHold      string        `arg:"--object-lock-legal-hold,env:S3BCP_OBJECT_LOCK_LEGAL_HOLD" placeholder:"STATUS" help:"Set the legal hold of the copies, ON or OFF, with or without a retention"`
+	ObjectLockMode           string        `arg:"--object-lock-mode,env:S3BCP_OBJECT_LOCK_MODE" placeholder:"MODE" help:"Set the retention mode of the copies, GOVERNANCE or COMPLIANCE, together with --object-lock-retain-until"`
+	ObjectLockRetainUntil    string        `arg:"--object-lock-retain-until,env:S3BCP_OBJECT_LOCK_RETAIN_UNTIL" placeholder:"DATE" help:"Retain the copies until the date (2006-01-02 or RFC 3339), together with --object-lock-mode"`
+	OlderThan                time.Duration `arg:"--older-than,env:S3BCP_OLDER_THAN" placeholder:"DURATION" help:"Minimum age of the multipart uploads aborted by --abort-incomplete-uploads" default:"24h"`
+	OnConflict               string        `arg:"--on-conflict,env:S3BCP_ON_CONFLICT" placeholder:"POLICY" help:"What to do when rewritten keys of several objects collide: overwrite, skip all but the smallest source key, or hash (append a hash of the source key and ETag to all of them)" default:"overwrite"`
+	OnlyDirectoryMarkers     bool          `arg:"--only-directory-markers,env:S3BCP_ONLY_DIRECTORY_MARKERS" help:"Copy only the directory markers, the zero-byte objects with keys ending in /"`
+	OnNotFound               string        `arg:"--on-not-found,env:S3BCP_ON_NOT_FOUND" placeholder:"POLICY" help:"What to do with the keys of --manifest, --jobs or --retry-failed missing in the source: fail (count as failed) or skip" default:"fail"`
+	OnOversize               string        `arg:"--on-oversize,env:S3BCP_ON_OVERSIZE" placeholder:"POLICY" help:"What to do with the objects over --max-object-size: skip, or fail (count as failed and exit with code 11)" default:"skip"`
+	OverwriteOnlyIfOlderDest bool          `arg:"--overwrite-only-if-older-dest,env:S3BCP_OVERWRITE_ONLY_IF_OLDER_DEST" help:"Skip the objects whose destination object was modified later than the source, e.g. updated independently"`
+	OwnerID                  string        `arg:"--owner-id,env:S3BCP_OWNER_ID" placeholder:"ID" help:"Copy only the objects owned by the canonical user ID, listed with the owners"`
+	PageAtATime              bool          `arg:"--page-at-a-time,env:S3BCP_PAGE_AT_A_TIME" help:"Copy all objects of a listed page before listing the next one, bounding the keys in flight to a page for a predictable memory use"`
+	Pairs                    string        `arg:"--pairs,env:S3BCP_PAIRS" placeholder:"FILE" help:"Copy every line of tab-separated s3:// source and destination URLs of the file, - reads stdin"`
+	ParallelListing          bool          `arg:"--parallel-listing,env:S3BCP_PARALLEL_LISTING" help:"List the source keyspace in partitions split by the first character after the prefix concurrently"`
+	PerPrefixRate            float64       `arg:"--per-prefix-rate,env:S3BCP_PER_PREFIX_RATE" placeholder:"PER_SECOND" help:"Limit the copies per second under each first path segment below the source prefix, to spare the partitions of a shared bucket"`
+	Preflight                bool          `arg:"--preflight,env:S3BCP_PREFLIGHT" help:"Check the access to both buckets and the KMS decrypt permission of an SSE-KMS test object, then copy it to a temporary key before the run"`
+	PreserveObjectLock       bool          `arg:"--preserve-object-lock,env:S3BCP_PRESERVE_OBJECT_LOCK" help:"Apply the Object Lock retention and legal hold of the source object to the copy"`
+	PreserveStorageClass     bool          `arg:"--preserve-storage-class,env:S3BCP_PRESERVE_STORAGE_CLASS" help:"Copy each listed object to its storage class, the GLACIER and DEEP_ARCHIVE objects to --storage-class"`
+	PreserveTimestamp        bool          `arg:"--preserve-timestamp,env:S3BCP_PRESERVE_TIMESTAMP" help:"Store the source LastModified in the x-amz-meta-original-last-modified metadata"`
+	Profile                  string        `arg:"--profile,env:S3BCP_PROFILE" placeholder:"PROFILE" help:"Preset of the concurrency, rate, retry and connection flags: fast, safe or throttle-friendly, the flags still override it"`
+	ProgressFile             string        `arg:"--progress-file,env:S3BCP_PROGRESS_FILE" placeholder:"FILE" help:"Write the progress as JSON with the counts, rate and ETA to the file every --report-interval"`
+	RampUp                   time.Duration `arg:"--ramp-up,env:S3BCP_RAMP_UP" placeholder:"DURATION" help:"Stagger the start of the concurrent transfers over this window (e.g. 30s) to let S3 scale"`
+	Range                    string        `arg:"--range,env:S3BCP_RANGE" placeholder:"bytes=START-END" help:"Copy only the byte range of the source object into the destination (non-recursive only)"`
+	Recursive                bool          `arg:"-r,--recursive,env:S3BCP_RECURSIVE" help:"Recursively copy all objects in the source bucket"`
+	Region                   string        `arg:"--region,env:S3BCP_REGION" help:"AWS region, resolved from AWS_REGION, AWS_DEFAULT_REGION or the shared config when not set (fallback us-east-1)"`
+	RegionMap                string        `arg:"--region-map,env:S3BCP_REGION_MAP" placeholder:"FILE" help:"YAML or TOML file of bucket names and their regions for the copies of --pairs, the unmapped buckets are located with GetBucketLocation"`
+	RejectRegex              []string      `arg:"--reject-regex,separate,env:S3BCP_REJECT_REGEX" placeholder:"REGEX" help:"Skip the keys matching one of the regular expressions, can be repeated and takes precedence over --match-regex"`
+	RemainingManifest        string        `arg:"--remaining-manifest,env:S3BCP_REMAINING_MANIFEST" placeholder:"FILE" help:"When --time-budget is reached, keep listing and write the unscheduled keys to the file for the --manifest of the next run"`
+	ReplicaBucket            string        `arg:"--replica-bucket,env:S3BCP_REPLICA_BUCKET" placeholder:"BUCKET" help:"Copy each object to this bucket too, in the background after its primary copy succeeded, with a separate summary, exit with code 12 when a replica fails"`
+	ReplicaRegion            string        `arg:"--replica-region,env:S3BCP_REPLICA_REGION" placeholder:"REGION" help:"Region of the --replica-bucket, the region of the session when not set"`
+	ReportInterval           time.Duration `arg:"--report-interval,env:S3BCP_REPORT_INTERVAL" placeholder:"DURATION" help:"Interval of the --progress-file updates" default:"10s"`
+	RequestPayer             string        `arg:"--request-payer,env:S3BCP_REQUEST_PAYER" placeholder:"PAYER" help:"Set to requester to access Requester Pays buckets, needs --accept-requester-pays"`
+	RetryBaseDelay           time.Duration `arg:"--retry-base-delay,env:S3BCP_RETRY_BASE_DELAY" placeholder:"DURATION" help:"Delay of the first retry, doubled on every next retry up to --retry-max-delay (default the SDK backoff)"`
+	RetryBudget              int           `arg:"--retry-budget,env:S3BCP_RETRY_BUDGET" placeholder:"N" help:"Cap the retries of all requests over the whole run, no limit when 0"`
+	RetryCodes               []string      `arg:"--retry-codes,separate,env:S3BCP_RETRY_CODES" placeholder:"CODES" help:"Comma-separated error codes or HTTP status codes to retry, can be repeated, the others fail immediately (default throttling, timeout, connection and 5xx errors)"`
+	RetryFailed              string        `arg:"--retry-failed,env:S3BCP_RETRY_FAILED" placeholder:"FILE" help:"Copy only the failed objects of the source bucket listed in the --summary-json file of a previous run"`
+	RetryJitter              float64       `arg:"--retry-jitter,env:S3BCP_RETRY_JITTER" placeholder:"FRACTION" help:"Reduce every retry delay of --retry-base-delay by a random part up to the fraction, 0 to 1"`
+	RetryMaxDelay            time.Duration `arg:"--retry-max-delay,env:S3BCP_RETRY_MAX_DELAY" placeholder:"DURATION" help:"Maximum delay of the retries of --retry-base-delay (default 5m)"`
+	RetryMode                string        `arg:"--retry-mode,env:S3BCP_RETRY_MODE" placeholder:"MODE" help:"Retry mode: standard or adaptive (throttling backs off all requests together)" default:"standard"`
+	RunID                    string        `arg:"--run-id,env:S3BCP_RUN_ID" placeholder:"ID" help:"ID of the run for the {{.RunID}} tag template (default generated)"`
+	ShutdownTimeout          time.Duration `arg:"--shutdown-timeout,env:S3BCP_SHUTDOWN_TIMEOUT" placeholder:"DURATION" help:"After an interrupt, wait this long for the started copies before canceling them and exiting with code 130" default:"30s"`
+	SkipDirectoryMarkers     bool          `arg:"--skip-directory-markers,env:S3BCP_SKIP_DIRECTORY_MARKERS" help:"Skip the directory markers, the zero-byte objects with keys ending in /"`
+	SkipExisting             bool          `arg:"--skip-existing,env:S3BCP_SKIP_EXISTING" help:"Skip the objects that already exist at the destination"`
+	SkipExistingMode         string        `arg:"--skip-existing-mode,env:S3BCP_SKIP_EXISTING_MODE" placeholder:"MODE" help:"How --skip-existing, --copy-if-newer and --overwrite-only-if-older-dest check the destination: head (a request per object) or list (a single listing of the destination prefix)" default:"head"`
+	SkipIfDestMatches        bool          `arg:"--skip-if-dest-matches,env:S3BCP_SKIP_IF_DEST_MATCHES" help:"Head each destination object and copy with its ETag as the CopySourceIfNoneMatch precondition, skipping the unchanged objects"`
+	SlowObjectWarn           time.Duration `arg:"--slow-object-warn,env:S3BCP_SLOW_OBJECT_WARN" placeholder:"DURATION" help:"Warn about the copies that take longer than this (e.g. 5m), with the key and the elapsed time, without failing them"`
+	Sort                     string        `arg:"--sort,env:S3BCP_SORT" placeholder:"ORDER" help:"Hold all objects in memory and process them in the order: none (as listed), lexical, size (largest first) or modified (oldest first)" default:"none"`
+	SourceRoleArn            string        `arg:"--source-role-arn,env:S3BCP_SOURCE_ROLE_ARN" placeholder:"ARN" help:"Role assumed for listing, reading and deleting the source objects"`
+	SplitBySize              bool          `arg:"--split-by-size,env:S3BCP_SPLIT_BY_SIZE" help:"Assign the objects to the workers by the listed sizes, balancing the bytes per worker instead of a shared queue"`
+	StorageClass             string        `arg:"--storage-class,env:S3BCP_STORAGE_CLASS" placeholder:"CLASS" help:"Storage class to apply to the copied object" default:"STANDARD"`
+	StripPrefix              string        `arg:"--strip-prefix,env:S3BCP_STRIP_PREFIX" placeholder:"PREFIX" help:"Remove the prefix from the source keys before --flatten and --add-prefix"`
+	SummaryJSON              string        `arg:"--summary-json,env:S3BCP_SUMMARY_JSON" placeholder:"FILE" help:"Write the summary with the failed objects to the JSON file, for --retry-failed"`
+	TagAfterCopy             bool          `arg:"--tag-after-copy,env:S3BCP_TAG_AFTER_COPY" help:"Set the tags with a separate PutObjectTagging request after the copy, for backends that ignore the tags of CopyObject (used automatically once a copy with tags is rejected)"`
+	TimeBudget               time.Duration `arg:"--time-budget,env:S3BCP_TIME_BUDGET" placeholder:"DURATION" help:"Stop scheduling new copies after this wall-clock time (e.g. 30m), finish the started ones and exit with code 8"`
+	Timeout                  int           `arg:"-t,--timeout,env:S3BCP_TIMEOUT" placeholder:"SECONDS" help:"Timeout of the whole run in seconds, 0 for none, it must be longer than --time-budget" default:"60"`
+	Traversal                string        `arg:"--traversal,env:S3BCP_TRAVERSAL" placeholder:"ORDER" help:"Order of the --delimiter descent: breadth (every level before the next, spreading the load) or depth (every subtree before its next sibling)" default:"depth"`
+	UseListCache             bool          `arg:"--use-list-cache,env:S3BCP_USE_LIST_CACHE" help:"Take the objects from the --list-cache file instead of listing the source, the source is listed when the file doesn't exist yet"`
+	Verify                   bool          `arg:"--verify,env:S3BCP_VERIFY" help:"Check the size and ETag of the copied object before reporting it copied or deleting the source, the copies get SHA256 checksums compared with the ones of the sources that have no MD5 ETag"`
+	VerifyCount              bool          `arg:"--verify-count,env:S3BCP_VERIFY_COUNT" help:"List the destination after copying and report the copied objects that are missing"`
+	Wait                     bool          `arg:"-w,--wait,env:S3BCP_WAIT" help:"Wait for the item to be copied"`
+	WaitInterval             time.Duration `arg:"--wait-interval,env:S3BCP_WAIT_INTERVAL" placeholder:"DURATION" help:"Delay between the polls of the waiter of --wait" default:"5s"`
+	WaitMaxAttempts          int           `arg:"--wait-max-attempts,env:S3BCP_WAIT_MAX_ATTEMPTS" placeholder:"N" help:"Maximum polls of the waiter of --wait" default:"20"`
+	WaitMode                 string        `arg:"--wait-mode,env:S3BCP_WAIT_MODE" placeholder:"MODE" help:"How --wait checks the copy: waiter (poll until it exists) or head (a single request, the copy is synchronous)" default:"waiter"`
+	Webhook                  string        `arg:"--webhook,env:S3BCP_WEBHOOK" placeholder:"URL" help:"POST a JSON notification with the source, target, bytes and timestamp of every copied object to the URL"`
+	WebhookRequired          bool          `arg:"--webhook-required,env:S3BCP_WEBHOOK_REQUIRED" help:"Wait for the --webhook notification of every copy and count the copy as failed when it fails"`
+	WorkerErrorBackoff       time.Duration `arg:"--worker-error-backoff,env:S3BCP_WORKER_ERROR_BACKOFF" placeholder:"DURATION" help:"Pause a worker for this long (e.g. 30s) after --worker-error-threshold consecutive failures"`
+	WorkerErrorThreshold     int           `arg:"--worker-error-threshold,env:S3BCP_WORKER_ERROR_THRESHOLD" placeholder:"N" help:"Consecutive failures of a worker that trigger --worker-error-backoff" default:"5"`
+}
+
+// defaultRegion is used when the region is set neither by the flag
+// nor by the AWS environment and shared config.
+const defaultRegion = "us-east-1"
+
+// newSession initializes a session in that the SDK will use to load
+// credentials from the shared credentials file ~/.aws/credentials
+// and the region from the environment or the shared config file.
+func newSession() (*session.Session, error) {
+	retryer, err := newRetryer(args.RetryMode, args.MaxRetries, args.RetryBudget, args.RetryCodes,
+		retryBackoff{base: args.RetryBaseDelay, max: args.RetryMaxDelay, jitter: args.RetryJitter})
+	if err != nil {
+		return nil, err
+	}
+	maxIdle, maxPerHost := connectionLimits(args.MaxIdleConns, args.MaxConnsPerHost, args.Concurrency)
+	config := aws.Config{
+		HTTPClient: &http.Client{Transport: newTransport(maxIdle, maxPerHost)},
+		// Keep the double slashes of the keys in the request paths, see joinKey.
+		DisableRestProtocolURICleaning: aws.Bool(true),
+		// Send the requests of the access point ARNs to the region of the access point.
+		S3UseARNRegion: aws.Bool(true),
+	}
+	request.WithRetryer(&config, retryer)
+	if args.Region != "" {
+		config.Region = aws.String(args.Region)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            config,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.Region = aws.String(defaultRegion)
+	}
+	if retryer.adaptive {
+		sess.Handlers.Complete.PushBack(retryer.complete)
+	}
+	if args.RequestPayer == s3.RequestPayerRequester {
+		// Every request of the clients is charged to the caller, built before they are signed.
+		sess.Handlers.Build.PushBack(func(r *request.Request) {
+			r.HTTPRequest.Header.Set("x-amz-request-payer", s3.RequestPayerRequester)
+		})
+	}
+	return sess, nil
+}
+
+// newClient returns the S3 client of the session, with the credentials
+// of the assumed role when the role ARN is set.
+func newClient(sess *session.Session, roleARN string) *s3.S3 {
+	if roleARN == "" {
+		return s3.New(sess)
+	}
+	return s3.New(sess, &aws.Config{Credentials: stscreds.NewCredentials(sess, roleARN)})
+}
+
+// newLoggers returns the logger of the progress messages, which discards them
+// with --errors-only, and the logger of the final summary and counts.
+func newLoggers(w io.Writer, errorsOnly bool) (info, summary *log.Logger) {
+	summary = log.New(w, "", 0)
+	if errorsOnly {
+		return log.New(io.Discard, "", 0), summary
+	}
+	return summary, summary
 }
 
 func main() {
-	arg.MustParse(&args)
 	logerr := log.New(os.Stderr, "", 0)
-	loginfo := log.New(os.Stdout, "", 0)
 
-	source, err := url.Parse(args.Source)
+	p := arg.MustParse(&args)
+	// The profile and then the options from the config file replace the values
+	// of the flags that the command line and the environment didn't give.
+	given, err := givenFlags(os.Args[1:], &args)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	if args.Profile != "" {
+		if err := applyProfile(args.Profile, &args, given); err != nil {
+			logerr.Println(err)
+			os.Exit(3)
+		}
+	}
+	if args.Config != "" {
+		if err := loadConfig(args.Config, &args, given); err != nil {
+			logerr.Println(err)
+			os.Exit(6)
+		}
+	}
+	loginfo, logsummary := newLoggers(os.Stdout, args.ErrorsOnly)
+
+	if args.AbortIncompleteUploads != "" {
+		// Maintenance mode, runs without copying
+		if args.Source != "" || args.Destination != "" {
+			p.Fail("--abort-incomplete-uploads runs without SOURCE and DESTINATION")
+		}
+		bucket, err := url.Parse(args.AbortIncompleteUploads)
+		if err != nil || bucket.Scheme != "s3" || bucket.Host == "" {
+			p.Fail("--abort-incomplete-uploads must be an s3://bucket/prefix url")
+		}
+		sess, err := newSession()
+		if err != nil {
+			logerr.Printf("Failed to create AWS session: %v\n", err)
+			os.Exit(4)
+		}
+		cutoff := time.Now().Add(-args.OlderThan)
+		aborted, err := abortIncompleteUploads(context.Background(), s3.New(sess), bucket.Host, strings.TrimPrefix(bucket.Path, "/"), cutoff,
+			func(upload *s3.MultipartUpload, err error) {
+				if err != nil {
+					logerr.Printf("Failed to abort upload %s of object %s: %v\n", aws.StringValue(upload.UploadId), aws.StringValue(upload.Key), err)
+					return
+				}
+				loginfo.Printf("Aborted upload %s of object %q initiated at %s\n",
+					aws.StringValue(upload.UploadId), aws.StringValue(upload.Key), aws.TimeValue(upload.Initiated).Format(time.RFC3339))
+			})
+		if err != nil {
+			logerr.Printf("Failed to list multipart uploads for bucket %s: %v\n", bucket.Host, err)
+			os.Exit(5)
+		}
+		loginfo.Printf("Aborted %d incomplete multipart uploads in bucket %q\n", aborted, bucket.Host)
+		return
+	}
+	var source, target *url.URL
+	if args.Pairs != "" {
+		// Every pair has its own buckets and keys
+		if args.Source != "" || args.Destination != "" {
+			p.Fail("--pairs reads the source and destination of every copy and runs without SOURCE and DESTINATION")
+		}
+		source, target = &url.URL{Scheme: "s3"}, &url.URL{Scheme: "s3"}
+	} else {
+		if args.Source == "" || args.Destination == "" {
+			p.Fail("SOURCE and DESTINATION are required")
+		}
+		source, err = parseS3URL(args.Source)
+		if err != nil {
+			logerr.Printf(err.Error())
+			os.Exit(1)
+		}
+		target, err = parseS3URL(args.Destination)
+		if err != nil {
+			logerr.Printf(err.Error())
+			os.Exit(2)
+		}
+		if source.Scheme != "s3" || target.Scheme != "s3" {
+			logerr.Println("Source and target must be s3:// urls")
+			os.Exit(3)
+		}
+	}
+	if err := validateArgs(source, target); err != nil {
+		p.Fail(err.Error())
+	}
+
+	metadata, err := parseKeyValues(args.Metadata)
 	if err != nil {
-		logerr.Printf(err.Error())
-		os.Exit(1)
+		logerr.Printf("Invalid metadata: %v\n", err)
+		os.Exit(3)
 	}
-	target, err := url.Parse(args.Destination)
+	metadataFilter, err := parseKeyValues(args.MetadataFilter)
 	if err != nil {
-		logerr.Printf(err.Error())
-		os.Exit(2)
+		logerr.Printf("Invalid metadata filter: %v\n", err)
+		os.Exit(3)
+	}
+	startTime := time.Now()
+	if args.RunID == "" {
+		args.RunID = newRunID(startTime)
 	}
-	if source.Scheme != "s3" || target.Scheme != "s3" {
-		logerr.Println("Source and target must be s3:// urls")
+	tags, err := renderTags(args.AddTag, tagTemplateData{RunID: args.RunID, Now: startTime.UTC().Format(time.RFC3339)})
+	if err != nil {
+		logerr.Printf("Invalid tag: %v\n", err)
 		os.Exit(3)
 	}
+	var attributes *jsonLinesWriter
+	if args.AttributesFile != "" {
+		f, err := os.Create(args.AttributesFile)
+		if err != nil {
+			logerr.Printf("Failed to create attributes file: %v\n", err)
+			os.Exit(6)
+		}
+		defer f.Close()
+		attributes = newJSONLinesWriter(f)
+	}
+	var export *metadataExport
+	if args.ExportMetadata != "" {
+		f, err := os.Create(args.ExportMetadata)
+		if err != nil {
+			logerr.Printf("Failed to create metadata export file: %v\n", err)
+			os.Exit(6)
+		}
+		defer f.Close()
+		export = newMetadataExport(args.ExportMetadata, f)
+	}
 
-	// Initialize a session in that the SDK will use to load
-	// credentials from the shared credentials file ~/.aws/credentials.
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(args.Region)},
-	)
+	var failures *jsonLinesWriter
+	if args.FailuresFile != "" {
+		f, err := os.OpenFile(args.FailuresFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			logerr.Printf("Failed to open failures file: %v\n", err)
+			os.Exit(6)
+		}
+		defer f.Close()
+		failures = newJSONLinesWriter(f)
+	}
+
+	out, err := newOutput(args.Format, os.Stdout)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	conflicts, err := newConflictResolver(args.OnConflict)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	grants, err := parseGrants()
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	lock, err := parseObjectLock(time.Now())
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	var filter filterExpr
+	if args.Filter != "" {
+		filter, err = parseFilter(args.Filter)
+		if err != nil {
+			logerr.Printf("Invalid filter: %v\n", err)
+			os.Exit(3)
+		}
+	}
+	var maxObjectSize int64
+	if args.MaxObjectSize != "" {
+		maxObjectSize, err = parseSize(args.MaxObjectSize)
+		if err != nil || maxObjectSize <= 0 {
+			p.Fail("--max-object-size must be a positive size, e.g. 10GB")
+		}
+	}
+	patterns, err := compileKeyPatterns(args.MatchRegex, args.RejectRegex)
+	if err != nil {
+		logerr.Printf("Invalid key pattern: %v\n", err)
+		os.Exit(3)
+	}
+	dedup, err := newDedupIndex(args.DedupBy)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	var byteRange string
+	var rangeSize int64
+	if args.Range != "" {
+		byteRange, rangeSize, err = parseByteRange(args.Range)
+		if err != nil {
+			p.Fail(err.Error())
+		}
+	}
+	metadataDirective, warning, err := resolveMetadataDirective(args.MetadataDirective)
+	if err != nil {
+		logerr.Println(err)
+		os.Exit(3)
+	}
+	if warning != "" {
+		logerr.Printf("Warning: %s\n", warning)
+	}
+
+	var keyTemplate *template.Template
+	if args.DestKeyTemplate != "" {
+		keyTemplate, err = parseKeyTemplate(args.DestKeyTemplate)
+		if err != nil {
+			logerr.Printf("Invalid destination key template: %v\n", err)
+			os.Exit(3)
+		}
+	}
+	keys := newKeyPipeline(args.StripPrefix, args.Flatten, args.AddPrefix, keyTemplate)
+	// Destination key of the source object.
+	targetKey := func(sourcePath string) (string, error) {
+		key, err := keys.rewrite(sourcePath)
+		if err != nil {
+			return "", err
+		}
+		return joinKey(target.Path, key), nil
+	}
+
+	sess, err := newSession()
 	if err != nil {
 		logerr.Printf("Failed to create AWS session: %v\n", err)
 		os.Exit(4)
 	}
 
-	// Create S3 service client
-	svc := s3.New(sess)
+	if args.MaxRate > 0 {
+		throttle := newRequestThrottle(args.MaxRate)
+		sess.Handlers.Send.PushFront(throttle.wait)
+		if args.AutoThrottle {
+			sess.Handlers.Complete.PushBack(throttle.record)
+			go throttle.run(throttleWindow, func(rate, errorRate float64) {
+				loginfo.Printf("Error rate %.1f%% over %s, request rate changed to %.1f/s\n", errorRate*100, throttleWindow, rate)
+			})
+		}
+	}
+
+	// Create S3 service clients for the destination and the source
+	svc := newClient(sess, args.DestRoleArn)
+	sourceSvc := newClient(sess, args.SourceRoleArn)
+	var regionMap map[string]string
+	var sourceRegions, destRegions *regionClients
+	if args.RegionMap != "" {
+		regionMap, err = readRegionMap(args.RegionMap)
+		if err != nil {
+			logerr.Printf("Failed to read region map: %v\n", err)
+			os.Exit(6)
+		}
+		sourceRegions = newRegionClients(sess, args.SourceRoleArn, regionMap)
+		destRegions = newRegionClients(sess, args.DestRoleArn, regionMap)
+	}
 
 	// Create a context with a timeout that will abort the upload if it takes
 	// more than the passed in timeout.
@@ -72,65 +480,744 @@ func main() {
 	if cancelFn != nil {
 		defer cancelFn()
 	}
+	// Stop scheduling on an interrupt and cancel the started copies after the shutdown timeout.
+	ctx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	scheduleCtx, stopScheduling := context.WithCancel(ctx)
+	defer stopScheduling()
+	var interrupted int32
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go watchShutdown(signals, args.ShutdownTimeout, func() {
+		atomic.StoreInt32(&interrupted, 1)
+		stopScheduling()
+		logerr.Printf("Interrupted, waiting up to %s for the started copies (interrupt again to cancel them)\n", args.ShutdownTimeout)
+	}, func() {
+		logerr.Println("Canceling the started copies")
+		cancelRun()
+	})
 
-	semaphore := make(chan struct{}, args.Concurrency)
-	var wg sync.WaitGroup
+	if args.CreateDestBucket && !args.DryRunDiff && !args.CountOnly {
+		created, err := ensureBucket(ctx, svc, target.Host, aws.StringValue(sess.Config.Region))
+		if err != nil {
+			logerr.Printf("Failed to create target bucket %s: %v\n", target.Host, err)
+			os.Exit(5)
+		}
+		if created {
+			loginfo.Printf("Bucket %q created in region %s\n", target.Host, aws.StringValue(sess.Config.Region))
+		}
+	}
 
-	// Object copy function.
-	copyObject := func(sourceBucket, sourcePath, targetBucket, targetPath string) {
-		if args.Recursive {
-			defer func() { <-semaphore }()
-			defer wg.Done()
-		}
-		// Copy the item from the source bucket to the destination bucket.
-		_, err := svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
-			CopySource:   aws.String(url.QueryEscape(path.Join(sourceBucket, sourcePath))),
-			Bucket:       aws.String(targetBucket),
-			Key:          aws.String(targetPath),
-			ACL:          aws.String(args.ACL),
-			StorageClass: aws.String(args.StorageClass),
-		})
+	if args.AllVersions && !args.ListOnly {
+		enabled, err := ensureVersioning(ctx, svc, target.Host, args.EnableDestVersioning)
 		if err != nil {
-			logerr.Printf("Failed to copy object %s: %v\n", sourcePath, err)
-			return
+			logerr.Printf("Failed to copy all versions to target bucket %s: %v\n", target.Host, err)
+			os.Exit(4)
 		}
-		// Wait for the item to be copied
-		if args.Wait {
-			err = svc.WaitUntilObjectExistsWithContext(ctx, &s3.HeadObjectInput{
-				Bucket: aws.String(targetBucket),
-				Key:    aws.String(targetPath),
-			})
+		if enabled {
+			loginfo.Printf("Versioning of bucket %q enabled\n", target.Host)
+		}
+	}
+
+	if (args.ACL != "" || grants != (objectGrants{})) && target.Host != "" && !args.DryRunDiff && !args.CountOnly && !args.ListOnly {
+		// The ACLs are disabled in a bucket with BucketOwnerEnforced, and copies setting them fail.
+		enforced, err := bucketOwnerEnforced(ctx, svc, target.Host)
+		if err != nil {
+			logerr.Printf("Failed to check the object ownership of target bucket %s, keeping the ACL flags: %s\n", target.Host, describeError(err))
+		}
+		if enforced {
+			grants = objectGrants{}
+			if stripped := stripACLFlags(); len(stripped) > 0 {
+				logerr.Printf("Target bucket %s enforces the bucket owner and has the ACLs disabled, ignoring %s\n", target.Host, strings.Join(stripped, ", "))
+			}
+		}
+	}
+
+	if args.Preflight && !args.DryRunDiff && !args.CountOnly {
+		sourceKey := strings.TrimPrefix(source.Path, "/")
+		if bulkMode() {
+			sourceKey, err = firstObject(ctx, sourceSvc, source.Host, sourceKey)
 			if err != nil {
-				logerr.Printf("Failed to wait for object %s: %v\n", targetPath, err)
-				return
+				logerr.Printf("Preflight failed: source bucket %s can't be listed: %s\n", source.Host, describeError(err))
+				os.Exit(4)
 			}
 		}
-		loginfo.Printf("Item %q successfully copied from bucket %q to bucket %q\n", sourcePath, sourceBucket, targetBucket)
+		tempKey := joinKey(target.Path, ".preflight-"+args.RunID)
+		if err := preflight(ctx, sourceSvc, svc, source.Host, sourceKey, target.Host, tempKey); err != nil {
+			logerr.Printf("Preflight failed: %v\n", err)
+			os.Exit(4)
+		}
+		loginfo.Println("Preflight passed")
 	}
 
-	if args.Recursive {
-		// List all objects in the source bucket and copy them to the target bucket
-		err = svc.ListObjectsPagesWithContext(ctx, &s3.ListObjectsInput{
-			Bucket: aws.String(source.Host),
-			Prefix: aws.String(strings.TrimPrefix(source.Path, "/")),
-		}, func(p *s3.ListObjectsOutput, lastPage bool) bool {
-			for _, o := range p.Contents {
-				sourcePath := aws.StringValue(o.Key)
-				targetPath := path.Join(target.Path, sourcePath)
-				wg.Add(1)
-				semaphore <- struct{}{}
-				go copyObject(source.Host, sourcePath, target.Host, targetPath)
+	var stats summary
+	var copied keySet
+	// Existing destination objects, checked with HEAD requests unless prelisted.
+	index := &destIndex{}
+	var wg sync.WaitGroup
+	// Objects that reach the summary, and whether all of them are known.
+	var scheduled int64
+	var listingComplete int32
+	finishProgress := func() {}
+	if args.ProgressFile != "" {
+		var estimate int64
+		if args.ObjectCountEstimate {
+			count, ok, err := estimateObjectCount(ctx, sess, sourceSvc, args.SourceRoleArn, source.Host)
+			switch {
+			case err != nil:
+				logerr.Printf("Failed to estimate the object count of bucket %s, the total is unknown until the listing completes: %s\n", source.Host, describeError(err))
+			case !ok:
+				loginfo.Printf("Bucket %s has no NumberOfObjects metric yet, the total is unknown until the listing completes\n", source.Host)
+			default:
+				estimate = count
+				loginfo.Printf("Bucket %s has about %d objects\n", source.Host, estimate)
 			}
-			return true // continue paging
-		})
+		}
+		updateProgress := func() {
+			current := newProgress(&stats, atomic.LoadInt64(&scheduled), estimate, atomic.LoadInt32(&listingComplete) == 1, time.Since(startTime), time.Now())
+			if err := writeProgress(args.ProgressFile, current); err != nil {
+				logerr.Printf("Failed to write progress file: %v\n", err)
+			}
+		}
+		ticker := time.NewTicker(args.ReportInterval)
+		done := make(chan struct{})
+		finished := make(chan struct{})
+		go func() {
+			defer close(finished)
+			for {
+				select {
+				case <-ticker.C:
+					updateProgress()
+				case <-done:
+					return
+				}
+			}
+		}()
+		finishProgress = func() {
+			ticker.Stop()
+			close(done)
+			<-finished
+			updateProgress()
+		}
+	}
+	var prefixRate *prefixLimiter
+	if args.PerPrefixRate > 0 {
+		prefixRate = newPrefixLimiter(args.PerPrefixRate, strings.TrimPrefix(source.Path, "/"))
+	}
+	budgetReached := false
+	// The keys left unscheduled by the time budget, for the --manifest of the next run.
+	var remaining *manifestWriter
+	if args.RemainingManifest != "" {
+		f, err := os.Create(args.RemainingManifest)
 		if err != nil {
-			logerr.Printf("Failed to list objects for source bucket %s: %v\n", source.Host, err)
-			os.Exit(5)
+			logerr.Printf("Failed to create remaining manifest: %v\n", err)
+			os.Exit(6)
+		}
+		defer f.Close()
+		remaining = newManifestWriter(f)
+	}
+	hook := newWebhook(args.Webhook)
+	// Secondary copies of --replica-bucket, queued after the primary copies.
+	var replicas *replicator
+	if args.ReplicaBucket != "" {
+		replicaSess := sess
+		if args.ReplicaRegion != "" {
+			replicaSess = sess.Copy(&aws.Config{Region: aws.String(args.ReplicaRegion)})
+		}
+		replicas = newReplicator(ctx, newClient(replicaSess, args.DestRoleArn), args.ReplicaBucket, args.Concurrency, loginfo, logerr)
+		stats.name = "primary"
+	}
+	// Objects refused by --max-object-size with --on-oversize fail.
+	var oversized int64
+
+	c := &copier{
+		ctx:               ctx,
+		svc:               svc,
+		sourceSvc:         sourceSvc,
+		sourceRegions:     sourceRegions,
+		destRegions:       destRegions,
+		index:             index,
+		prefixRate:        prefixRate,
+		stats:             &stats,
+		copied:            &copied,
+		failures:          failures,
+		attributes:        attributes,
+		export:            export,
+		out:               out,
+		hook:              hook,
+		replicas:          replicas,
+		grants:            grants,
+		lock:              lock,
+		tags:              tags,
+		metadata:          metadata,
+		metadataFilter:    metadataFilter,
+		metadataDirective: metadataDirective,
+		byteRange:         byteRange,
+		rangeSize:         rangeSize,
+		loginfo:           loginfo,
+		logerr:            logerr,
+	}
+
+	// Plan of the --dry-run-diff mode.
+	actions := make(plan)
+	// Counts of the --count-only mode.
+	var counts tally
+	diffObject := func(object, existing *s3.Object, targetPath string) {
+		action := planAction(object, existing)
+		actions[action]++
+		if out.text() {
+			loginfo.Printf("%s %s\n", action, targetPath)
+			return
+		}
+		var destSize interface{}
+		if existing != nil {
+			destSize = aws.Int64Value(existing.Size)
+		}
+		err := out.write([]string{"key", "status", "source_size", "dest_size"},
+			strings.TrimPrefix(targetPath, "/"), action, aws.Int64Value(object.Size), destSize)
+		if err != nil {
+			logerr.Printf("Failed to write the plan: %v\n", err)
+		}
+	}
+
+	if bulkMode() {
+		if !args.CountOnly && args.Pairs == "" && (args.DryRunDiff || ((args.SkipExisting || args.CopyIfNewer || args.OverwriteOnlyIfOlderDest) && args.SkipExistingMode == skipExistingList)) {
+			// Prelist the destination once instead of a HEAD request per object
+			index.objects, err = listExisting(ctx, svc, target.Host, strings.TrimPrefix(target.Path, "/"))
+			if err != nil {
+				if args.DryRunDiff {
+					logerr.Printf("Failed to list objects for target bucket %s: %v\n", target.Host, err)
+					os.Exit(5)
+				}
+				logerr.Printf("Failed to list objects for target bucket %s, checking the unlisted objects with HEAD requests: %v\n", target.Host, err)
+			} else {
+				index.complete = true
+			}
+		}
+		// Start the copy workers, spread over the ramp-up window.
+		// With --split-by-size every worker has its own queue filled by the balancer.
+		tasks := make(chan copyTask)
+		queues := make([]chan copyTask, args.Concurrency)
+		var balancer *byteBalancer
+		if args.SplitBySize {
+			balancer = newByteBalancer(args.Concurrency)
+		}
+		for i := 0; i < args.Concurrency; i++ {
+			queues[i] = tasks
+			if balancer != nil {
+				queues[i] = make(chan copyTask, 2)
+			}
+			wg.Add(1)
+			go func(worker int, queue <-chan copyTask, delay time.Duration) {
+				defer wg.Done()
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+				}
+				backoff := errorBackoff{threshold: args.WorkerErrorThreshold, pause: args.WorkerErrorBackoff}
+				for task := range queue {
+					sourceBucket, targetBucket := source.Host, target.Host
+					if task.sourceBucket != "" {
+						sourceBucket, targetBucket = task.sourceBucket, task.targetBucket
+					}
+					size := aws.Int64Value(task.object.Size)
+					var ok bool
+					failedVersion := task.versionID
+					if task.versions != nil {
+						failedVersion, ok = c.copyHistory(sourceBucket, task, targetBucket)
+					} else {
+						ok = c.copyObject(sourceBucket, task, targetBucket)
+					}
+					if !ok && args.SummaryJSON != "" {
+						stats.failedKey(sourceBucket, aws.StringValue(task.object.Key), failedVersion)
+					}
+					if balancer != nil {
+						balancer.done(worker, size)
+					}
+					if task.done != nil {
+						task.done.Done()
+					}
+					if pause := backoff.record(ok); pause > 0 {
+						logerr.Printf("Worker %d failed %d times in a row, pausing for %s\n", worker, backoff.threshold, pause)
+						select {
+						case <-time.After(pause):
+						case <-ctx.Done():
+						}
+					}
+				}
+			}(i, queues[i], rampUpDelay(i, args.Concurrency, args.RampUp))
+		}
+		// Scheduling stops once the time budget is spent or on an interrupt, the started copies still finish.
+		// With --remaining-manifest the listing continues into the manifest after the budget.
+		stopped := func() bool {
+			return (budgetReached && remaining == nil) || atomic.LoadInt32(&interrupted) == 1
+		}
+		// Diff the object or queue it for the copy workers.
+		// addRemaining records the unscheduled object in --remaining-manifest.
+		addRemaining := func(task copyTask) {
+			if err := remaining.add(aws.StringValue(task.object.Key), task.versionID); err != nil {
+				logerr.Printf("Failed to write remaining manifest, stopping the listing: %v\n", err)
+				remaining = nil
+				stopScheduling()
+			}
+		}
+		// selected reports whether the listed object passes the filters.
+		selected := func(o *s3.Object) bool {
+			if excludedPrefix(aws.StringValue(o.Key), args.ExcludePrefix) != "" {
+				return false
+			}
+			if !patterns.allow(aws.StringValue(o.Key)) {
+				return false
+			}
+			if filter != nil && !filter.match(o) {
+				return false
+			}
+			if args.OwnerID != "" && (o.Owner == nil || aws.StringValue(o.Owner.ID) != args.OwnerID) {
+				return false
+			}
+			return !(args.SkipDirectoryMarkers && directoryMarker(o)) && !(args.OnlyDirectoryMarkers && !directoryMarker(o))
+		}
+		schedule := func(task copyTask) {
+			o := task.object
+			if budgetReached && remaining != nil {
+				// The rest of the listing goes to the manifest of the next run instead of the workers.
+				addRemaining(task)
+				return
+			}
+			if stopped() {
+				return
+			}
+			if args.TimeBudget > 0 && time.Since(startTime) >= args.TimeBudget {
+				budgetReached = true
+				if remaining != nil {
+					logerr.Printf("Time budget of %s reached, writing %q and the following objects to %s\n", args.TimeBudget, aws.StringValue(o.Key), args.RemainingManifest)
+					addRemaining(task)
+					return
+				}
+				stopScheduling()
+				logerr.Printf("Time budget of %s reached, not scheduling %q and the following objects\n", args.TimeBudget, aws.StringValue(o.Key))
+				return
+			}
+			if !selected(o) {
+				return
+			}
+			if args.CountOnly {
+				counts.add(o)
+				return
+			}
+			atomic.AddInt64(&scheduled, 1)
+			if first, ok := dedup.duplicate(aws.StringValue(o.Key), aws.StringValue(o.ETag)); ok {
+				stats.skip()
+				loginfo.Printf("Item %q has the same ETag as %q, skipping as deduplicated\n", aws.StringValue(o.Key), first)
+				return
+			}
+			if maxObjectSize > 0 && aws.Int64Value(o.Size) > maxObjectSize {
+				if args.OnOversize == oversizeFail {
+					logerr.Printf("Item %q of %d bytes is larger than --max-object-size %s, failing\n", aws.StringValue(o.Key), aws.Int64Value(o.Size), args.MaxObjectSize)
+					atomic.AddInt64(&oversized, 1)
+					stats.failure(errOversized)
+					c.recordFailure(task.sourceBucketOr(source.Host), aws.StringValue(o.Key), task.versionID, errOversized)
+				} else {
+					logerr.Printf("Item %q of %d bytes is larger than --max-object-size %s, skipping\n", aws.StringValue(o.Key), aws.Int64Value(o.Size), args.MaxObjectSize)
+					stats.skip()
+				}
+				return
+			}
+			targetPath := task.targetPath
+			if targetPath == "" {
+				targetPath, err = targetKey(aws.StringValue(o.Key))
+				if err != nil {
+					logerr.Printf("Failed to render destination key of %s: %v\n", aws.StringValue(o.Key), err)
+					stats.failure(err)
+					c.recordFailure(task.sourceBucketOr(source.Host), aws.StringValue(o.Key), task.versionID, err)
+					return
+				}
+			}
+			resolved, ok := conflicts.resolve(aws.StringValue(o.Key), aws.StringValue(o.ETag), targetPath)
+			if !ok {
+				stats.skip()
+				loginfo.Printf("Item %q conflicts with another object copied to %q, skipping\n", aws.StringValue(o.Key), targetPath)
+				return
+			}
+			targetPath = resolved
+			if args.DryRunDiff {
+				diffObject(o, index.objects[strings.TrimPrefix(targetPath, "/")], targetPath)
+				return
+			}
+			task.targetPath = targetPath
+			if task.done != nil {
+				task.done.Add(1)
+			}
+			if balancer != nil {
+				queues[balancer.assign(aws.Int64Value(task.object.Size))] <- task
+			} else {
+				tasks <- task
+			}
+		}
+		// With --expect-count the enumerated objects are held until their count is checked,
+		// with --sort until all of them are sorted, and with --on-conflict skip or hash
+		// until all of their destination keys are known.
+		var held []copyTask
+		enqueue := schedule
+		if args.ExpectCount > 0 || args.Sort != sortNone || conflicts.holds() {
+			enqueue = func(task copyTask) {
+				held = append(held, task)
+			}
+		}
+		// Copies of the listed page that --page-at-a-time waits for before the next page.
+		var page *sync.WaitGroup
+		// Listed source objects, before the filters.
+		var listed int64
+		process := func(o *s3.Object) {
+			listed++
+			enqueue(copyTask{object: o, done: page})
+		}
+		if args.Pairs != "" {
+			// Take the source and destination of every copy from the pairs
+			r := os.Stdin
+			if args.Pairs != "-" {
+				f, err := os.Open(args.Pairs)
+				if err != nil {
+					logerr.Printf("Failed to open pairs file: %v\n", err)
+					os.Exit(6)
+				}
+				defer f.Close()
+				r = f
+			}
+			err = readPairs(scheduleCtx, r, func(pair copyPair) {
+				enqueue(copyTask{
+					object:       &s3.Object{Key: aws.String(pair.sourceKey)},
+					targetPath:   pair.targetKey,
+					sourceBucket: pair.sourceBucket,
+					targetBucket: pair.targetBucket,
+				})
+			})
+			if err != nil && !stopped() {
+				logerr.Printf("Failed to read pairs %s: %v\n", args.Pairs, err)
+				os.Exit(6)
+			}
+		} else if args.RetryFailed != "" {
+			// Take the failed objects of the source bucket from the summary of a previous run
+			f, err := os.Open(args.RetryFailed)
+			if err != nil {
+				logerr.Printf("Failed to open summary: %v\n", err)
+				os.Exit(6)
+			}
+			failed, err := readFailedObjects(f)
+			f.Close()
+			if err != nil {
+				logerr.Printf("Failed to read summary %s: %v\n", args.RetryFailed, err)
+				os.Exit(6)
+			}
+			for _, o := range failed {
+				if stopped() {
+					break
+				}
+				if o.Bucket != source.Host {
+					logerr.Printf("Failed object %s of bucket %s isn't in the source bucket %s, skipping\n", o.Key, o.Bucket, source.Host)
+					continue
+				}
+				enqueue(copyTask{object: &s3.Object{Key: aws.String(o.Key)}, versionID: o.VersionID})
+			}
+		} else if args.Manifest != "" {
+			// Take the keys and versions from the manifest file
+			f, err := os.Open(args.Manifest)
+			if err != nil {
+				logerr.Printf("Failed to open manifest: %v\n", err)
+				os.Exit(6)
+			}
+			err = readManifest(scheduleCtx, f, func(key, versionID string) {
+				enqueue(copyTask{object: &s3.Object{Key: aws.String(key)}, versionID: versionID})
+			})
+			f.Close()
+			if err != nil && !stopped() {
+				logerr.Printf("Failed to read manifest %s: %v\n", args.Manifest, err)
+				os.Exit(6)
+			}
+		} else if args.Jobs != "" {
+			// Take the objects and their overrides from the jobs file
+			f, err := os.Open(args.Jobs)
+			if err != nil {
+				logerr.Printf("Failed to open jobs file: %v\n", err)
+				os.Exit(6)
+			}
+			err = readJobs(scheduleCtx, f, func(job copyJob) {
+				task := copyTask{
+					object:       &s3.Object{Key: aws.String(job.Source)},
+					storageClass: job.StorageClass,
+					tags:         job.Tags,
+				}
+				if job.Dest != "" {
+					task.targetPath = joinKey(target.Path, job.Dest)
+				}
+				enqueue(task)
+			})
+			f.Close()
+			if err != nil && !stopped() {
+				logerr.Printf("Failed to read jobs file %s: %v\n", args.Jobs, err)
+				os.Exit(6)
+			}
+		} else if args.InventoryManifest != "" {
+			// Take the keys from the inventory report instead of listing the source bucket
+			manifest, err := url.Parse(args.InventoryManifest)
+			if err != nil || manifest.Scheme != "s3" {
+				logerr.Printf("Inventory manifest must be an s3:// url: %s\n", args.InventoryManifest)
+				os.Exit(3)
+			}
+			err = readInventory(scheduleCtx, sourceSvc, manifest.Host, strings.TrimPrefix(manifest.Path, "/"),
+				source.Host, strings.TrimPrefix(source.Path, "/"), process)
+			if err != nil && !stopped() {
+				logerr.Printf("Failed to read inventory %s: %v\n", args.InventoryManifest, err)
+				os.Exit(5)
+			}
+		} else {
+			// List all objects in the source bucket and copy them to the target bucket
+			prefix := strings.TrimPrefix(source.Path, "/")
+			cached := false
+			if args.UseListCache {
+				// Take the objects from the listing saved by a previous run
+				f, err := os.Open(args.ListCache)
+				if err == nil {
+					err = readListCache(scheduleCtx, f, func(header listCacheHeader) error {
+						if !header.matches(source.Host, prefix, args.ExcludePrefix) {
+							return fmt.Errorf("the cache is the listing of s3://%s/%s with the excluded prefixes %q", header.Bucket, header.Prefix, header.Exclude)
+						}
+						if age := time.Since(header.Saved); age > args.ListCacheTTL {
+							logerr.Printf("Warning: list cache %s was saved %s ago, the objects changed since then are missed\n", args.ListCache, age.Round(time.Second))
+						}
+						return nil
+					}, process)
+					f.Close()
+					if err != nil && !stopped() {
+						logerr.Printf("Failed to read list cache %s: %v\n", args.ListCache, err)
+						os.Exit(6)
+					}
+					cached = true
+				} else if !os.IsNotExist(err) {
+					logerr.Printf("Failed to open list cache: %v\n", err)
+					os.Exit(6)
+				} else {
+					loginfo.Printf("List cache %s doesn't exist yet, listing the source bucket\n", args.ListCache)
+				}
+			}
+			if !cached {
+				list := process
+				var cache *listCacheWriter
+				if args.ListCache != "" {
+					// Save the listing for the runs with --use-list-cache
+					cache, err = createListCache(args.ListCache, listCacheHeader{
+						Bucket:  source.Host,
+						Prefix:  prefix,
+						Exclude: args.ExcludePrefix,
+						Saved:   time.Now().UTC(),
+					})
+					if err != nil {
+						logerr.Printf("Failed to create list cache: %v\n", err)
+						os.Exit(6)
+					}
+					list = func(o *s3.Object) {
+						if cache != nil {
+							if err := cache.add(o); err != nil {
+								logerr.Printf("Failed to write list cache, the listing isn't saved: %v\n", err)
+								cache.discard()
+								cache = nil
+							}
+						}
+						process(o)
+					}
+				}
+				if args.AllVersions {
+					err = listVersions(scheduleCtx, sourceSvc, source.Host, prefix, args.IncludeDeleteMarkers, func(history []objectVersion) {
+						// The current version or marker is filtered and counted for the key
+						enqueue(copyTask{object: history[len(history)-1].object, versions: history})
+					})
+				} else if args.PageAtATime {
+					err = listPages(scheduleCtx, sourceSvc, source.Host, prefix, func(objects []*s3.Object) {
+						page = &sync.WaitGroup{}
+						for _, o := range objects {
+							list(o)
+						}
+						page.Wait()
+					})
+				} else if args.Delimiter != "" {
+					err = listDelimited(scheduleCtx, sourceSvc, source.Host, prefix, args.Delimiter, args.Traversal, args.ExcludePrefix, list)
+				} else if args.ParallelListing {
+					err = listParallel(scheduleCtx, sourceSvc, source.Host, prefix, args.ListWorkers, list)
+				} else if len(args.ExcludePrefix) > 0 {
+					err = listExcluding(scheduleCtx, sourceSvc, source.Host, prefix, args.ExcludePrefix, list)
+				} else {
+					err = listObjects(scheduleCtx, sourceSvc, source.Host, prefix, list)
+				}
+				if cache != nil {
+					if err != nil || stopped() {
+						cache.discard()
+					} else if err := cache.commit(); err != nil {
+						logerr.Printf("Failed to save list cache: %v\n", err)
+					}
+				}
+				if err != nil && !stopped() && bucketMissing(err) {
+					logerr.Printf("Source bucket %s doesn't exist, check the bucket name and the account\n", source.Host)
+					os.Exit(5)
+				}
+				if err != nil && !stopped() {
+					logerr.Printf("Failed to list objects for source bucket %s: %v\n", source.Host, err)
+					if args.RequestPayer == "" && errorCategory(err) == categoryAccessDenied {
+						logerr.Printf("If bucket %s is Requester Pays, run with --request-payer requester --accept-requester-pays to pay for the requests\n", source.Host)
+					}
+					os.Exit(5)
+				}
+			}
+		}
+		if args.ExpectCount > 0 && !stopped() && !countWithin(int64(len(held)), args.ExpectCount, args.CountTolerance) {
+			logerr.Printf("Enumerated %d objects, expected %d within %g%%, aborting before copying\n",
+				len(held), args.ExpectCount, args.CountTolerance)
+			os.Exit(9)
+		}
+		if args.Sort != sortNone {
+			sortTasks(held, args.Sort)
+		}
+		if conflicts.holds() {
+			// The index of its own finds the duplicates of schedule, which sees the tasks in the same order.
+			duplicates, _ := newDedupIndex(args.DedupBy)
+			conflicts.addTasks(held, func(task copyTask) bool {
+				o := task.object
+				if !selected(o) {
+					return false
+				}
+				if _, ok := duplicates.duplicate(aws.StringValue(o.Key), aws.StringValue(o.ETag)); ok {
+					return false
+				}
+				return maxObjectSize <= 0 || aws.Int64Value(o.Size) <= maxObjectSize
+			}, targetKey)
+		}
+		for _, task := range held {
+			schedule(task)
+		}
+		if args.Recursive && !args.AllVersions && !stopped() && listed == 0 {
+			// The bucket exists, otherwise the listing failed, but has no objects under the prefix.
+			if args.FailOnEmpty {
+				logerr.Printf("Source s3://%s/%s has no objects, check the source prefix\n", source.Host, strings.TrimPrefix(source.Path, "/"))
+				os.Exit(10)
+			}
+			loginfo.Printf("Source s3://%s/%s has no objects, nothing to copy\n", source.Host, strings.TrimPrefix(source.Path, "/"))
+		}
+		if args.FailOnEmpty && !stopped() && atomic.LoadInt64(&scheduled) == 0 && counts.count == 0 {
+			logerr.Printf("No objects to copy from s3://%s/%s after the filters, check the source prefix\n",
+				source.Host, strings.TrimPrefix(source.Path, "/"))
+			os.Exit(10)
+		}
+		atomic.StoreInt32(&listingComplete, 1)
+		if balancer != nil {
+			for _, queue := range queues {
+				close(queue)
+			}
+		} else {
+			close(tasks)
 		}
 		wg.Wait()
+	} else if args.DryRunDiff {
+		sourcePath := strings.TrimPrefix(source.Path, "/")
+		object, err := headExisting(ctx, sourceSvc, source.Host, sourcePath)
+		if err == nil && object == nil {
+			err = fmt.Errorf("object %s not found", sourcePath)
+		}
+		if err != nil {
+			logerr.Printf("Failed to head object %s: %v\n", sourcePath, err)
+			os.Exit(5)
+		}
+		targetPath, err := targetKey(sourcePath)
+		if err != nil {
+			logerr.Printf("Failed to render destination key of %s: %v\n", sourcePath, err)
+			os.Exit(3)
+		}
+		existing, err := index.lookup(ctx, svc, target.Host, strings.TrimPrefix(targetPath, "/"))
+		if err != nil {
+			logerr.Printf("Failed to head object %s: %v\n", targetPath, err)
+			os.Exit(5)
+		}
+		diffObject(object, existing, targetPath)
 	} else {
 		// Copy onces the item to the target bucket
-		targetPath := path.Join(target.Path, source.Path)
-		copyObject(source.Host, source.Path, target.Host, targetPath)
+		sourcePath := strings.TrimPrefix(source.Path, "/")
+		targetPath, err := targetKey(sourcePath)
+		if err != nil {
+			logerr.Printf("Failed to render destination key of %s: %v\n", sourcePath, err)
+			os.Exit(3)
+		}
+		atomic.StoreInt64(&scheduled, 1)
+		atomic.StoreInt32(&listingComplete, 1)
+		c.copyObject(source.Host, copyTask{object: &s3.Object{Key: aws.String(sourcePath)}, targetPath: targetPath}, target.Host)
+	}
+	hook.wait()
+	replicas.wait()
+	finishProgress()
+	if args.CountOnly {
+		if out.text() {
+			counts.print(logsummary)
+		} else if err := counts.write(out); err != nil {
+			logerr.Printf("Failed to write the count: %v\n", err)
+		}
+		return
+	}
+	if args.DryRunDiff {
+		if out.text() {
+			actions.print(logsummary)
+		}
+		return
+	}
+	// The rows of the --list-only objects are the output, like the plan of --dry-run-diff.
+	listed := args.ListOnly && export == nil
+	if out.text() {
+		stats.print(logsummary)
+	} else if !listed {
+		if err := stats.write(out); err != nil {
+			logerr.Printf("Failed to write the summary: %v\n", err)
+		}
+	}
+	if replicas != nil {
+		if out.text() {
+			replicas.stats.print(logsummary)
+		} else if err := replicas.stats.write(out); err != nil {
+			logerr.Printf("Failed to write the replica summary: %v\n", err)
+		}
+	}
+	if remaining != nil {
+		if err := remaining.flush(); err != nil {
+			logerr.Printf("Failed to write remaining manifest: %v\n", err)
+		}
+	}
+	if args.SummaryJSON != "" {
+		if err := stats.writeFile(args.SummaryJSON); err != nil {
+			logerr.Printf("Failed to write the summary file: %v\n", err)
+		}
+	}
+
+	if args.VerifyCount {
+		// Reconcile the copied objects with the destination listing
+		existing, err := listExisting(ctx, svc, target.Host, strings.TrimPrefix(target.Path, "/"))
+		if err != nil {
+			logerr.Printf("Failed to list objects for target bucket %s: %v\n", target.Host, err)
+			os.Exit(5)
+		}
+		missing := copied.missing(existing)
+		loginfo.Printf("Verified: %d of %d copied objects found in bucket %q\n", copied.len()-len(missing), copied.len(), target.Host)
+		for _, key := range missing {
+			logerr.Printf("Missing object %s in bucket %s\n", key, target.Host)
+		}
+		if len(missing) > 0 {
+			os.Exit(7)
+		}
+	}
+	if atomic.LoadInt32(&interrupted) == 1 {
+		os.Exit(130)
+	}
+	if budgetReached {
+		os.Exit(8)
+	}
+	if atomic.LoadInt64(&oversized) > 0 {
+		os.Exit(11)
+	}
+	if replicas != nil && atomic.LoadInt64(&replicas.stats.failed) > 0 {
+		os.Exit(12)
 	}
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// saveArgs restores the flags changed by the test when it ends.
+func saveArgs(t *testing.T) {
+	saved := args
+	t.Cleanup(func() { args = saved })
+}
+
+func TestEveryFlagHasEnv(t *testing.T) {
+	v := reflect.TypeOf(args)
+	for i := 0; i < v.NumField(); i++ {
+		var long, env string
+		for _, opt := range strings.Split(v.Field(i).Tag.Get("arg"), ",") {
+			switch {
+			case strings.HasPrefix(opt, "--"):
+				long = strings.TrimPrefix(opt, "--")
+			case strings.HasPrefix(opt, "env:"):
+				env = strings.TrimPrefix(opt, "env:")
+			}
+		}
+		if long == "" {
+			continue
+		}
+		if want := "S3BCP_" + strings.ToUpper(strings.ReplaceAll(long, "-", "_")); env != want {
+			t.Errorf("--%s has env %q, want %q", long, env, want)
+		}
+	}
+}
+
+func TestEnvPopulatesArgs(t *testing.T) {
+	os.Setenv("S3BCP_CONCURRENCY", "42")
+	os.Setenv("S3BCP_REGION", "eu-west-1")
+	os.Setenv("S3BCP_RECURSIVE", "true")
+	os.Setenv("S3BCP_SOURCE", "s3://bucket1/")
+	os.Setenv("S3BCP_DESTINATION", "s3://bucket2/")
+	defer func() {
+		for _, env := range []string{"S3BCP_CONCURRENCY", "S3BCP_REGION", "S3BCP_RECURSIVE", "S3BCP_SOURCE", "S3BCP_DESTINATION"} {
+			os.Unsetenv(env)
+		}
+	}()
+	dest := args
+	p, err := arg.NewParser(arg.Config{}, &dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Parse([]string{"--region", "us-east-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if dest.Concurrency != 42 || !dest.Recursive || dest.Source != "s3://bucket1/" {
+		t.Errorf("concurrency %d, recursive %v, source %q, want the environment values", dest.Concurrency, dest.Recursive, dest.Source)
+	}
+	if dest.Region != "us-east-2" {
+		t.Errorf("region = %q, want the flag to override the environment", dest.Region)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	static := credentials.NewStaticCredentials("id", "secret", "")
+	sess, err := session.NewSession(&aws.Config{Region: aws.String("us-east-1"), Credentials: static})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc := newClient(sess, ""); svc.Config.Credentials != static {
+		t.Error("newClient() without a role doesn't use the session credentials")
+	}
+	if svc := newClient(sess, "arn:aws:iam::123456789012:role/source"); svc.Config.Credentials == static {
+		t.Error("newClient() of a role uses the session credentials")
+	}
+}
+
+func TestNewLoggers(t *testing.T) {
+	var buf bytes.Buffer
+	info, summary := newLoggers(&buf, false)
+	info.Println("copied a")
+	summary.Println("total 1")
+	if want := "copied a\ntotal 1\n"; buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	info, summary = newLoggers(&buf, true)
+	info.Println("copied a")
+	summary.Println("total 1")
+	if want := "total 1\n"; buf.String() != want {
+		t.Errorf("output with --errors-only = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestNewSessionRequestPayer(t *testing.T) {
+	for _, payer := range []string{"", "requester"} {
+		parseArgs(t, "--request-payer", payer)
+		sess, err := newSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := s3.New(sess).HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String("bucket1")})
+		if err := req.Build(); err != nil {
+			t.Fatal(err)
+		}
+		if got := req.HTTPRequest.Header.Get("x-amz-request-payer"); got != payer {
+			t.Errorf("x-amz-request-payer of --request-payer %q = %q, want %q", payer, got, payer)
+		}
+	}
+}
+
+func TestNewSessionRegion(t *testing.T) {
+	config := t.TempDir() + "/config"
+	if err := os.WriteFile(config, []byte("[profile other]\nregion = ap-south-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	vars := []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE", "AWS_CONFIG_FILE", "AWS_SHARED_CREDENTIALS_FILE"}
+	saved := make(map[string]string)
+	for _, v := range vars {
+		if value, ok := os.LookupEnv(v); ok {
+			saved[v] = value
+		}
+	}
+	defer func() {
+		for _, v := range vars {
+			if value, ok := saved[v]; ok {
+				os.Setenv(v, value)
+			} else {
+				os.Unsetenv(v)
+			}
+		}
+	}()
+	tests := []struct {
+		name string
+		argv []string
+		env  map[string]string
+		want string
+	}{
+		{"--region", []string{"--region", "eu-west-1"}, map[string]string{"AWS_REGION": "us-west-2", "AWS_PROFILE": "other"}, "eu-west-1"},
+		{"AWS_REGION", nil, map[string]string{"AWS_REGION": "us-west-2", "AWS_DEFAULT_REGION": "eu-central-1"}, "us-west-2"},
+		{"AWS_DEFAULT_REGION", nil, map[string]string{"AWS_DEFAULT_REGION": "eu-central-1"}, "eu-central-1"},
+		{"shared config profile", nil, map[string]string{"AWS_PROFILE": "other"}, "ap-south-1"},
+		{"nothing set", nil, nil, defaultRegion},
+	}
+	for _, test := range tests {
+		for _, v := range vars {
+			os.Unsetenv(v)
+		}
+		// The shared config of the test, without the credentials of the machine.
+		os.Setenv("AWS_CONFIG_FILE", config)
+		os.Setenv("AWS_SHARED_CREDENTIALS_FILE", config+".missing")
+		for k, v := range test.env {
+			os.Setenv(k, v)
+		}
+		parseArgs(t, test.argv...)
+		sess, err := newSession()
+		if err != nil {
+			t.Errorf("%s: newSession(): %v", test.name, err)
+			continue
+		}
+		if got := aws.StringValue(sess.Config.Region); got != test.want {
+			t.Errorf("%s: region = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
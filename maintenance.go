@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// uploadsBefore returns the multipart uploads initiated before the cutoff.
+func uploadsBefore(uploads []*s3.MultipartUpload, cutoff time.Time) []*s3.MultipartUpload {
+	var old []*s3.MultipartUpload
+	for _, upload := range uploads {
+		if upload.Initiated != nil && upload.Initiated.Before(cutoff) {
+			old = append(old, upload)
+		}
+	}
+	return old
+}
+
+// abortIncompleteUploads aborts the multipart uploads under the prefix of the bucket
+// initiated before the cutoff. It calls fn with the result of every abort and
+// returns the number of aborted uploads.
+func abortIncompleteUploads(ctx context.Context, svc *s3.S3, bucket, prefix string, cutoff time.Time,
+	fn func(upload *s3.MultipartUpload, err error)) (int, error) {
+	// Collect the uploads first, aborting them while paging would shift the markers.
+	var uploads []*s3.MultipartUpload
+	err := svc.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(p *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		uploads = append(uploads, uploadsBefore(p.Uploads, cutoff)...)
+		return true // continue paging
+	})
+	if err != nil {
+		return 0, err
+	}
+	aborted := 0
+	for _, upload := range uploads {
+		_, err := svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		})
+		if err == nil {
+			aborted++
+		}
+		fn(upload, err)
+	}
+	return aborted, nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestUploadsBefore(t *testing.T) {
+	cutoff := time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC)
+	uploads := []*s3.MultipartUpload{
+		{UploadId: aws.String("old"), Initiated: aws.Time(cutoff.Add(-time.Hour))},
+		{UploadId: aws.String("new"), Initiated: aws.Time(cutoff.Add(time.Hour))},
+		{UploadId: aws.String("cutoff"), Initiated: aws.Time(cutoff)},
+		{UploadId: aws.String("unknown")},
+	}
+	old := uploadsBefore(uploads, cutoff)
+	if len(old) != 1 || aws.StringValue(old[0].UploadId) != "old" {
+		t.Errorf("uploadsBefore() = %v, want only the old upload", old)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Policies of the --on-not-found flag.
+const (
+	onNotFoundFail = "fail"
+	onNotFoundSkip = "skip"
+)
+
+// missingSource reports whether the copy, HEAD or delete request failed because
+// the source key doesn't exist, e.g. deleted since the manifest was written.
+func missingSource(err error) bool {
+	var rerr awserr.RequestFailure
+	if !errors.As(err, &rerr) {
+		return false
+	}
+	return rerr.Code() == "NoSuchKey" || (rerr.Code() == "NotFound" && rerr.StatusCode() == http.StatusNotFound)
+}
+
+// manifestWriter writes the object keys in the format of readManifest,
+// one per line with the version ID after a tab when there is one.
+type manifestWriter struct {
+	w *bufio.Writer
+}
+
+func newManifestWriter(w io.Writer) *manifestWriter {
+	return &manifestWriter{w: bufio.NewWriter(w)}
+}
+
+// add writes the key and its version ID.
+func (m *manifestWriter) add(key, versionID string) error {
+	line := key
+	if versionID != "" {
+		line += "\t" + versionID
+	}
+	_, err := m.w.WriteString(line + "\n")
+	return err
+}
+
+// flush writes the buffered keys.
+func (m *manifestWriter) flush() error {
+	return m.w.Flush()
+}
+
+// readManifest reads the object keys of the manifest, one per line with an
+// optional tab-separated version ID, and calls fn for every key. A blank version
+// ID means the current version. Empty lines are skipped.
+func readManifest(ctx context.Context, r io.Reader, fn func(key, versionID string)) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) > 2 {
+			return fmt.Errorf("line %d: expected KEY or KEY<TAB>VERSION_ID, got %d fields", line, len(fields))
+		}
+		key, versionID := fields[0], ""
+		if len(fields) == 2 {
+			versionID = strings.TrimSpace(fields[1])
+		}
+		if key == "" {
+			return fmt.Errorf("line %d: object key is required", line)
+		}
+		fn(key, versionID)
+	}
+	return scanner.Err()
+}
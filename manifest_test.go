@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestManifestWriterRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	m := newManifestWriter(&buf)
+	entries := [][2]string{{"logs/a b.log", ""}, {"logs/b.log", "v1"}, {"logs/c.log", ""}}
+	for _, e := range entries {
+		if err := m.add(e[0], e[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %q before the flush, want the keys buffered", buf.String())
+	}
+	if err := m.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "logs/a b.log\nlogs/b.log\tv1\nlogs/c.log\n"; buf.String() != want {
+		t.Errorf("manifest = %q, want %q", buf.String(), want)
+	}
+	var read [][2]string
+	if err := readManifest(context.Background(), &buf, func(key, versionID string) {
+		read = append(read, [2]string{key, versionID})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("readManifest() = %q, want %q", read, entries)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	input := "a.txt\r\n\n  \nb.txt\t v2 \nc.txt\t\n"
+	var read [][2]string
+	if err := readManifest(context.Background(), strings.NewReader(input), func(key, versionID string) {
+		read = append(read, [2]string{key, versionID})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][2]string{{"a.txt", ""}, {"b.txt", "v2"}, {"c.txt", ""}}; !reflect.DeepEqual(read, want) {
+		t.Errorf("readManifest() = %q, want %q", read, want)
+	}
+
+	tests := map[string]string{
+		"a.txt\nb.txt\tv1\tv2\n": "line 2: expected KEY or KEY<TAB>VERSION_ID, got 3 fields",
+		"\tv1\n":                 "line 1: object key is required",
+	}
+	for input, want := range tests {
+		err := readManifest(context.Background(), strings.NewReader(input), func(string, string) {})
+		if err == nil || err.Error() != want {
+			t.Errorf("readManifest(%q) = %v, want %q", input, err, want)
+		}
+	}
+}
+
+func TestMissingSource(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.NewRequestFailure(awserr.New("NoSuchKey", "The specified key does not exist.", nil), http.StatusNotFound, "REQ"), true},
+		{awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), http.StatusNotFound, "REQ"), true},
+		{awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), http.StatusBadRequest, "REQ"), false},
+		{awserr.NewRequestFailure(awserr.New("NoSuchBucket", "The specified bucket does not exist", nil), http.StatusNotFound, "REQ"), false},
+		{awserr.New("NoSuchKey", "no request", nil), false},
+		{errors.New("NoSuchKey"), false},
+	}
+	for _, test := range tests {
+		if got := missingSource(test.err); got != test.want {
+			t.Errorf("missingSource(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestMissingSourceDeleted(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	fake.put("bucket1", "a", 1)
+	fake.strictDelete = true
+	input := &s3.DeleteObjectInput{Bucket: aws.String("bucket1"), Key: aws.String("a")}
+	if _, err := svc.DeleteObjectWithContext(context.Background(), input); err != nil {
+		t.Fatal(err)
+	}
+	// Deleted again, e.g. by a concurrent move of the same object.
+	_, err := svc.DeleteObjectWithContext(context.Background(), input)
+	if err == nil || !missingSource(err) {
+		t.Errorf("missingSource() of the delete of a deleted object = false (%v), want true", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// originalLastModifiedKey is the user metadata key (without the x-amz-meta-
+// prefix) that keeps the source LastModified when --preserve-timestamp is set.
+const originalLastModifiedKey = "original-last-modified"
+
+// replaceMetadata carries over the system and user metadata of the source object
+// to a copy with the REPLACE metadata directive, which would otherwise drop
+// everything that is not specified explicitly.
+func replaceMetadata(input *s3.CopyObjectInput, head *s3.HeadObjectOutput) {
+	input.CacheControl = head.CacheControl
+	input.ContentDisposition = head.ContentDisposition
+	input.ContentEncoding = head.ContentEncoding
+	input.ContentLanguage = head.ContentLanguage
+	input.ContentType = head.ContentType
+	input.WebsiteRedirectLocation = head.WebsiteRedirectLocation
+	if head.Expires != nil {
+		if expires, err := http.ParseTime(*head.Expires); err == nil {
+			input.Expires = aws.Time(expires)
+		}
+	}
+	input.Metadata = make(map[string]*string, len(head.Metadata))
+	for k, v := range head.Metadata {
+		input.Metadata[k] = v
+	}
+}
+
+// setMetadata sets a user metadata value, replacing any existing key that
+// differs only by case (S3 returns metadata keys in canonical header form).
+func setMetadata(metadata map[string]*string, key, value string) {
+	for k := range metadata {
+		if strings.EqualFold(k, key) {
+			delete(metadata, k)
+		}
+	}
+	metadata[key] = aws.String(value)
+}
+
+// hasMetadataOverrides reports whether any content header or user metadata was set by the flags.
+func hasMetadataOverrides() bool {
+	return args.CacheControl != "" || args.ContentDisposition != "" || args.ContentEncoding != "" ||
+		args.ContentLanguage != "" || args.ContentType != "" || len(args.Metadata) > 0
+}
+
+// applyMetadataOverrides sets the content headers and the user metadata given by the flags on the copy.
+func applyMetadataOverrides(input *s3.CopyObjectInput, metadata map[string]string) {
+	if args.CacheControl != "" {
+		input.CacheControl = aws.String(args.CacheControl)
+	}
+	if args.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(args.ContentDisposition)
+	}
+	if args.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(args.ContentEncoding)
+	}
+	if args.ContentLanguage != "" {
+		input.ContentLanguage = aws.String(args.ContentLanguage)
+	}
+	if args.ContentType != "" {
+		input.ContentType = aws.String(args.ContentType)
+	}
+	if len(metadata) > 0 && input.Metadata == nil {
+		input.Metadata = make(map[string]*string, len(metadata))
+	}
+	for k, v := range metadata {
+		setMetadata(input.Metadata, k, v)
+	}
+}
+
+// matchMetadata reports whether the user metadata contains all the filter values.
+// Keys are compared case-insensitively, since S3 returns them in canonical form.
+func matchMetadata(metadata map[string]*string, filter map[string]string) bool {
+	for key, value := range filter {
+		found := false
+		for k, v := range metadata {
+			if strings.EqualFold(k, key) && aws.StringValue(v) == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parseKeyValues parses the KEY=VALUE pairs of a repeatable flag.
+func parseKeyValues(pairs []string) (map[string]string, error) {
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("%q is not in the KEY=VALUE format", pair)
+		}
+		values[pair[:i]] = pair[i+1:]
+	}
+	return values, nil
+}
+
+// metadataDirectiveAuto is the default --metadata-directive, inferred from the flags.
+const metadataDirectiveAuto = "AUTO"
+
+// resolveMetadataDirective returns the metadata directive of the copies.
+// The content header and metadata flags, as well as --merge-metadata and
+// --preserve-timestamp, require REPLACE, which is chosen by the auto directive,
+// otherwise it's COPY. It also
+// returns a warning when REPLACE would clear the metadata, or drop the source
+// headers and metadata that aren't given by the flags.
+func resolveMetadataDirective(directive string) (string, string, error) {
+	replace := hasMetadataOverrides() || args.MergeMetadata || args.PreserveTimestamp
+	directive = strings.ToUpper(directive)
+	switch directive {
+	case "", metadataDirectiveAuto:
+		if !replace {
+			return s3.MetadataDirectiveCopy, "", nil
+		}
+		if !args.MergeMetadata && !args.PreserveTimestamp {
+			return s3.MetadataDirectiveReplace, "metadata directive REPLACE drops the content headers and metadata of the sources " +
+				"that aren't given by the flags, add --merge-metadata to keep them", nil
+		}
+		return s3.MetadataDirectiveReplace, "", nil
+	case s3.MetadataDirectiveCopy:
+		if replace {
+			return "", "", fmt.Errorf("content header, metadata, --merge-metadata and --preserve-timestamp flags require the %s metadata directive", s3.MetadataDirectiveReplace)
+		}
+		return directive, "", nil
+	case s3.MetadataDirectiveReplace:
+		if !replace {
+			return directive, "metadata directive REPLACE without content header or metadata flags clears the existing content headers and metadata", nil
+		}
+		return directive, "", nil
+	}
+	return "", "", fmt.Errorf("unknown metadata directive %q, use auto, %s or %s", directive, s3.MetadataDirectiveCopy, s3.MetadataDirectiveReplace)
+}
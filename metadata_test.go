@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestReplaceMetadata(t *testing.T) {
+	head := &s3.HeadObjectOutput{
+		CacheControl: aws.String("max-age=60"),
+		ContentType:  aws.String("text/plain"),
+		Expires:      aws.String("Wed, 21 Oct 2015 07:28:00 GMT"),
+		Metadata:     map[string]*string{"Owner": aws.String("ops")},
+	}
+	input := &s3.CopyObjectInput{}
+	replaceMetadata(input, head)
+	if aws.StringValue(input.CacheControl) != "max-age=60" || aws.StringValue(input.ContentType) != "text/plain" {
+		t.Errorf("headers = %q, %q, want the source headers", aws.StringValue(input.CacheControl), aws.StringValue(input.ContentType))
+	}
+	if want := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC); !aws.TimeValue(input.Expires).Equal(want) {
+		t.Errorf("Expires = %v, want %v", aws.TimeValue(input.Expires), want)
+	}
+	head.Metadata["Owner"] = aws.String("changed")
+	if aws.StringValue(input.Metadata["Owner"]) != "ops" {
+		t.Error("Metadata shares the map of the source")
+	}
+}
+
+func TestSetMetadata(t *testing.T) {
+	metadata := map[string]*string{"Original-Last-Modified": aws.String("old"), "Owner": aws.String("ops")}
+	setMetadata(metadata, originalLastModifiedKey, "new")
+	if len(metadata) != 2 || aws.StringValue(metadata[originalLastModifiedKey]) != "new" {
+		t.Errorf("metadata = %v, want the key replaced regardless of the case", metadata)
+	}
+}
+
+func TestApplyMetadataOverrides(t *testing.T) {
+	saveArgs(t)
+	args.ContentType, args.CacheControl = "application/json", "no-cache"
+	args.Metadata = []string{"owner=dev", "team=data"}
+	if !hasMetadataOverrides() {
+		t.Fatal("hasMetadataOverrides() = false, want true")
+	}
+	metadata, err := parseKeyValues(args.Metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := &s3.CopyObjectInput{ContentType: aws.String("text/plain"), Metadata: map[string]*string{"Owner": aws.String("ops")}}
+	applyMetadataOverrides(input, metadata)
+	if aws.StringValue(input.ContentType) != "application/json" || aws.StringValue(input.CacheControl) != "no-cache" {
+		t.Errorf("headers = %q, %q, want the flags", aws.StringValue(input.ContentType), aws.StringValue(input.CacheControl))
+	}
+	if len(input.Metadata) != 2 || aws.StringValue(input.Metadata["owner"]) != "dev" || aws.StringValue(input.Metadata["team"]) != "data" {
+		t.Errorf("Metadata = %v, want owner and team of the flags", input.Metadata)
+	}
+
+	args.ContentType, args.CacheControl, args.Metadata = "", "", nil
+	if hasMetadataOverrides() {
+		t.Error("hasMetadataOverrides() without flags = true, want false")
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	values, err := parseKeyValues([]string{"a=1", "b=x=y", "c="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values["a"] != "1" || values["b"] != "x=y" || values["c"] != "" {
+		t.Errorf("parseKeyValues() = %v", values)
+	}
+	for _, pair := range []string{"novalue", "=value"} {
+		if _, err := parseKeyValues([]string{pair}); err == nil {
+			t.Errorf("parseKeyValues(%q) succeeded, want an error", pair)
+		}
+	}
+}
+
+func TestMatchMetadata(t *testing.T) {
+	metadata := map[string]*string{"Owner": aws.String("ops"), "Team": aws.String("data")}
+	tests := []struct {
+		filter map[string]string
+		want   bool
+	}{
+		{nil, true},
+		{map[string]string{"owner": "ops"}, true},
+		{map[string]string{"OWNER": "ops", "team": "data"}, true},
+		{map[string]string{"owner": "OPS"}, false},
+		{map[string]string{"owner": "ops", "env": "prod"}, false},
+	}
+	for _, test := range tests {
+		if got := matchMetadata(metadata, test.filter); got != test.want {
+			t.Errorf("matchMetadata(%v) = %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestResolveMetadataDirective(t *testing.T) {
+	saveArgs(t)
+	tests := []struct {
+		directive   string
+		contentType string
+		merge       bool
+		want        string
+		warning     bool
+		err         bool
+	}{
+		{"", "", false, s3.MetadataDirectiveCopy, false, false},
+		{"auto", "text/plain", false, s3.MetadataDirectiveReplace, true, false},
+		{"AUTO", "text/plain", true, s3.MetadataDirectiveReplace, false, false},
+		{"copy", "", false, s3.MetadataDirectiveCopy, false, false},
+		{"copy", "text/plain", false, "", false, true},
+		{"COPY", "", true, "", false, true},
+		{"replace", "", false, s3.MetadataDirectiveReplace, true, false},
+		{"replace", "text/plain", true, s3.MetadataDirectiveReplace, false, false},
+		{"merge", "", false, "", false, true},
+	}
+	for _, test := range tests {
+		args.ContentType, args.MergeMetadata = test.contentType, test.merge
+		got, warning, err := resolveMetadataDirective(test.directive)
+		if (err != nil) != test.err {
+			t.Errorf("resolveMetadataDirective(%q) with %+v: error %v, want error %v", test.directive, test, err, test.err)
+			continue
+		}
+		if got != test.want || (warning != "") != test.warning {
+			t.Errorf("resolveMetadataDirective(%q) with %+v = %q, %q", test.directive, test, got, warning)
+		}
+	}
+}
+
+func TestResolveMetadataDirectiveWarnings(t *testing.T) {
+	tests := []struct {
+		argv    []string
+		want    string
+		warning string
+	}{
+		{nil, s3.MetadataDirectiveCopy, ""},
+		{[]string{"--content-type", "text/plain"}, s3.MetadataDirectiveReplace, "add --merge-metadata to keep them"},
+		{[]string{"--content-type", "text/plain", "--preserve-timestamp"}, s3.MetadataDirectiveReplace, ""},
+		{[]string{"--metadata-directive", "replace"}, s3.MetadataDirectiveReplace, "clears the existing content headers and metadata"},
+		{[]string{"--metadata-directive", "replace", "--preserve-timestamp"}, s3.MetadataDirectiveReplace, ""},
+	}
+	for _, test := range tests {
+		parseArgs(t, test.argv...)
+		got, warning, err := resolveMetadataDirective(args.MetadataDirective)
+		if err != nil {
+			t.Errorf("resolveMetadataDirective() with %q: %v", test.argv, err)
+			continue
+		}
+		if got != test.want || (test.warning == "") != (warning == "") || !strings.Contains(warning, test.warning) {
+			t.Errorf("resolveMetadataDirective() with %q = %q, %q, want %q, %q", test.argv, got, warning, test.want, test.warning)
+		}
+	}
+}
+
+func TestResolveMetadataDirectivePreserveTimestamp(t *testing.T) {
+	tests := []struct {
+		directive string
+		want      string
+	}{
+		{"auto", s3.MetadataDirectiveReplace},
+		{"replace", s3.MetadataDirectiveReplace},
+		// COPY can't keep the source LastModified in the metadata.
+		{"copy", ""},
+	}
+	for _, test := range tests {
+		parseArgs(t, "--preserve-timestamp", "--metadata-directive", test.directive)
+		got, warning, err := resolveMetadataDirective(args.MetadataDirective)
+		if (err != nil) != (test.want == "") || got != test.want || warning != "" {
+			t.Errorf("resolveMetadataDirective(%q) with --preserve-timestamp = %q, %q, %v, want %q", test.directive, got, warning, err, test.want)
+		}
+	}
+}
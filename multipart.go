@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// maxCopySize is the largest source that a single CopyObject
+// or UploadPartCopy request can copy, 5 GiB.
+const maxCopySize = 5 << 30
+
+// Policies of the --on-oversize flag.
+const (
+	oversizeSkip = "skip"
+	oversizeFail = "fail"
+)
+
+// errOversized is the failure of the objects refused by --max-object-size.
+var errOversized = errors.New("object larger than --max-object-size")
+
+// copyTooLarge reports whether the copy failed because the source
+// is larger than maxCopySize.
+func copyTooLarge(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	return aerr.Code() == "InvalidRequest" && strings.Contains(aerr.Message(), "larger than the maximum allowable size")
+}
+
+// copyTooLargeGuidance explains the failed copy of the object larger than maxCopySize.
+func copyTooLargeGuidance(key string, size int64) string {
+	if size > 0 {
+		key = fmt.Sprintf("%s (%d bytes)", key, size)
+	}
+	return fmt.Sprintf("Object %s is larger than %d bytes, the limit of a single copy request. "+
+		"It needs a multipart copy of several parts, which isn't supported yet, copy it with the AWS CLI instead", key, int64(maxCopySize))
+}
+
+// byteRangePattern matches the --range value.
+var byteRangePattern = regexp.MustCompile(`^bytes=(\d+)-(\d+)$`)
+
+// parseByteRange validates the bytes=START-END range and returns
+// the CopySourceRange value and the size of the range.
+func parseByteRange(s string) (string, int64, error) {
+	m := byteRangePattern.FindStringSubmatch(s)
+	if m == nil {
+		return "", 0, fmt.Errorf("range %q is not in the bytes=START-END format", s)
+	}
+	start, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid range start: %v", err)
+	}
+	end, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid range end: %v", err)
+	}
+	if start > end {
+		return "", 0, fmt.Errorf("range start %d is after the end %d", start, end)
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, end), end - start + 1, nil
+}
+
+// copyObjectRange copies the byte range of the source into a new object with a
+// single-part multipart upload, since CopyObject can't copy a part of an object.
+// The upload takes the headers, metadata and tags of the copy input, the source
+// metadata isn't copied by the multipart upload.
+func copyObjectRange(ctx context.Context, svc *s3.S3, input *s3.CopyObjectInput, byteRange string) error {
+	upload, err := svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:                    input.Bucket,
+		Key:                       input.Key,
+		ACL:                       input.ACL,
+		CacheControl:              input.CacheControl,
+		ContentDisposition:        input.ContentDisposition,
+		ContentEncoding:           input.ContentEncoding,
+		ContentLanguage:           input.ContentLanguage,
+		ContentType:               input.ContentType,
+		Expires:                   input.Expires,
+		GrantFullControl:          input.GrantFullControl,
+		GrantRead:                 input.GrantRead,
+		GrantReadACP:              input.GrantReadACP,
+		GrantWriteACP:             input.GrantWriteACP,
+		Metadata:                  input.Metadata,
+		ObjectLockLegalHoldStatus: input.ObjectLockLegalHoldStatus,
+		ObjectLockMode:            input.ObjectLockMode,
+		ObjectLockRetainUntilDate: input.ObjectLockRetainUntilDate,
+		StorageClass:              input.StorageClass,
+		Tagging:                   input.Tagging,
+		WebsiteRedirectLocation:   input.WebsiteRedirectLocation,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	part, err := svc.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		CopySource:      input.CopySource,
+		CopySourceRange: aws.String(byteRange),
+		PartNumber:      aws.Int64(1),
+		UploadId:        upload.UploadId,
+	})
+	if err == nil {
+		_, err = svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: upload.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{
+				Parts: []*s3.CompletedPart{{
+					ETag:       part.CopyPartResult.ETag,
+					PartNumber: aws.Int64(1),
+				}},
+			},
+		})
+	}
+	if err != nil {
+		// Don't leave the incomplete upload behind.
+		svc.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: upload.UploadId,
+		})
+		return fmt.Errorf("failed to copy range %s: %w", byteRange, err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		s     string
+		want  string
+		size  int64
+		valid bool
+	}{
+		{"bytes=0-99", "bytes=0-99", 100, true},
+		{"bytes=5-5", "bytes=5-5", 1, true},
+		{"bytes=007-10", "bytes=7-10", 4, true},
+		{"bytes=10-5", "", 0, false},
+		{"bytes=0-", "", 0, false},
+		{"bytes=-10", "", 0, false},
+		{"0-99", "", 0, false},
+		{"bytes=0-99999999999999999999", "", 0, false},
+	}
+	for _, test := range tests {
+		got, size, err := parseByteRange(test.s)
+		if (err == nil) != test.valid {
+			t.Errorf("parseByteRange(%q): error %v, want valid %v", test.s, err, test.valid)
+			continue
+		}
+		if got != test.want || size != test.size {
+			t.Errorf("parseByteRange(%q) = %q, %d, want %q, %d", test.s, got, size, test.want, test.size)
+		}
+	}
+}
+
+func TestCopyTooLarge(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.New("InvalidRequest", "The specified copy source is larger than the maximum allowable size for a copy source: 5368709120", nil), true},
+		{awserr.New("InvalidRequest", "Invalid storage class", nil), false},
+		{awserr.New("EntityTooLarge", "larger than the maximum allowable size", nil), false},
+		{errors.New("larger than the maximum allowable size"), false},
+	}
+	for _, test := range tests {
+		if got := copyTooLarge(test.err); got != test.want {
+			t.Errorf("copyTooLarge(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+
+	if got := copyTooLargeGuidance("big.bin", 6<<30); !strings.HasPrefix(got, "Object big.bin (6442450944 bytes) is larger than 5368709120 bytes") {
+		t.Errorf("copyTooLargeGuidance() = %q, want the size of the object", got)
+	}
+	if got := copyTooLargeGuidance("big.bin", 0); !strings.HasPrefix(got, "Object big.bin is larger than") {
+		t.Errorf("copyTooLargeGuidance() without the size = %q", got)
+	}
+}
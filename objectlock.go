@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// applySourceObjectLock carries the Object Lock retention and legal hold of the
+// source object over to the copy. Objects without a lock are copied unchanged,
+// and an expired retention is dropped because S3 only accepts future dates.
+func applySourceObjectLock(input *s3.CopyObjectInput, head *s3.HeadObjectOutput) {
+	if head.ObjectLockMode != nil && head.ObjectLockRetainUntilDate != nil &&
+		head.ObjectLockRetainUntilDate.After(time.Now()) {
+		input.ObjectLockMode = head.ObjectLockMode
+		input.ObjectLockRetainUntilDate = head.ObjectLockRetainUntilDate
+	}
+	if head.ObjectLockLegalHoldStatus != nil {
+		input.ObjectLockLegalHoldStatus = head.ObjectLockLegalHoldStatus
+	}
+}
+
+// objectLock is the Object Lock set on the copies by the --object-lock-* flags.
+// The legal hold is independent of the retention, while the retention needs
+// both the mode and the retain-until date.
+type objectLock struct {
+	mode        string
+	retainUntil *time.Time
+	legalHold   string
+}
+
+// parseObjectLock validates the --object-lock-* flags, the retain-until
+// date must be in the future since S3 rejects a past one.
+func parseObjectLock(now time.Time) (objectLock, error) {
+	var l objectLock
+	if args.ObjectLockLegalHold != "" {
+		l.legalHold = strings.ToUpper(args.ObjectLockLegalHold)
+		if l.legalHold != s3.ObjectLockLegalHoldStatusOn && l.legalHold != s3.ObjectLockLegalHoldStatusOff {
+			return l, errors.New("--object-lock-legal-hold must be ON or OFF")
+		}
+	}
+	if (args.ObjectLockMode == "") != (args.ObjectLockRetainUntil == "") {
+		return l, errors.New("--object-lock-mode and --object-lock-retain-until set the retention together, " +
+			"use --object-lock-legal-hold alone for a legal hold without retention")
+	}
+	if args.ObjectLockMode == "" {
+		return l, nil
+	}
+	l.mode = strings.ToUpper(args.ObjectLockMode)
+	if l.mode != s3.ObjectLockModeGovernance && l.mode != s3.ObjectLockModeCompliance {
+		return l, errors.New("--object-lock-mode must be GOVERNANCE or COMPLIANCE")
+	}
+	until, err := parseDate(args.ObjectLockRetainUntil)
+	if err != nil {
+		return l, fmt.Errorf("--object-lock-retain-until: %v", err)
+	}
+	retainUntil := time.Unix(until, 0).UTC()
+	if !retainUntil.After(now) {
+		return l, fmt.Errorf("--object-lock-retain-until %s is not in the future", args.ObjectLockRetainUntil)
+	}
+	l.retainUntil = &retainUntil
+	return l, nil
+}
+
+// apply sets the lock on the copy, over the lock of --preserve-object-lock.
+func (l objectLock) apply(input *s3.CopyObjectInput) {
+	if l.mode != "" {
+		input.ObjectLockMode = aws.String(l.mode)
+		input.ObjectLockRetainUntilDate = l.retainUntil
+	}
+	if l.legalHold != "" {
+		input.ObjectLockLegalHoldStatus = aws.String(l.legalHold)
+	}
+}
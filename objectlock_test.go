@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestApplySourceObjectLock(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	input := &s3.CopyObjectInput{}
+	applySourceObjectLock(input, &s3.HeadObjectOutput{
+		ObjectLockMode:            aws.String(s3.ObjectLockModeCompliance),
+		ObjectLockRetainUntilDate: aws.Time(future),
+		ObjectLockLegalHoldStatus: aws.String(s3.ObjectLockLegalHoldStatusOn),
+	})
+	if aws.StringValue(input.ObjectLockMode) != s3.ObjectLockModeCompliance || !aws.TimeValue(input.ObjectLockRetainUntilDate).Equal(future) ||
+		aws.StringValue(input.ObjectLockLegalHoldStatus) != s3.ObjectLockLegalHoldStatusOn {
+		t.Errorf("lock = %v, %v, %v, want the lock of the source", input.ObjectLockMode, input.ObjectLockRetainUntilDate, input.ObjectLockLegalHoldStatus)
+	}
+
+	input = &s3.CopyObjectInput{}
+	applySourceObjectLock(input, &s3.HeadObjectOutput{
+		ObjectLockMode:            aws.String(s3.ObjectLockModeGovernance),
+		ObjectLockRetainUntilDate: aws.Time(time.Now().Add(-time.Hour)),
+	})
+	if input.ObjectLockMode != nil || input.ObjectLockRetainUntilDate != nil || input.ObjectLockLegalHoldStatus != nil {
+		t.Errorf("lock = %v, %v, %v, want the expired retention dropped", input.ObjectLockMode, input.ObjectLockRetainUntilDate, input.ObjectLockLegalHoldStatus)
+	}
+}
+
+func TestParseObjectLock(t *testing.T) {
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		argv []string
+		want objectLock
+	}{
+		{nil, objectLock{}},
+		{[]string{"--object-lock-legal-hold", "on"}, objectLock{legalHold: s3.ObjectLockLegalHoldStatusOn}},
+		{[]string{"--object-lock-mode", "governance", "--object-lock-retain-until", "2021-01-02"},
+			objectLock{mode: s3.ObjectLockModeGovernance, retainUntil: &until}},
+		{[]string{"--object-lock-mode", "COMPLIANCE", "--object-lock-retain-until", "2021-01-02T00:00:00Z", "--object-lock-legal-hold", "OFF"},
+			objectLock{mode: s3.ObjectLockModeCompliance, retainUntil: &until, legalHold: s3.ObjectLockLegalHoldStatusOff}},
+	}
+	for _, test := range tests {
+		parseArgs(t, test.argv...)
+		got, err := parseObjectLock(now)
+		if err != nil {
+			t.Errorf("parseObjectLock() with %q: %v", test.argv, err)
+			continue
+		}
+		if got.mode != test.want.mode || got.legalHold != test.want.legalHold ||
+			(got.retainUntil == nil) != (test.want.retainUntil == nil) ||
+			(got.retainUntil != nil && !got.retainUntil.Equal(*test.want.retainUntil)) {
+			t.Errorf("parseObjectLock() with %q = %+v, want %+v", test.argv, got, test.want)
+		}
+	}
+
+	errs := map[string]string{
+		"--object-lock-legal-hold maybe":                                      "--object-lock-legal-hold must be ON or OFF",
+		"--object-lock-mode GOVERNANCE":                                       "set the retention together",
+		"--object-lock-retain-until 2021-01-02":                               "set the retention together",
+		"--object-lock-mode LEGAL --object-lock-retain-until 2021-01-02":      "--object-lock-mode must be GOVERNANCE or COMPLIANCE",
+		"--object-lock-mode GOVERNANCE --object-lock-retain-until tomorrow":   "--object-lock-retain-until: invalid date",
+		"--object-lock-mode GOVERNANCE --object-lock-retain-until 2020-01-01": "is not in the future",
+	}
+	for argv, want := range errs {
+		parseArgs(t, strings.Fields(argv)...)
+		if _, err := parseObjectLock(now); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseObjectLock() with %q = %v, want %q", argv, err, want)
+		}
+	}
+}
+
+func TestObjectLockApply(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	input := &s3.CopyObjectInput{
+		ObjectLockMode:            aws.String(s3.ObjectLockModeGovernance),
+		ObjectLockRetainUntilDate: aws.Time(time.Now()),
+		ObjectLockLegalHoldStatus: aws.String(s3.ObjectLockLegalHoldStatusOn),
+	}
+	objectLock{mode: s3.ObjectLockModeCompliance, retainUntil: &until}.apply(input)
+	if aws.StringValue(input.ObjectLockMode) != s3.ObjectLockModeCompliance || input.ObjectLockRetainUntilDate != &until ||
+		aws.StringValue(input.ObjectLockLegalHoldStatus) != s3.ObjectLockLegalHoldStatusOn {
+		t.Errorf("apply() = %v, %v, %v, want the retention replaced and the legal hold kept",
+			input.ObjectLockMode, input.ObjectLockRetainUntilDate, input.ObjectLockLegalHoldStatus)
+	}
+	objectLock{legalHold: s3.ObjectLockLegalHoldStatusOff}.apply(input)
+	if aws.StringValue(input.ObjectLockMode) != s3.ObjectLockModeCompliance || aws.StringValue(input.ObjectLockLegalHoldStatus) != s3.ObjectLockLegalHoldStatusOff {
+		t.Errorf("apply() = %v, %v, want the legal hold replaced and the retention kept", input.ObjectLockMode, input.ObjectLockLegalHoldStatus)
+	}
+}
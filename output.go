@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Formats of the --format flag.
+const (
+	formatText = "text"
+	formatCSV  = "csv"
+	formatJSON = "json"
+)
+
+// output writes the rows of the dry-run plan, the count and the summary
+// as CSV with a header line or as JSON lines. The text format is written
+// by the callers themselves.
+type output struct {
+	format string
+	w      io.Writer
+
+	mu     sync.Mutex
+	csv    *csv.Writer
+	header bool
+}
+
+// newOutput returns the output of the format.
+func newOutput(format string, w io.Writer) (*output, error) {
+	switch format {
+	case formatText, formatCSV, formatJSON:
+	default:
+		return nil, fmt.Errorf("--format must be text, csv or json, got %q", format)
+	}
+	return &output{format: format, w: w, csv: csv.NewWriter(w)}, nil
+}
+
+// text reports whether the callers write the plain text lines.
+func (o *output) text() bool {
+	return o.format == formatText
+}
+
+// write writes a row of the values named by the fields.
+// The CSV header is taken from the fields of the first row,
+// and the nil values are written as empty CSV cells or JSON nulls.
+func (o *output) write(fields []string, values ...interface{}) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.format == formatJSON {
+		row := make(map[string]interface{}, len(fields))
+		for i, field := range fields {
+			row[field] = values[i]
+		}
+		line, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		_, err = o.w.Write(append(line, '\n'))
+		return err
+	}
+	if !o.header {
+		o.header = true
+		if err := o.csv.Write(fields); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(values))
+	for i, value := range values {
+		if value != nil {
+			record[i] = fmt.Sprint(value)
+		}
+	}
+	if err := o.csv.Write(record); err != nil {
+		return err
+	}
+	o.csv.Flush()
+	return o.csv.Error()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOutputWrite(t *testing.T) {
+	fields := []string{"key", "status", "dest_size"}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{formatCSV, "key,status,dest_size\na.txt,NEW,\n\"b,c.txt\",OVERWRITE,10\n"},
+		{formatJSON, `{"dest_size":null,"key":"a.txt","status":"NEW"}` + "\n" + `{"dest_size":10,"key":"b,c.txt","status":"OVERWRITE"}` + "\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		out, err := newOutput(test.format, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.text() {
+			t.Errorf("%s output writes the text lines", test.format)
+		}
+		if err := out.write(fields, "a.txt", planNew, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := out.write(fields, "b,c.txt", planOverwrite, int64(10)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s output = %q, want %q", test.format, buf.String(), test.want)
+		}
+	}
+
+	out, err := newOutput(formatText, &bytes.Buffer{})
+	if err != nil || !out.text() {
+		t.Errorf("newOutput(text) = %v, %v, want the text output", out, err)
+	}
+	if _, err := newOutput("yaml", &bytes.Buffer{}); err == nil {
+		t.Error("newOutput(yaml) succeeded, want an error")
+	}
+}
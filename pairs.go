@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// copyPair is a line of the --pairs input with the source object
+// and its own destination.
+type copyPair struct {
+	sourceBucket string
+	sourceKey    string
+	targetBucket string
+	targetKey    string
+}
+
+// parsePair parses the tab-separated s3:// URLs of the source object and the
+// destination. A destination ending with a slash gets the base name of the source key.
+func parsePair(line string) (copyPair, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 2 {
+		return copyPair{}, fmt.Errorf("expected SOURCE<TAB>DESTINATION, got %d fields", len(fields))
+	}
+	source, err := url.Parse(strings.TrimSpace(fields[0]))
+	if err != nil || source.Scheme != "s3" || source.Host == "" || strings.Trim(source.Path, "/") == "" {
+		return copyPair{}, fmt.Errorf("source %q must be an s3://bucket/key url", fields[0])
+	}
+	target, err := url.Parse(strings.TrimSpace(fields[1]))
+	if err != nil || target.Scheme != "s3" || target.Host == "" {
+		return copyPair{}, fmt.Errorf("destination %q must be an s3://bucket/key url", fields[1])
+	}
+	pair := copyPair{
+		sourceBucket: source.Host,
+		sourceKey:    strings.TrimPrefix(source.Path, "/"),
+		targetBucket: target.Host,
+		targetKey:    strings.TrimPrefix(target.Path, "/"),
+	}
+	if pair.targetKey == "" || strings.HasSuffix(pair.targetKey, "/") {
+		pair.targetKey += path.Base(pair.sourceKey)
+	}
+	if !args.AllowSame && pair.sourceBucket == pair.targetBucket && pair.sourceKey == pair.targetKey {
+		return copyPair{}, fmt.Errorf("source and destination are the same object %s (use --allow-same to copy in place)", fields[0])
+	}
+	return pair, nil
+}
+
+// readPairs reads the lines of the source and destination URLs and calls fn
+// for every pair. Empty lines are skipped, and an invalid line stops the reading.
+func readPairs(ctx context.Context, r io.Reader, fn func(copyPair)) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		pair, err := parsePair(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		fn(pair)
+	}
+	return scanner.Err()
+}
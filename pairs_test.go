@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePair(t *testing.T) {
+	saveArgs(t)
+	args.AllowSame = false
+	tests := map[string]copyPair{
+		"s3://src/dir/a.txt\ts3://dst/b.txt":     {"src", "dir/a.txt", "dst", "b.txt"},
+		"s3://src/dir/a.txt\ts3://dst/archive/":  {"src", "dir/a.txt", "dst", "archive/a.txt"},
+		" s3://src/dir/a.txt \t s3://dst ":       {"src", "dir/a.txt", "dst", "a.txt"},
+		"s3://src/dir/a.txt\ts3://src/dir/b.txt": {"src", "dir/a.txt", "src", "dir/b.txt"},
+	}
+	for line, want := range tests {
+		if got, err := parsePair(line); got != want || err != nil {
+			t.Errorf("parsePair(%q) = %+v, %v, want %+v", line, got, err, want)
+		}
+	}
+
+	errs := map[string]string{
+		"s3://src/a.txt":                    "expected SOURCE<TAB>DESTINATION, got 1 fields",
+		"s3://src/a\ts3://dst/\ts3://dst2/": "got 3 fields",
+		"http://src/a.txt\ts3://dst/":       "must be an s3://bucket/key url",
+		"s3://src/\ts3://dst/":              "must be an s3://bucket/key url",
+		"s3://src/a.txt\t/dst/":             "must be an s3://bucket/key url",
+		"s3://src/dir/a.txt\ts3://src/dir/": "same object",
+	}
+	for line, want := range errs {
+		if _, err := parsePair(line); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parsePair(%q) = %v, want %q", line, err, want)
+		}
+	}
+	args.AllowSame = true
+	if _, err := parsePair("s3://src/dir/a.txt\ts3://src/dir/"); err != nil {
+		t.Errorf("parsePair() of the same object with --allow-same: %v", err)
+	}
+}
+
+func TestReadPairs(t *testing.T) {
+	saveArgs(t)
+	args.AllowSame = false
+	input := "s3://src/a\ts3://dst/\r\n\ns3://src/b\ts3://dst/c\nbad\n"
+	var pairs []copyPair
+	err := readPairs(context.Background(), strings.NewReader(input), func(p copyPair) { pairs = append(pairs, p) })
+	if err == nil || !strings.HasPrefix(err.Error(), "line 4:") {
+		t.Errorf("readPairs() = %v, want the error of line 4", err)
+	}
+	if want := []copyPair{{"src", "a", "dst", "a"}, {"src", "b", "dst", "c"}}; !reflect.DeepEqual(pairs, want) {
+		t.Errorf("readPairs() = %+v, want %+v", pairs, want)
+	}
+}
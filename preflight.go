@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// firstObject returns the key of the first object under the prefix of the bucket,
+// or an empty string when there are no objects.
+func firstObject(ctx context.Context, svc *s3.S3, bucket, prefix string) (string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(1),
+	}
+	if args.EncodingType != "" {
+		input.EncodingType = aws.String(args.EncodingType)
+	}
+	out, err := svc.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	if len(out.Contents) == 0 {
+		return "", nil
+	}
+	return aws.StringValue(decodeKey(out.Contents[0].Key)), nil
+}
+
+// preflight checks the access to both buckets and copies the source key to the
+// temporary key of the target bucket and deletes it again, so missing permissions
+// are found before the bulk work. An SSE-KMS source key is read first to check
+// the permission to decrypt it. The test copy is skipped without a source key.
+func preflight(ctx context.Context, sourceSvc, svc *s3.S3, sourceBucket, sourceKey, targetBucket, tempKey string) error {
+	if _, err := sourceSvc.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(sourceBucket)}); err != nil {
+		return fmt.Errorf("source bucket %s isn't accessible: %s", sourceBucket, describeError(err))
+	}
+	if _, err := svc.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(targetBucket)}); err != nil {
+		return fmt.Errorf("destination bucket %s isn't accessible: %s", targetBucket, describeError(err))
+	}
+	if sourceKey == "" {
+		return nil
+	}
+	if err := checkKMSDecrypt(ctx, sourceSvc, sourceBucket, sourceKey); err != nil {
+		return err
+	}
+	_, err := svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		CopySource:   aws.String(copySource(sourceBucket, sourceKey, "")),
+		Bucket:       aws.String(targetBucket),
+		Key:          aws.String(tempKey),
+		ACL:          aws.String(args.ACL),
+		StorageClass: aws.String(args.StorageClass),
+	})
+	if err != nil {
+		return fmt.Errorf("test copy of %s to %s failed: %s", sourceKey, tempKey, describeError(err))
+	}
+	_, err = svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(targetBucket),
+		Key:    aws.String(tempKey),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete the test copy %s: %s", tempKey, describeError(err))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFirstObject(t *testing.T) {
+	saveArgs(t)
+	args.EncodingType = ""
+	fake, svc := newFakeS3(t)
+	fake.put("src", "data/b", 1)
+	fake.put("src", "data/a", 1)
+	fake.put("src", "other", 1)
+	ctx := context.Background()
+	if key, err := firstObject(ctx, svc, "src", "data/"); key != "data/a" || err != nil {
+		t.Errorf("firstObject(data/) = %q, %v, want data/a", key, err)
+	}
+	if key, err := firstObject(ctx, svc, "src", "none/"); key != "" || err != nil {
+		t.Errorf("firstObject(none/) = %q, %v, want no key", key, err)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	saveArgs(t)
+	fake, svc := newFakeS3(t)
+	fake.put("src", "data/a", 1)
+	fake.put("dst", "existing", 1)
+	ctx := context.Background()
+
+	if err := preflight(ctx, svc, svc, "src", "data/a", "dst", "tmp/preflight"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"HEAD src", "HEAD dst", "HEAD data/a", "COPY tmp/preflight", "DELETE tmp/preflight"}
+	if !reflect.DeepEqual(fake.requests, want) {
+		t.Errorf("requests = %q, want %q", fake.requests, want)
+	}
+	if _, ok := fake.buckets["dst"]["tmp/preflight"]; ok {
+		t.Error("preflight() left the test copy")
+	}
+
+	fake.requests = nil
+	if err := preflight(ctx, svc, svc, "src", "", "dst", "tmp/preflight"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"HEAD src", "HEAD dst"}; !reflect.DeepEqual(fake.requests, want) {
+		t.Errorf("requests without a source key = %q, want %q", fake.requests, want)
+	}
+
+	tests := []struct {
+		source, key, target string
+		want                string
+	}{
+		{"missing", "data/a", "dst", "source bucket missing isn't accessible"},
+		{"src", "data/a", "missing", "destination bucket missing isn't accessible"},
+		{"src", "data/b", "dst", "failed to head data/b"},
+	}
+	for _, test := range tests {
+		err := preflight(ctx, svc, svc, test.source, test.key, test.target, "tmp/preflight")
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("preflight(%s, %s, %s) = %v, want %q", test.source, test.key, test.target, err, test.want)
+		}
+	}
+}
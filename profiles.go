@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profiles are the presets of --profile, the values of the long flags that
+// work together. They replace the defaults like the config file, which
+// overrides them as well as the environment and command-line flags do.
+var profiles = map[string]map[string]string{
+	// Many connections and few retries for the buckets with a lot of headroom.
+	"fast": {
+		"concurrency":        "200",
+		"max-idle-conns":     "400",
+		"max-conns-per-host": "400",
+		"max-retries":        "3",
+		"retry-mode":         retryModeStandard,
+	},
+	// Few connections and patient retries for the copies that must not fail.
+	"safe": {
+		"concurrency":      "20",
+		"max-retries":      "10",
+		"retry-mode":       retryModeAdaptive,
+		"retry-base-delay": "200ms",
+		"retry-max-delay":  "30s",
+		"retry-jitter":     "0.5",
+	},
+	// A global rate that backs off on errors for the buckets shared with other workloads.
+	"throttle-friendly": {
+		"concurrency":      "50",
+		"ramp-up":          "30s",
+		"max-rate":         "300",
+		"auto-throttle":    "true",
+		"max-retries":      "8",
+		"retry-mode":       retryModeAdaptive,
+		"retry-base-delay": "500ms",
+		"retry-max-delay":  "1m",
+		"retry-jitter":     "0.5",
+	},
+}
+
+// applyProfile sets the flag values of the named profile in dest after parsing,
+// except the given flags.
+func applyProfile(name string, dest interface{}, given map[string]bool) error {
+	profile, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown profile %q, use %s", name, strings.Join(names, ", "))
+	}
+	fields := configFields(dest)
+	for flag, value := range profile {
+		if given[flag] {
+			continue
+		}
+		if err := setConfigValue(fields[flag], value); err != nil {
+			return fmt.Errorf("invalid value %q of %s in profile %s: %v", value, flag, name, err)
+		}
+	}
+	return nil
+}
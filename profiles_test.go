@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProfilesApply(t *testing.T) {
+	for name := range profiles {
+		parseArgs(t)
+		if err := applyProfile(name, &args, nil); err != nil {
+			t.Errorf("applyProfile(%s): %v", name, err)
+		}
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	parseArgs(t, "--concurrency", "5")
+	if err := applyProfile("throttle-friendly", &args, map[string]bool{"concurrency": true}); err != nil {
+		t.Fatal(err)
+	}
+	if args.Concurrency != 5 {
+		t.Errorf("concurrency = %d, want 5 of the command line", args.Concurrency)
+	}
+	if args.MaxRetries != 8 || args.RetryMode != retryModeAdaptive || args.RetryBaseDelay != 500*time.Millisecond ||
+		args.RampUp != 30*time.Second || !args.AutoThrottle {
+		t.Errorf("flags = %d, %s, %s, %s, %v, want the values of the profile",
+			args.MaxRetries, args.RetryMode, args.RetryBaseDelay, args.RampUp, args.AutoThrottle)
+	}
+
+	want := `unknown profile "turbo", use fast, safe, throttle-friendly`
+	if err := applyProfile("turbo", &args, nil); err == nil || err.Error() != want {
+		t.Errorf("applyProfile(turbo) = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// progress is the checkpoint written to the --progress-file.
+type progress struct {
+	Copied          int64    `json:"copied"`
+	Skipped         int64    `json:"skipped"`
+	Failed          int64    `json:"failed"`
+	Total           int64    `json:"total"`
+	TotalEstimated  bool     `json:"total_estimated"`
+	ListingComplete bool     `json:"listing_complete"`
+	Bytes           int64    `json:"bytes"`
+	Rate            float64  `json:"rate"`
+	ETASeconds      *float64 `json:"eta_seconds"`
+	UpdatedAt       string   `json:"updated_at"`
+}
+
+// newProgress returns the progress of the summary out of the total objects
+// scheduled so far, which is final once the listing is complete. Until then
+// the estimate of --object-count-estimate is the total when it's larger.
+func newProgress(s *summary, total, estimate int64, listingComplete bool, elapsed time.Duration, now time.Time) progress {
+	p := progress{
+		Copied:          atomic.LoadInt64(&s.copied),
+		Skipped:         atomic.LoadInt64(&s.skipped),
+		Failed:          atomic.LoadInt64(&s.failed),
+		Total:           total,
+		ListingComplete: listingComplete,
+		Bytes:           atomic.LoadInt64(&s.bytes),
+		UpdatedAt:       now.UTC().Format(time.RFC3339),
+	}
+	if !listingComplete && estimate > total {
+		p.Total, p.TotalEstimated = estimate, true
+	}
+	var ok bool
+	var eta time.Duration
+	p.Rate, eta, ok = estimateETA(p.Copied+p.Skipped+p.Failed, p.Total, elapsed)
+	if ok && (listingComplete || p.TotalEstimated) {
+		seconds := eta.Seconds()
+		p.ETASeconds = &seconds
+	}
+	return p
+}
+
+// estimateETA returns the rate of the done objects per second and the time
+// left for the rest of the total at that rate. It returns false while nothing
+// is done yet, so there is no rate to extrapolate from.
+func estimateETA(done, total int64, elapsed time.Duration) (float64, time.Duration, bool) {
+	if done <= 0 || elapsed <= 0 {
+		return 0, 0, false
+	}
+	rate := float64(done) / elapsed.Seconds()
+	remaining := total - done
+	if remaining < 0 {
+		remaining = 0
+	}
+	return rate, time.Duration(float64(remaining) / rate * float64(time.Second)), true
+}
+
+// writeFileAtomic replaces the file with the data, so a reader sees either
+// the previous or the new content, never a partial write.
+func writeFileAtomic(filename string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// writeProgress writes the progress as JSON to the file.
+func writeProgress(filename string, p progress) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, append(data, '\n'))
+}
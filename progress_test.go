@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEstimateETA(t *testing.T) {
+	tests := []struct {
+		done, total int64
+		elapsed     time.Duration
+		rate        float64
+		eta         time.Duration
+		ok          bool
+	}{
+		{0, 100, time.Minute, 0, 0, false},
+		{10, 100, 0, 0, 0, false},
+		{10, 100, 10 * time.Second, 1, 90 * time.Second, true},
+		{50, 100, 10 * time.Second, 5, 10 * time.Second, true},
+		{120, 100, 10 * time.Second, 12, 0, true},
+	}
+	for _, test := range tests {
+		rate, eta, ok := estimateETA(test.done, test.total, test.elapsed)
+		if rate != test.rate || eta != test.eta || ok != test.ok {
+			t.Errorf("estimateETA(%d, %d, %s) = %v, %s, %v, want %v, %s, %v",
+				test.done, test.total, test.elapsed, rate, eta, ok, test.rate, test.eta, test.ok)
+		}
+	}
+}
+
+func TestNewProgress(t *testing.T) {
+	s := &summary{copied: 6, skipped: 2, failed: 2, bytes: 1024}
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	p := newProgress(s, 20, 0, true, 10*time.Second, now)
+	if p.Copied != 6 || p.Skipped != 2 || p.Failed != 2 || p.Bytes != 1024 || p.Total != 20 || p.TotalEstimated || p.Rate != 1 {
+		t.Errorf("newProgress() = %+v", p)
+	}
+	if p.UpdatedAt != "2020-01-02T02:04:05Z" {
+		t.Errorf("UpdatedAt = %s, want the UTC time", p.UpdatedAt)
+	}
+	if p.ETASeconds == nil || *p.ETASeconds != 10 {
+		t.Errorf("ETASeconds = %v, want 10", p.ETASeconds)
+	}
+
+	if p := newProgress(s, 20, 0, false, 10*time.Second, now); p.ETASeconds != nil {
+		t.Errorf("ETASeconds of an incomplete listing = %v, want none", *p.ETASeconds)
+	}
+	p = newProgress(s, 20, 100, false, 10*time.Second, now)
+	if p.Total != 100 || !p.TotalEstimated || p.ETASeconds == nil || *p.ETASeconds != 90 {
+		t.Errorf("newProgress() with an estimate = %+v, want the estimated total", p)
+	}
+	if p := newProgress(s, 20, 100, true, 10*time.Second, now); p.Total != 20 || p.TotalEstimated {
+		t.Errorf("newProgress() of a complete listing = %+v, want the listed total", p)
+	}
+}
+
+func TestWriteProgress(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "progress.json")
+	if err := os.WriteFile(filename, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	want := progress{Copied: 3, Total: 10, ListingComplete: true, UpdatedAt: "2020-01-02T03:04:05Z"}
+	if err := writeProgress(filename, want); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got progress
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("progress file = %+v, want %+v", got, want)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %s, want 0644", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory has %d files, want the temporary file removed", len(entries))
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "progress.json"), data); err == nil {
+		t.Error("writeFileAtomic() to a missing directory succeeded, want an error")
+	}
+}
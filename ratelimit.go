@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces the operations evenly to the rate per second.
+type rateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newRateLimiter returns the limiter of the rate per second.
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// setRate changes the rate per second of the following reservations.
+func (l *rateLimiter) setRate(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = time.Duration(float64(time.Second) / rate)
+}
+
+// reserve takes the next free slot at or after now and returns its delay.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	return delay
+}
+
+// wait blocks until the operation is allowed or the context is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// prefixLimiter keeps a limiter per first path segment under the source prefix,
+// so that no single S3 partition of a shared bucket gets more than the rate.
+type prefixLimiter struct {
+	rate   float64
+	prefix string
+
+	mu       sync.Mutex
+	limiters map[string]*rateLimiter
+}
+
+// newPrefixLimiter returns the limiter of the rate per second for the keys under the prefix.
+func newPrefixLimiter(rate float64, prefix string) *prefixLimiter {
+	return &prefixLimiter{rate: rate, prefix: prefix, limiters: make(map[string]*rateLimiter)}
+}
+
+// segment returns the first path segment of the key under the prefix,
+// or an empty string for the keys right under the prefix.
+func (p *prefixLimiter) segment(key string) string {
+	rest := strings.TrimPrefix(strings.TrimPrefix(key, p.prefix), "/")
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		return ""
+	}
+	return rest[:i]
+}
+
+// limiter returns the limiter of the key.
+func (p *prefixLimiter) limiter(key string) *rateLimiter {
+	segment := p.segment(key)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.limiters[segment]
+	if !ok {
+		l = newRateLimiter(p.rate)
+		p.limiters[segment] = l
+	}
+	return l
+}
+
+// wait blocks until the copy of the key is allowed or the context is done.
+func (p *prefixLimiter) wait(ctx context.Context, key string) error {
+	return p.limiter(key).wait(ctx)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := newRateLimiter(4)
+	now := time.Unix(1600000000, 0)
+	for i, want := range []time.Duration{0, 250 * time.Millisecond, 500 * time.Millisecond} {
+		if got := l.reserve(now); got != want {
+			t.Errorf("reserve() #%d = %s, want %s", i+1, got, want)
+		}
+	}
+	if got := l.reserve(now.Add(time.Second)); got != 0 {
+		t.Errorf("reserve() after the free slots = %s, want 0", got)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := newRateLimiter(0.001)
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatalf("wait() of the first slot: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx); err != context.Canceled {
+		t.Errorf("wait() of a canceled context = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPrefixLimiterSegment(t *testing.T) {
+	p := newPrefixLimiter(10, "data/")
+	tests := map[string]string{
+		"data/2020/01/a.txt": "2020",
+		"data/2021/a.txt":    "2021",
+		"data/a.txt":         "",
+	}
+	for key, want := range tests {
+		if got := p.segment(key); got != want {
+			t.Errorf("segment(%q) = %q, want %q", key, got, want)
+		}
+	}
+	if p.limiter("data/2020/a") != p.limiter("data/2020/b") {
+		t.Error("limiter() of the same segment returned different limiters")
+	}
+	if p.limiter("data/2020/a") == p.limiter("data/2021/a") {
+		t.Error("limiter() of different segments returned the same limiter")
+	}
+}
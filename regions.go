@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/yaml.v3"
+)
+
+// readRegionMap reads the bucket names and their regions from a YAML
+// (.yaml, .yml) or TOML (.toml) file of the --region-map.
+func readRegionMap(filename string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	regions := make(map[string]string)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &regions)
+	case ".toml":
+		err = toml.Unmarshal(data, &regions)
+	default:
+		return nil, fmt.Errorf("unsupported region map format %q, use .yaml, .yml or .toml", filepath.Ext(filename))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse region map %s: %v", filename, err)
+	}
+	for bucket, region := range regions {
+		if region == "" {
+			return nil, fmt.Errorf("empty region of bucket %q in region map %s", bucket, filename)
+		}
+	}
+	return regions, nil
+}
+
+// regionClients returns the S3 clients of the role in the regions of the buckets.
+// The buckets missing in the region map are located with GetBucketLocation,
+// and a client is created once per region. It's safe for concurrent use.
+type regionClients struct {
+	sess    *session.Session
+	roleARN string
+	locator *s3.S3
+
+	mu      sync.Mutex
+	regions map[string]string
+	clients map[string]*s3.S3
+}
+
+func newRegionClients(sess *session.Session, roleARN string, regions map[string]string) *regionClients {
+	c := &regionClients{
+		sess:    sess,
+		roleARN: roleARN,
+		locator: newClient(sess, roleARN),
+		regions: make(map[string]string, len(regions)),
+		clients: make(map[string]*s3.S3),
+	}
+	for bucket, region := range regions {
+		c.regions[bucket] = region
+	}
+	return c
+}
+
+// region returns the mapped or the detected region of the bucket.
+func (c *regionClients) region(ctx context.Context, bucket string) (string, error) {
+	c.mu.Lock()
+	region, ok := c.regions[bucket]
+	c.mu.Unlock()
+	if ok {
+		return region, nil
+	}
+	out, err := c.locator.GetBucketLocationWithContext(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return "", fmt.Errorf("failed to get the region of bucket %s: %w", bucket, err)
+	}
+	region = s3.NormalizeBucketLocation(aws.StringValue(out.LocationConstraint))
+	c.mu.Lock()
+	c.regions[bucket] = region
+	c.mu.Unlock()
+	return region, nil
+}
+
+// client returns the client in the region of the bucket.
+func (c *regionClients) client(ctx context.Context, bucket string) (*s3.S3, error) {
+	region, err := c.region(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[region]; ok {
+		return client, nil
+	}
+	sess := c.sess.Copy(&aws.Config{Region: aws.String(region)})
+	client := newClient(sess, c.roleARN)
+	c.clients[region] = client
+	return client, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestReadRegionMap(t *testing.T) {
+	dir := t.TempDir()
+	want := map[string]string{"bucket1": "eu-west-1", "bucket2": "us-west-2"}
+	files := map[string]string{
+		"regions.yaml": "bucket1: eu-west-1\nbucket2: us-west-2\n",
+		"regions.yml":  "bucket1: eu-west-1\nbucket2: us-west-2\n",
+		"regions.TOML": "bucket1 = \"eu-west-1\"\nbucket2 = \"us-west-2\"\n",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := readRegionMap(filename); !reflect.DeepEqual(got, want) || err != nil {
+			t.Errorf("readRegionMap(%s) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+
+	errs := map[string]string{
+		"regions.json": "unsupported region map format",
+		"invalid.yaml": "failed to parse region map",
+		"empty.toml":   "empty region of bucket",
+		"missing.yaml": "no such file",
+	}
+	contents := map[string]string{"regions.json": "{}", "invalid.yaml": "- a\n- b\n", "empty.toml": "bucket1 = \"\"\n"}
+	for name, want := range errs {
+		filename := filepath.Join(dir, name)
+		if content, ok := contents[name]; ok {
+			if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := readRegionMap(filename); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("readRegionMap(%s) = %v, want %q", name, err, want)
+		}
+	}
+}
+
+func TestRegionClients(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	fake.put("mapped", "a", 1)
+	fake.put("located", "a", 1)
+	fake.put("default", "a", 1)
+	fake.locations["located"] = "eu-west-1"
+	sess, err := session.NewSession(svc.Client.Config.Copy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newRegionClients(sess, "", map[string]string{"mapped": "us-west-2"})
+	ctx := context.Background()
+
+	for _, r := range [][2]string{{"mapped", "us-west-2"}, {"located", "eu-west-1"}, {"default", "us-east-1"}} {
+		client, err := c.client(ctx, r[0])
+		if err != nil {
+			t.Fatalf("client(%s): %v", r[0], err)
+		}
+		if region := aws.StringValue(client.Config.Region); region != r[1] {
+			t.Errorf("client(%s) region = %s, want %s", r[0], region, r[1])
+		}
+	}
+	first, _ := c.client(ctx, "located")
+	if second, _ := c.client(ctx, "located"); first != second {
+		t.Error("client() created another client of the region")
+	}
+	if want := []string{"LOCATION located", "LOCATION default"}; !reflect.DeepEqual(fake.requests, want) {
+		t.Errorf("requests = %q, want the regions of the unmapped buckets located once", fake.requests)
+	}
+	if _, err := c.region(ctx, "missing"); err == nil || !strings.Contains(err.Error(), "failed to get the region of bucket missing") {
+		t.Errorf("region(missing) = %v, want an error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// replicaQueueSize is the number of secondary copies waiting for the replica
+// workers, the primary workers block only when that many are pending.
+const replicaQueueSize = 10000
+
+// replicaCopy is a secondary copy of --replica-bucket.
+type replicaCopy struct {
+	input *s3.CopyObjectInput
+	size  *int64
+	// tags are set with a PutObjectTagging request after the copy,
+	// when the primary copy was tagged after copying too.
+	tags map[string]string
+	// fail records the failure with the ones of the primary copies.
+	fail func(error)
+}
+
+// replicator copies the objects to the replica bucket in the background after
+// their primary copies succeeded, with its own workers and summary.
+type replicator struct {
+	svc    *s3.S3
+	bucket string
+	queue  chan replicaCopy
+	wg     sync.WaitGroup
+	stats  summary
+}
+
+// newReplicator starts the workers copying to the bucket with the client.
+func newReplicator(ctx context.Context, svc *s3.S3, bucket string, workers int, loginfo, logerr *log.Logger) *replicator {
+	r := &replicator{
+		svc:    svc,
+		bucket: bucket,
+		queue:  make(chan replicaCopy, replicaQueueSize),
+		stats:  summary{name: "replica"},
+	}
+	for i := 0; i < workers; i++ {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for c := range r.queue {
+				if err := r.copy(ctx, c); err != nil {
+					logerr.Printf("Failed to replicate object %s to bucket %s: %s\n", aws.StringValue(c.input.Key), r.bucket, describeError(err))
+					r.stats.failure(err)
+					if c.fail != nil {
+						c.fail(err)
+					}
+					continue
+				}
+				r.stats.success(c.size)
+				loginfo.Printf("Item %q replicated to bucket %q\n", aws.StringValue(c.input.Key), r.bucket)
+			}
+		}()
+	}
+	return r
+}
+
+// copy makes the secondary copy and tags it.
+func (r *replicator) copy(ctx context.Context, c replicaCopy) error {
+	if _, err := r.svc.CopyObjectWithContext(ctx, c.input); err != nil {
+		return err
+	}
+	if len(c.tags) == 0 {
+		return nil
+	}
+	_, err := r.svc.PutObjectTaggingWithContext(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  c.input.Bucket,
+		Key:     c.input.Key,
+		Tagging: &s3.Tagging{TagSet: tagSet(c.tags)},
+	})
+	return err
+}
+
+// add queues the secondary copy with the request of the primary copy and
+// the tags set after the primary copy, if any. The preconditions of the primary
+// copy, which compared the source with the primary destination, don't apply
+// to the replica. The failure of the replica is passed to fail.
+func (r *replicator) add(input *s3.CopyObjectInput, size *int64, tags map[string]string, fail func(error)) {
+	replica := *input
+	replica.Bucket = aws.String(r.bucket)
+	replica.CopySourceIfMatch = nil
+	replica.CopySourceIfNoneMatch = nil
+	replica.CopySourceIfModifiedSince = nil
+	replica.CopySourceIfUnmodifiedSince = nil
+	replica.ExpectedBucketOwner = nil
+	r.queue <- replicaCopy{input: &replica, size: size, tags: tags, fail: fail}
+}
+
+// wait blocks until the queued copies are done, no copies can be added after it.
+func (r *replicator) wait() {
+	if r != nil {
+		close(r.queue)
+		r.wg.Wait()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestReplicator(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	fake.put("src", "a", 10)
+	fake.put("replica", "other", 1)
+	var info, errs bytes.Buffer
+	r := newReplicator(context.Background(), svc, "replica", 2, log.New(&info, "", 0), log.New(&errs, "", 0))
+
+	// The preconditions compared the source with the primary destination.
+	input := &s3.CopyObjectInput{
+		Bucket:              aws.String("dst"),
+		Key:                 aws.String("backup/a"),
+		CopySource:          aws.String(copySource("src", "a", "")),
+		CopySourceIfMatch:   aws.String(`"stale"`),
+		ExpectedBucketOwner: aws.String("123456789012"),
+	}
+	r.add(input, aws.Int64(10), map[string]string{"team": "data"}, nil)
+	var failed error
+	r.add(&s3.CopyObjectInput{Bucket: aws.String("dst"), Key: aws.String("backup/b"), CopySource: aws.String(copySource("src", "b", ""))}, aws.Int64(1),
+		nil, func(err error) { failed = err })
+	r.wait()
+
+	if o := fake.buckets["replica"]["backup/a"]; o == nil || o.tags != "team=data" {
+		t.Error("backup/a wasn't replicated with its tags")
+	}
+	if !missingSource(failed) {
+		t.Errorf("failure of backup/b = %v, want the missing source", failed)
+	}
+	if r.stats.copied != 1 || r.stats.bytes != 10 || r.stats.failed != 1 {
+		t.Errorf("replica summary = %d copied (%d bytes), %d failed, want 1 (10 bytes), 1", r.stats.copied, r.stats.bytes, r.stats.failed)
+	}
+	if aws.StringValue(input.Bucket) != "dst" || input.CopySourceIfMatch == nil {
+		t.Error("add() changed the input of the primary copy")
+	}
+	if want := "Item \"backup/a\" replicated to bucket \"replica\"\n"; info.String() != want {
+		t.Errorf("info = %q, want %q", info.String(), want)
+	}
+	if !bytes.HasPrefix(errs.Bytes(), []byte("Failed to replicate object backup/b to bucket replica:")) {
+		t.Errorf("errors = %q, want the failed replica", errs.String())
+	}
+
+	var none *replicator
+	none.wait()
+}
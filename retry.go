@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Modes of the --retry-mode flag.
+const (
+	retryModeStandard = "standard"
+	retryModeAdaptive = "adaptive"
+)
+
+// defaultRetryCodes are the error codes retried when --retry-codes is not set.
+// They cover throttling, transient server and connection errors.
+var defaultRetryCodes = []string{
+	"InternalError",
+	"RequestError",
+	"RequestLimitExceeded",
+	"RequestTimeout",
+	"ResponseTimeout",
+	"SerializationError",
+	"ServiceUnavailable",
+	"SlowDown",
+	"Throttling",
+	"ThrottlingException",
+	"TooManyRequestsException",
+	"500",
+	"502",
+	"503",
+	"504",
+}
+
+// maxThrottlePressure caps the shared backoff multiplier of the adaptive mode (2^6).
+const maxThrottlePressure = 6
+
+// retryBackoff is the exponential backoff of --retry-base-delay, --retry-max-delay
+// and --retry-jitter. A zero base keeps the backoff of the SDK.
+type retryBackoff struct {
+	base   time.Duration
+	max    time.Duration
+	jitter float64
+}
+
+// validate checks the backoff and defaults the maximum delay to the SDK maximum.
+func (b *retryBackoff) validate() error {
+	if b.base < 0 || b.max < 0 {
+		return errors.New("retry delays must not be negative")
+	}
+	if b.jitter < 0 || b.jitter > 1 {
+		return errors.New("retry jitter must be between 0 and 1")
+	}
+	if b.base == 0 {
+		if b.max > 0 || b.jitter > 0 {
+			return errors.New("--retry-max-delay and --retry-jitter need --retry-base-delay")
+		}
+		return nil
+	}
+	if b.max == 0 {
+		b.max = client.DefaultRetryerMaxRetryDelay
+	}
+	if b.max < b.base {
+		return fmt.Errorf("retry max delay %s must not be less than the base delay %s", b.max, b.base)
+	}
+	return nil
+}
+
+// delay returns the delay before the retry with the count, the base doubled on every
+// retry up to the maximum, reduced by up to the jitter fraction with the random in [0, 1).
+func (b retryBackoff) delay(retryCount int, random float64) time.Duration {
+	delay := b.base
+	for i := 0; i < retryCount && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	return delay - time.Duration(float64(delay)*b.jitter*random)
+}
+
+// retryer extends the SDK retryer used for every list, head and copy request.
+// In the adaptive mode throttling errors raise a pressure shared by all requests
+// that multiplies the throttle delay, and successful requests release it again,
+// so concurrent workers back off together instead of each on their own.
+//
+// Only the errors with one of the codes (or HTTP status codes) are retried,
+// and with a budget at most that many retries are made over the whole run.
+type retryer struct {
+	client.DefaultRetryer
+	backoff  retryBackoff
+	adaptive bool
+	pressure int32
+	codes    map[string]bool
+	limited  bool
+	budget   int64
+}
+
+// newRetryer returns the retryer for the mode that retries the error codes
+// within the budget of retries, which is unlimited when zero, with the backoff.
+func newRetryer(mode string, maxRetries, budget int, codes []string, backoff retryBackoff) (*retryer, error) {
+	if maxRetries < 0 {
+		return nil, fmt.Errorf("max retries must not be negative")
+	}
+	if budget < 0 {
+		return nil, fmt.Errorf("retry budget must not be negative")
+	}
+	if err := backoff.validate(); err != nil {
+		return nil, err
+	}
+	r := &retryer{
+		DefaultRetryer: client.DefaultRetryer{
+			NumMaxRetries:    maxRetries,
+			MinRetryDelay:    client.DefaultRetryerMinRetryDelay,
+			MinThrottleDelay: client.DefaultRetryerMinThrottleDelay,
+			MaxRetryDelay:    client.DefaultRetryerMaxRetryDelay,
+			MaxThrottleDelay: client.DefaultRetryerMaxThrottleDelay,
+		},
+		backoff: backoff,
+		codes:   make(map[string]bool),
+		limited: budget > 0,
+		budget:  int64(budget),
+	}
+	if backoff.base > 0 {
+		r.MaxThrottleDelay = backoff.max
+	}
+	if len(codes) == 0 {
+		codes = defaultRetryCodes
+	}
+	for _, list := range codes {
+		for _, code := range strings.Split(list, ",") {
+			if code = strings.TrimSpace(code); code != "" {
+				r.codes[code] = true
+			}
+		}
+	}
+	switch mode {
+	case retryModeStandard:
+	case retryModeAdaptive:
+		r.adaptive = true
+	default:
+		return nil, fmt.Errorf("unknown retry mode %q, use %s or %s", mode, retryModeStandard, retryModeAdaptive)
+	}
+	return r, nil
+}
+
+// ShouldRetry reports whether the failed request is retried, which happens only
+// for the configured error codes while the retry budget isn't spent.
+func (r *retryer) ShouldRetry(req *request.Request) bool {
+	if !r.retryable(req) {
+		return false
+	}
+	if !r.limited || req.RetryCount >= r.NumMaxRetries {
+		// Out of the retries of the request, the budget is left for other requests.
+		return true
+	}
+	return atomic.AddInt64(&r.budget, -1) >= 0
+}
+
+// retryable reports whether the error of the request has one of the codes.
+func (r *retryer) retryable(req *request.Request) bool {
+	if r.NumMaxRetries == 0 || req.Error == nil {
+		return false
+	}
+	if req.Retryable != nil && !*req.Retryable {
+		return false
+	}
+	var aerr awserr.Error
+	if errors.As(req.Error, &aerr) && r.codes[aerr.Code()] {
+		return true
+	}
+	return req.HTTPResponse != nil && r.codes[strconv.Itoa(req.HTTPResponse.StatusCode)]
+}
+
+// RetryRules returns the delay before the request is retried.
+func (r *retryer) RetryRules(req *request.Request) time.Duration {
+	var delay time.Duration
+	if r.backoff.base > 0 {
+		delay = r.backoff.delay(req.RetryCount, rand.Float64())
+	} else {
+		delay = r.DefaultRetryer.RetryRules(req)
+	}
+	if !r.adaptive || !req.IsErrorThrottle() {
+		return delay
+	}
+	pressure := atomic.AddInt32(&r.pressure, 1)
+	if pressure > maxThrottlePressure {
+		atomic.StoreInt32(&r.pressure, maxThrottlePressure)
+		pressure = maxThrottlePressure
+	}
+	delay *= 1 << uint(pressure)
+	if delay > r.MaxThrottleDelay {
+		delay = r.MaxThrottleDelay
+	}
+	return delay
+}
+
+// complete releases the throttle pressure after a successful request.
+// It is registered as a Complete handler of the session in the adaptive mode.
+func (r *retryer) complete(req *request.Request) {
+	if req.Error != nil {
+		return
+	}
+	for {
+		pressure := atomic.LoadInt32(&r.pressure)
+		if pressure == 0 || atomic.CompareAndSwapInt32(&r.pressure, pressure, pressure-1) {
+			return
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// failedRequest returns a request that failed with the error code and HTTP status.
+func failedRequest(code string, status int) *request.Request {
+	return &request.Request{
+		Error:        awserr.New(code, "failed", nil),
+		HTTPResponse: &http.Response{StatusCode: status},
+	}
+}
+
+func TestNewRetryerErrors(t *testing.T) {
+	tests := []struct {
+		mode       string
+		maxRetries int
+		budget     int
+		backoff    retryBackoff
+	}{
+		{"eager", 3, 0, retryBackoff{}},
+		{retryModeStandard, -1, 0, retryBackoff{}},
+		{retryModeStandard, 3, -1, retryBackoff{}},
+		{retryModeStandard, 3, 0, retryBackoff{base: time.Second, max: time.Millisecond}},
+	}
+	for _, test := range tests {
+		if _, err := newRetryer(test.mode, test.maxRetries, test.budget, nil, test.backoff); err == nil {
+			t.Errorf("newRetryer(%q, %d, %d, %+v) succeeded, want an error", test.mode, test.maxRetries, test.budget, test.backoff)
+		}
+	}
+}
+
+func TestAdaptiveRetryRules(t *testing.T) {
+	r, err := newRetryer(retryModeAdaptive, 3, 0, nil, retryBackoff{base: 100 * time.Millisecond, max: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	throttled := failedRequest("Throttling", http.StatusBadRequest)
+	for _, want := range []time.Duration{200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second} {
+		if got := r.RetryRules(throttled); got != want {
+			t.Errorf("RetryRules() = %s, want %s", got, want)
+		}
+	}
+	if got, want := r.RetryRules(failedRequest("InternalError", http.StatusInternalServerError)), 100*time.Millisecond; got != want {
+		t.Errorf("RetryRules() of a server error = %s, want %s without the pressure", got, want)
+	}
+
+	for i := 0; i < maxThrottlePressure; i++ {
+		r.complete(&request.Request{})
+	}
+	r.complete(&request.Request{})
+	if r.pressure != 0 {
+		t.Errorf("pressure = %d after the successful requests, want 0", r.pressure)
+	}
+	r.RetryRules(throttled)
+	r.complete(&request.Request{Error: awserr.New("Throttling", "failed", nil)})
+	if r.pressure != 1 {
+		t.Errorf("pressure = %d after a failed request, want 1", r.pressure)
+	}
+}
+
+func TestStandardRetryRules(t *testing.T) {
+	r, err := newRetryer(retryModeStandard, 3, 0, nil, retryBackoff{base: 100 * time.Millisecond, max: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	throttled := failedRequest("Throttling", http.StatusBadRequest)
+	for i := 0; i < 3; i++ {
+		if got, want := r.RetryRules(throttled), 100*time.Millisecond; got != want {
+			t.Errorf("RetryRules() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestShouldRetryCodes(t *testing.T) {
+	r, err := newRetryer(retryModeStandard, 3, 0, []string{"SlowDown, 503", "RequestTimeout"}, retryBackoff{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		req  *request.Request
+		want bool
+	}{
+		{failedRequest("SlowDown", http.StatusServiceUnavailable), true},
+		{failedRequest("RequestTimeout", http.StatusBadRequest), true},
+		{failedRequest("UnknownError", http.StatusServiceUnavailable), true},
+		{failedRequest("InternalError", http.StatusInternalServerError), false},
+		{failedRequest("AccessDenied", http.StatusForbidden), false},
+		{&request.Request{HTTPResponse: &http.Response{StatusCode: http.StatusOK}}, false},
+	}
+	for _, test := range tests {
+		if got := r.ShouldRetry(test.req); got != test.want {
+			t.Errorf("ShouldRetry(%v) = %v, want %v", test.req.Error, got, test.want)
+		}
+	}
+
+	notRetryable := failedRequest("SlowDown", http.StatusServiceUnavailable)
+	notRetryable.Retryable = aws.Bool(false)
+	if r.ShouldRetry(notRetryable) {
+		t.Error("ShouldRetry() of a request marked not retryable = true, want false")
+	}
+	r, err = newRetryer(retryModeStandard, 0, 0, nil, retryBackoff{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ShouldRetry(failedRequest("SlowDown", http.StatusServiceUnavailable)) {
+		t.Error("ShouldRetry() without retries = true, want false")
+	}
+}
+
+func TestDefaultRetryCodes(t *testing.T) {
+	r, err := newRetryer(retryModeStandard, 3, 0, nil, retryBackoff{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.ShouldRetry(failedRequest("InternalError", http.StatusInternalServerError)) {
+		t.Error("ShouldRetry() of InternalError with the default codes = false, want true")
+	}
+	if r.ShouldRetry(failedRequest("NoSuchKey", http.StatusNotFound)) {
+		t.Error("ShouldRetry() of NoSuchKey with the default codes = true, want false")
+	}
+}
+
+func TestShouldRetryBudget(t *testing.T) {
+	r, err := newRetryer(retryModeStandard, 3, 2, nil, retryBackoff{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, true, false, false} {
+		if got := r.ShouldRetry(failedRequest("SlowDown", 503)); got != want {
+			t.Errorf("ShouldRetry() #%d = %v, want %v", i+1, got, want)
+		}
+	}
+	if r.ShouldRetry(failedRequest("AccessDenied", 403)) {
+		t.Error("ShouldRetry(AccessDenied) = true, want false")
+	}
+
+	r, err = newRetryer(retryModeStandard, 3, 0, nil, retryBackoff{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if !r.ShouldRetry(failedRequest("SlowDown", 503)) {
+			t.Fatalf("ShouldRetry() #%d without a budget = false, want true", i+1)
+		}
+	}
+}
+
+func TestRetryBackoffValidate(t *testing.T) {
+	b := retryBackoff{base: time.Second}
+	if err := b.validate(); err != nil || b.max != client.DefaultRetryerMaxRetryDelay {
+		t.Errorf("validate() = %v, max %s, want the SDK maximum", err, b.max)
+	}
+	for _, b := range []retryBackoff{
+		{base: -time.Second},
+		{base: time.Second, max: -time.Second},
+		{base: time.Second, jitter: 1.5},
+		{base: time.Second, jitter: -0.1},
+		{max: time.Second},
+		{jitter: 0.5},
+		{base: time.Second, max: time.Millisecond},
+	} {
+		if err := b.validate(); err == nil {
+			t.Errorf("validate() of %+v succeeded, want an error", b)
+		}
+	}
+	if err := (&retryBackoff{}).validate(); err != nil {
+		t.Errorf("validate() of the SDK backoff: %v", err)
+	}
+}
+
+func TestRetryBackoffDelay(t *testing.T) {
+	b := retryBackoff{base: 100 * time.Millisecond, max: time.Second, jitter: 0.5}
+	tests := []struct {
+		retryCount int
+		random     float64
+		want       time.Duration
+	}{
+		{0, 0, 100 * time.Millisecond},
+		{1, 0, 200 * time.Millisecond},
+		{3, 0, 800 * time.Millisecond},
+		{4, 0, time.Second},
+		{20, 0, time.Second},
+		{0, 0.5, 75 * time.Millisecond},
+		{4, 1, 500 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := b.delay(test.retryCount, test.random); got != test.want {
+			t.Errorf("delay(%d, %v) = %s, want %s", test.retryCount, test.random, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// watchShutdown calls stop on the first signal, so no new copies are started,
+// and cancel when the started copies haven't finished within the timeout
+// or on the second signal.
+func watchShutdown(signals <-chan os.Signal, timeout time.Duration, stop, cancel func()) {
+	if _, ok := <-signals; !ok {
+		return
+	}
+	stop()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-signals:
+	}
+	cancel()
+}
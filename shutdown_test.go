@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runShutdown runs watchShutdown with the signals and returns whether stop
+// and cancel were called.
+func runShutdown(timeout time.Duration, signals ...os.Signal) (stopped, canceled bool) {
+	ch := make(chan os.Signal, len(signals))
+	for _, s := range signals {
+		ch <- s
+	}
+	if len(signals) < 2 {
+		close(ch)
+	}
+	watchShutdown(ch, timeout, func() { stopped = true }, func() { canceled = true })
+	return stopped, canceled
+}
+
+func TestWatchShutdown(t *testing.T) {
+	if stopped, canceled := runShutdown(time.Hour); stopped || canceled {
+		t.Errorf("watchShutdown() without a signal = %v, %v, want neither stop nor cancel", stopped, canceled)
+	}
+	if stopped, canceled := runShutdown(time.Hour, syscall.SIGINT, syscall.SIGTERM); !stopped || !canceled {
+		t.Errorf("watchShutdown() on the second signal = %v, %v, want stop and cancel", stopped, canceled)
+	}
+
+	ch := make(chan os.Signal, 1)
+	ch <- syscall.SIGINT
+	start := time.Now()
+	canceled := false
+	watchShutdown(ch, 50*time.Millisecond, func() {}, func() { canceled = true })
+	if !canceled || time.Since(start) < 50*time.Millisecond {
+		t.Error("watchShutdown() didn't cancel after the timeout")
+	}
+}
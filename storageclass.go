@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// preservedStorageClass returns the storage class of the copy of the listed
+// object for --preserve-storage-class and whether it is the listed class.
+// The archive classes GLACIER and DEEP_ARCHIVE, whose objects are restored
+// for the copy, and the classes CopyObject can't write get the fallback.
+// An empty class is STANDARD.
+func preservedStorageClass(listed *string, fallback string) (string, bool) {
+	class := aws.StringValue(listed)
+	switch class {
+	case "":
+		return s3.StorageClassStandard, true
+	case s3.StorageClassGlacier, s3.StorageClassDeepArchive:
+		return fallback, false
+	}
+	if validStorageClass(class) != nil {
+		return fallback, false
+	}
+	return class, true
+}
+
+// validStorageClass checks that CopyObject can write the storage class, e.g.
+// INTELLIGENT_TIERING. The archive access tiers of Intelligent-Tiering are
+// configured on the bucket and can't be chosen per object at copy time.
+func validStorageClass(class string) error {
+	for _, writable := range s3.StorageClass_Values() {
+		if class == writable {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown storage class %q, use one of %s", class, strings.Join(s3.StorageClass_Values(), ", "))
+}
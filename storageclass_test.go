@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestPreservedStorageClass(t *testing.T) {
+	tests := []struct {
+		listed *string
+		class  string
+		ok     bool
+	}{
+		{nil, "STANDARD", true},
+		{aws.String(""), "STANDARD", true},
+		{aws.String("STANDARD_IA"), "STANDARD_IA", true},
+		{aws.String("INTELLIGENT_TIERING"), "INTELLIGENT_TIERING", true},
+		{aws.String("GLACIER_IR"), "GLACIER_IR", true},
+		{aws.String("GLACIER"), "ONEZONE_IA", false},
+		{aws.String("DEEP_ARCHIVE"), "ONEZONE_IA", false},
+		{aws.String("EXPRESS_ONEZONE"), "ONEZONE_IA", false},
+	}
+	for _, test := range tests {
+		class, ok := preservedStorageClass(test.listed, "ONEZONE_IA")
+		if class != test.class || ok != test.ok {
+			t.Errorf("preservedStorageClass(%q) = %s, %v, want %s, %v", aws.StringValue(test.listed), class, ok, test.class, test.ok)
+		}
+	}
+}
+
+func TestValidStorageClass(t *testing.T) {
+	for _, class := range []string{"STANDARD", "INTELLIGENT_TIERING", "GLACIER", "DEEP_ARCHIVE"} {
+		if err := validStorageClass(class); err != nil {
+			t.Errorf("validStorageClass(%s): %v", class, err)
+		}
+	}
+	for _, class := range []string{"", "intelligent_tiering", "DEEP_ARCHIVE_ACCESS"} {
+		if err := validStorageClass(class); err == nil || !strings.Contains(err.Error(), "use one of STANDARD, ") {
+			t.Errorf("validStorageClass(%q) = %v, want the storage classes", class, err)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Failure categories reported in the summary.
+const (
+	categoryAccessDenied = "access-denied"
+	categoryNotFound     = "not-found"
+	categoryThrottled    = "throttled"
+	categoryTimeout      = "timeout"
+	categorySizeLimit    = "size-limit"
+	categoryOther        = "other"
+)
+
+// categories keeps the order in which failure categories are printed.
+var categories = []string{
+	categoryAccessDenied,
+	categoryNotFound,
+	categoryThrottled,
+	categoryTimeout,
+	categorySizeLimit,
+	categoryOther,
+}
+
+// errorCategories maps the S3 error codes to the failure categories.
+var errorCategories = map[string]string{
+	"AccessDenied":                 categoryAccessDenied,
+	"AccountProblem":               categoryAccessDenied,
+	"AllAccessDisabled":            categoryAccessDenied,
+	"ExpiredToken":                 categoryAccessDenied,
+	"Forbidden":                    categoryAccessDenied,
+	"InvalidAccessKeyId":           categoryAccessDenied,
+	"InvalidToken":                 categoryAccessDenied,
+	"SignatureDoesNotMatch":        categoryAccessDenied,
+	"NoSuchBucket":                 categoryNotFound,
+	"NoSuchKey":                    categoryNotFound,
+	"NoSuchUpload":                 categoryNotFound,
+	"NoSuchVersion":                categoryNotFound,
+	"NotFound":                     categoryNotFound,
+	"RequestLimitExceeded":         categoryThrottled,
+	"ServiceUnavailable":           categoryThrottled,
+	"SlowDown":                     categoryThrottled,
+	"Throttling":                   categoryThrottled,
+	"ThrottlingException":          categoryThrottled,
+	"TooManyRequestsException":     categoryThrottled,
+	"RequestTimeout":               categoryTimeout,
+	request.CanceledErrorCode:      categoryTimeout,
+	request.ErrCodeResponseTimeout: categoryTimeout,
+	"EntityTooLarge":               categorySizeLimit,
+	"EntityTooSmall":               categorySizeLimit,
+	"MaxMessageLengthExceeded":     categorySizeLimit,
+}
+
+// errorCategory returns the failure category of the error returned by the SDK.
+func errorCategory(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return categoryTimeout
+	}
+	if errors.Is(err, errOversized) {
+		return categorySizeLimit
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return categoryOther
+	}
+	if category, ok := errorCategories[aerr.Code()]; ok {
+		return category
+	}
+	// Copy sources over 5 GB are rejected with a generic InvalidRequest.
+	if aerr.Code() == "InvalidRequest" && strings.Contains(aerr.Message(), "maximum allowable size") {
+		return categorySizeLimit
+	}
+	var rerr awserr.RequestFailure
+	if errors.As(err, &rerr) {
+		switch rerr.StatusCode() {
+		case 403:
+			return categoryAccessDenied
+		case 404:
+			return categoryNotFound
+		case 429, 503:
+			return categoryThrottled
+		}
+	}
+	if errors.Is(aerr.OrigErr(), context.DeadlineExceeded) {
+		return categoryTimeout
+	}
+	return categoryOther
+}
+
+// describeError formats the error on a single line with the request ID and the
+// S3 host ID (x-amz-request-id and x-amz-id-2) that AWS support asks for.
+func describeError(err error) string {
+	var rerr awserr.RequestFailure
+	if !errors.As(err, &rerr) {
+		return err.Error()
+	}
+	ids := fmt.Sprintf("status code: %d, request id: %s", rerr.StatusCode(), rerr.RequestID())
+	var serr s3.RequestFailure
+	if errors.As(err, &serr) && serr.HostID() != "" {
+		ids += ", host id: " + serr.HostID()
+	}
+	msg := rerr.Code()
+	if rerr.Message() != "" {
+		msg += ": " + rerr.Message()
+	}
+	if rerr.OrigErr() != nil {
+		msg += ": " + rerr.OrigErr().Error()
+	}
+	// Keep the context of the wrapping errors.
+	prefix := strings.TrimSuffix(err.Error(), rerr.Error())
+	return fmt.Sprintf("%s%s (%s)", prefix, msg, ids)
+}
+
+// summary collects the results of the copy run.
+type summary struct {
+	// name tells the summaries apart when there is more than one, e.g. of the replica copies.
+	name    string
+	copied  int64
+	skipped int64
+	failed  int64
+	bytes   int64
+	unsized int64
+
+	mu       sync.Mutex
+	failures map[string]int64
+	objects  []failedObject
+}
+
+// failedObject is a source object that failed to copy, kept for --summary-json.
+type failedObject struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	VersionID string `json:"versionId,omitempty"`
+}
+
+// failureRecord is a line of --failures-file, written as soon as an object fails
+// after the retries of the SDK, so another job can retry it before the run ends.
+type failureRecord struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	VersionID string    `json:"versionId,omitempty"`
+	Code      string    `json:"code,omitempty"`
+	Category  string    `json:"category"`
+	Error     string    `json:"error"`
+	Time      time.Time `json:"time"`
+}
+
+// newFailureRecord returns the record of the object that failed with the error,
+// with the S3 error code when there is one.
+func newFailureRecord(bucket, key, versionID string, err error) failureRecord {
+	record := failureRecord{
+		Bucket:    bucket,
+		Key:       key,
+		VersionID: versionID,
+		Category:  errorCategory(err),
+		Error:     describeError(err),
+		Time:      time.Now().UTC(),
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		record.Code = aerr.Code()
+	}
+	return record
+}
+
+// summaryFile is the JSON summary of --summary-json, read back by --retry-failed.
+type summaryFile struct {
+	Copied   int64            `json:"copied"`
+	Bytes    int64            `json:"bytes"`
+	Unsized  int64            `json:"unsized"`
+	Skipped  int64            `json:"skipped"`
+	Failed   int64            `json:"failed"`
+	Failures map[string]int64 `json:"failures"`
+	Objects  []failedObject   `json:"failedObjects"`
+}
+
+// success records a successfully copied object of the size,
+// which is nil when the size is unknown.
+func (s *summary) success(size *int64) {
+	atomic.AddInt64(&s.copied, 1)
+	if size == nil {
+		atomic.AddInt64(&s.unsized, 1)
+		return
+	}
+	atomic.AddInt64(&s.bytes, *size)
+}
+
+// skip records an object that was not copied on purpose.
+func (s *summary) skip() {
+	atomic.AddInt64(&s.skipped, 1)
+}
+
+// failure records a failed object under the category of its error.
+func (s *summary) failure(err error) {
+	atomic.AddInt64(&s.failed, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures == nil {
+		s.failures = make(map[string]int64)
+	}
+	s.failures[errorCategory(err)]++
+}
+
+// failedKey records the source object that failed to copy.
+func (s *summary) failedKey(bucket, key, versionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects = append(s.objects, failedObject{Bucket: bucket, Key: key, VersionID: versionID})
+}
+
+// writeFile writes the JSON summary with the failed objects to the file.
+func (s *summary) writeFile(filename string) error {
+	s.mu.Lock()
+	file := summaryFile{
+		Copied:   atomic.LoadInt64(&s.copied),
+		Bytes:    atomic.LoadInt64(&s.bytes),
+		Unsized:  atomic.LoadInt64(&s.unsized),
+		Skipped:  atomic.LoadInt64(&s.skipped),
+		Failed:   atomic.LoadInt64(&s.failed),
+		Failures: make(map[string]int64, len(s.failures)),
+		Objects:  append([]failedObject{}, s.objects...),
+	}
+	for category, n := range s.failures {
+		file.Failures[category] = n
+	}
+	s.mu.Unlock()
+	sort.Slice(file.Objects, func(i, j int) bool {
+		a, b := file.Objects[i], file.Objects[j]
+		if a.Bucket != b.Bucket {
+			return a.Bucket < b.Bucket
+		}
+		return a.Key < b.Key
+	})
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// readFailedObjects reads the failed objects of the JSON summary of a previous run.
+func readFailedObjects(r io.Reader) ([]failedObject, error) {
+	var file summaryFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid summary: %v", err)
+	}
+	for i, o := range file.Objects {
+		if o.Bucket == "" || o.Key == "" {
+			return nil, fmt.Errorf("failed object %d: bucket and key are required", i+1)
+		}
+	}
+	return file.Objects, nil
+}
+
+// print writes the summary with the failure counts per category.
+func (s *summary) print(l *log.Logger) {
+	copied := formatBytes(atomic.LoadInt64(&s.bytes))
+	if unsized := atomic.LoadInt64(&s.unsized); unsized > 0 {
+		copied += fmt.Sprintf(", %d of unknown size", unsized)
+	}
+	title := "Summary"
+	if s.name != "" {
+		title = "Summary of the " + s.name + " copies"
+	}
+	l.Printf("%s: %d copied (%s), %d skipped, %d failed\n", title,
+		atomic.LoadInt64(&s.copied), copied, atomic.LoadInt64(&s.skipped), atomic.LoadInt64(&s.failed))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, category := range categories {
+		if n := s.failures[category]; n > 0 {
+			l.Printf("  %s: %d\n", category, n)
+		}
+	}
+}
+
+// tally counts the listed objects of the --count-only mode.
+type tally struct {
+	count      int64
+	bytes      int64
+	classes    map[string]int64
+	classBytes map[string]int64
+}
+
+// add counts the object under its storage class.
+func (t *tally) add(o *s3.Object) {
+	if t.classes == nil {
+		t.classes = make(map[string]int64)
+		t.classBytes = make(map[string]int64)
+	}
+	class := aws.StringValue(o.StorageClass)
+	if class == "" {
+		class = s3.StorageClassStandard
+	}
+	t.count++
+	t.bytes += aws.Int64Value(o.Size)
+	t.classes[class]++
+	t.classBytes[class] += aws.Int64Value(o.Size)
+}
+
+// print writes the counts as a single line with the storage classes sorted by name.
+func (t *tally) print(l *log.Logger) {
+	classes := make([]string, 0, len(t.classes))
+	for class := range t.classes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	line := fmt.Sprintf("Count: %d objects (%s)", t.count, formatBytes(t.bytes))
+	for _, class := range classes {
+		line += fmt.Sprintf(", %s: %d", class, t.classes[class])
+	}
+	l.Println(line)
+}
+
+// write writes a row per storage class followed by the TOTAL row.
+func (t *tally) write(o *output) error {
+	classes := make([]string, 0, len(t.classes))
+	for class := range t.classes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	fields := []string{"storage_class", "objects", "bytes"}
+	for _, class := range classes {
+		if err := o.write(fields, class, t.classes[class], t.classBytes[class]); err != nil {
+			return err
+		}
+	}
+	return o.write(fields, "TOTAL", t.count, t.bytes)
+}
+
+// write writes the summary as a single row with a column per failure category,
+// led by the name of the summary when it has one.
+func (s *summary) write(o *output) error {
+	fields := []string{"copied", "bytes", "unsized", "skipped", "failed"}
+	values := []interface{}{
+		atomic.LoadInt64(&s.copied), atomic.LoadInt64(&s.bytes), atomic.LoadInt64(&s.unsized),
+		atomic.LoadInt64(&s.skipped), atomic.LoadInt64(&s.failed),
+	}
+	if s.name != "" {
+		fields = append([]string{"copies"}, fields...)
+		values = append([]interface{}{s.name}, values...)
+	}
+	s.mu.Lock()
+	for _, category := range categories {
+		fields = append(fields, category)
+		values = append(values, s.failures[category])
+	}
+	s.mu.Unlock()
+	return o.write(fields, values...)
+}
+
+// formatBytes formats the byte count with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{awserr.New("AccessDenied", "denied", nil), categoryAccessDenied},
+		{awserr.New("NoSuchKey", "missing", nil), categoryNotFound},
+		{awserr.New("SlowDown", "slow down", nil), categoryThrottled},
+		{awserr.New(request.CanceledErrorCode, "canceled", nil), categoryTimeout},
+		{awserr.New("EntityTooLarge", "too large", nil), categorySizeLimit},
+		{awserr.New("InvalidRequest", "The specified copy source is larger than the maximum allowable size for a copy source: 5368709120", nil), categorySizeLimit},
+		{awserr.New("InvalidRequest", "bad request", nil), categoryOther},
+		{awserr.NewRequestFailure(awserr.New("Forbidden", "", nil), 403, "id"), categoryAccessDenied},
+		{awserr.NewRequestFailure(awserr.New("UnknownError", "", nil), 403, "id"), categoryAccessDenied},
+		{awserr.NewRequestFailure(awserr.New("UnknownError", "", nil), 404, "id"), categoryNotFound},
+		{awserr.NewRequestFailure(awserr.New("UnknownError", "", nil), 503, "id"), categoryThrottled},
+		{awserr.NewRequestFailure(awserr.New("UnknownError", "", nil), 500, "id"), categoryOther},
+		{awserr.New(request.ErrCodeRequestError, "send request failed", context.DeadlineExceeded), categoryTimeout},
+		{fmt.Errorf("copy: %w", context.DeadlineExceeded), categoryTimeout},
+		{errOversized, categorySizeLimit},
+		{fmt.Errorf("item a: %w", errOversized), categorySizeLimit},
+		{errors.New("boom"), categoryOther},
+	}
+	for _, test := range tests {
+		if got := errorCategory(test.err); got != test.want {
+			t.Errorf("errorCategory(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+}
+
+func TestSummaryFailures(t *testing.T) {
+	var s summary
+	size := int64(2048)
+	s.success(&size)
+	s.success(nil)
+	s.failure(awserr.New("AccessDenied", "denied", nil))
+	s.failure(awserr.New("AccessDenied", "denied", nil))
+	s.failure(errors.New("boom"))
+
+	var buf bytes.Buffer
+	s.print(log.New(&buf, "", 0))
+	want := "Summary: 2 copied (2.0 KiB, 1 of unknown size), 0 skipped, 3 failed\n  access-denied: 2\n  other: 1\n"
+	if buf.String() != want {
+		t.Errorf("print() = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	out, err := newOutput(formatCSV, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.write(out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("write() = %q, want a header and a row", buf.String())
+	}
+	if want := "copied,bytes,unsized,skipped,failed," + strings.Join(categories, ","); lines[0] != want {
+		t.Errorf("header = %q, want %q", lines[0], want)
+	}
+	if want := "2,2048,1,0,3,2,0,0,0,0,1"; lines[1] != want {
+		t.Errorf("row = %q, want %q", lines[1], want)
+	}
+}
+
+func TestSummaryBytesConcurrent(t *testing.T) {
+	var s summary
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%10 == 0 {
+				s.success(nil)
+				return
+			}
+			size := int64(1024)
+			s.success(&size)
+		}(i)
+	}
+	wg.Wait()
+	if s.copied != 100 || s.bytes != 90*1024 || s.unsized != 10 {
+		t.Errorf("copied %d, bytes %d, unsized %d, want 100, %d, 10", s.copied, s.bytes, s.unsized, 90*1024)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+		{3 << 40, "3.0 TiB"},
+	}
+	for _, test := range tests {
+		if got := formatBytes(test.n); got != test.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", test.n, got, test.want)
+		}
+	}
+}
+
+func TestTally(t *testing.T) {
+	var counts tally
+	counts.add(&s3.Object{Size: aws.Int64(1024)})
+	counts.add(&s3.Object{Size: aws.Int64(2048), StorageClass: aws.String(s3.StorageClassGlacier)})
+	counts.add(&s3.Object{Size: aws.Int64(1024), StorageClass: aws.String(s3.StorageClassStandard)})
+
+	var buf bytes.Buffer
+	counts.print(log.New(&buf, "", 0))
+	if want := "Count: 3 objects (4.0 KiB), GLACIER: 1, STANDARD: 2\n"; buf.String() != want {
+		t.Errorf("print() = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	out, err := newOutput(formatCSV, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := counts.write(out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "storage_class,objects,bytes\nGLACIER,1,2048\nSTANDARD,2,2048\nTOTAL,3,4096\n"; buf.String() != want {
+		t.Errorf("write() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestDescribeError(t *testing.T) {
+	inner := awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "REQ123")
+	if got, want := describeError(inner), "AccessDenied: Access Denied (status code: 403, request id: REQ123)"; got != want {
+		t.Errorf("describeError() = %q, want %q", got, want)
+	}
+	wrapped := fmt.Errorf("copy failed: %w", inner)
+	if got, want := describeError(wrapped), "copy failed: AccessDenied: Access Denied (status code: 403, request id: REQ123)"; got != want {
+		t.Errorf("describeError() = %q, want %q", got, want)
+	}
+	if got := describeError(errors.New("boom")); got != "boom" {
+		t.Errorf("describeError() = %q, want boom", got)
+	}
+
+	fake, svc := newFakeS3(t)
+	fake.put("bucket1", "key", 1)
+	_, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String("bucket1"), Key: aws.String("missing")})
+	if got, want := describeError(err), "NoSuchKey: NoSuchKey (status code: 404, request id: REQ123, host id: HOST456)"; got != want {
+		t.Errorf("describeError() = %q, want %q", got, want)
+	}
+}
+
+func TestSummaryFileRoundTrip(t *testing.T) {
+	s := &summary{}
+	s.success(aws.Int64(10))
+	s.failure(awserr.New("AccessDenied", "denied", nil))
+	s.failedKey("src", "b", "")
+	s.failedKey("src", "a", "v1")
+	s.failedKey("other", "c", "")
+	filename := filepath.Join(t.TempDir(), "summary.json")
+	if err := s.writeFile(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file summaryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Copied != 1 || file.Bytes != 10 || file.Failed != 1 || file.Failures[categoryAccessDenied] != 1 {
+		t.Errorf("summary = %+v", file)
+	}
+	objects, err := readFailedObjects(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []failedObject{{Bucket: "other", Key: "c"}, {Bucket: "src", Key: "a", VersionID: "v1"}, {Bucket: "src", Key: "b"}}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("readFailedObjects() = %+v, want %+v", objects, want)
+	}
+}
+
+func TestReadFailedObjectsErrors(t *testing.T) {
+	tests := map[string]string{
+		"[]": "invalid summary",
+		`{"failedObjects":[{"bucket":"src","key":"a"},{"bucket":"src"}]}`: "failed object 2: bucket and key are required",
+		`{"failedObjects":[{"key":"a"}]}`:                                 "failed object 1: bucket and key are required",
+	}
+	for input, want := range tests {
+		if _, err := readFailedObjects(strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("readFailedObjects(%s) = %v, want %q", input, err, want)
+		}
+	}
+	if objects, err := readFailedObjects(strings.NewReader(`{"copied":3}`)); len(objects) != 0 || err != nil {
+		t.Errorf("readFailedObjects() of a summary without failures = %v, %v", objects, err)
+	}
+}
+
+func TestFailureRecord(t *testing.T) {
+	var buf bytes.Buffer
+	w := newJSONLinesWriter(&buf)
+	deny := awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "REQ123")
+	for _, record := range []failureRecord{
+		newFailureRecord("bucket1", "a", "v1", fmt.Errorf("copy failed: %w", deny)),
+		newFailureRecord("bucket1", "b", "", errOversized),
+	} {
+		if err := w.write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("failures = %q, want 2 lines", lines)
+	}
+	var got []failureRecord
+	for _, line := range lines {
+		var record failureRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Time.IsZero() {
+			t.Errorf("failure of %s has no time", record.Key)
+		}
+		record.Time = time.Time{}
+		got = append(got, record)
+	}
+	want := []failureRecord{
+		{Bucket: "bucket1", Key: "a", VersionID: "v1", Code: "AccessDenied", Category: categoryAccessDenied,
+			Error: "copy failed: AccessDenied: Access Denied (status code: 403, request id: REQ123)"},
+		{Bucket: "bucket1", Key: "b", Category: categorySizeLimit, Error: errOversized.Error()},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("failures = %+v, want %+v", got, want)
+	}
+	if strings.Contains(lines[1], "versionId") || strings.Contains(lines[1], "code") {
+		t.Errorf("failure without a version and code = %s, want them omitted", lines[1])
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// tagTemplateData are the variables available in the --add-tag values.
+type tagTemplateData struct {
+	RunID string // --run-id or the generated ID of the run
+	Now   string // start time of the run in RFC 3339
+}
+
+// newRunID generates an ID of the run from its start time and a random suffix.
+func newRunID(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// renderTags parses the KEY=VALUE tags and renders the templates of the values.
+func renderTags(pairs []string, data tagTemplateData) (map[string]string, error) {
+	tags, err := parseKeyValues(pairs)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range tags {
+		t, err := template.New(k).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		if err := t.Execute(&b, data); err != nil {
+			return nil, err
+		}
+		tags[k] = b.String()
+	}
+	return tags, nil
+}
+
+// mergeTags returns the source tags overridden by the added tags.
+func mergeTags(source []*s3.Tag, tags map[string]string) map[string]string {
+	merged := make(map[string]string, len(source)+len(tags))
+	for _, tag := range source {
+		merged[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+// overrideTags returns the tags overridden by the tags of the object.
+func overrideTags(tags, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(tags)+len(overrides))
+	for k, v := range tags {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+// encodeTags encodes the tags as the URL query of the x-amz-tagging header.
+// Spaces are encoded as %20, since S3 doesn't decode + in tags.
+func encodeTags(tags map[string]string) string {
+	values := make(url.Values, len(tags))
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+// tagSet returns the tags sorted by key for a PutObjectTagging request.
+func tagSet(tags map[string]string) []*s3.Tag {
+	set := make([]*s3.Tag, 0, len(tags))
+	for k, v := range tags {
+		set = append(set, &s3.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	sort.Slice(set, func(i, j int) bool { return aws.StringValue(set[i].Key) < aws.StringValue(set[j].Key) })
+	return set
+}
+
+// taggingUnsupported reports whether the copy failed because the backend
+// doesn't support the x-amz-tagging header of CopyObject.
+func taggingUnsupported(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	switch aerr.Code() {
+	case "NotImplemented":
+		return true
+	case "InvalidArgument", "InvalidRequest":
+		return strings.Contains(strings.ToLower(aerr.Message()), "tagging")
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestNewRunID(t *testing.T) {
+	id := newRunID(time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC))
+	if !regexp.MustCompile(`^20220304T050607Z-[0-9a-f]{8}$`).MatchString(id) {
+		t.Errorf("newRunID() = %q, want the start time with a random suffix", id)
+	}
+}
+
+func TestRenderTags(t *testing.T) {
+	tags, err := renderTags([]string{"run={{.RunID}}", "copied={{.Now}}", "team=data"}, tagTemplateData{RunID: "r1", Now: "2022-03-04T05:06:07Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"run": "r1", "copied": "2022-03-04T05:06:07Z", "team": "data"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("renderTags() = %v, want %v", tags, want)
+	}
+	for _, pair := range []string{"run={{.Missing}}", "run={{.RunID", "novalue"} {
+		if _, err := renderTags([]string{pair}, tagTemplateData{}); err == nil {
+			t.Errorf("renderTags(%q) succeeded, want an error", pair)
+		}
+	}
+}
+
+func TestMergeAndEncodeTags(t *testing.T) {
+	source := []*s3.Tag{{Key: aws.String("team"), Value: aws.String("ops")}, {Key: aws.String("env"), Value: aws.String("prod")}}
+	merged := mergeTags(source, map[string]string{"team": "data", "note": "a b+c"})
+	want := map[string]string{"team": "data", "env": "prod", "note": "a b+c"}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("mergeTags() = %v, want %v", merged, want)
+	}
+	if got, want := encodeTags(merged), "env=prod&note=a%20b%2Bc&team=data"; got != want {
+		t.Errorf("encodeTags() = %q, want %q", got, want)
+	}
+}
+
+func TestOverrideTags(t *testing.T) {
+	tags := map[string]string{"team": "data", "env": "prod"}
+	got := overrideTags(tags, map[string]string{"env": "dev", "job": "1"})
+	want := map[string]string{"team": "data", "env": "dev", "job": "1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("overrideTags() = %v, want %v", got, want)
+	}
+	if tags["env"] != "prod" {
+		t.Error("overrideTags() changed the source tags")
+	}
+}
+
+func TestTagSet(t *testing.T) {
+	set := tagSet(map[string]string{"team": "data", "env": "prod", "job": "1"})
+	var got []string
+	for _, tag := range set {
+		got = append(got, aws.StringValue(tag.Key)+"="+aws.StringValue(tag.Value))
+	}
+	if want := []string{"env=prod", "job=1", "team=data"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tagSet() = %q, want %q", got, want)
+	}
+}
+
+func TestTaggingUnsupported(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.New("NotImplemented", "A header you provided implies functionality that is not implemented", nil), true},
+		{awserr.New("InvalidArgument", "The x-amz-tagging header is not supported", nil), true},
+		{awserr.New("InvalidRequest", "Tagging is not supported", nil), true},
+		{awserr.New("InvalidArgument", "Invalid storage class", nil), false},
+		{awserr.New("AccessDenied", "tagging denied", nil), false},
+		{errors.New("NotImplemented"), false},
+	}
+	for _, test := range tests {
+		if got := taggingUnsupported(test.err); got != test.want {
+			t.Errorf("taggingUnsupported(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
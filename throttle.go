@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Settings of the --auto-throttle controller.
+const (
+	// throttleWindow is the period over which the error rate is observed.
+	throttleWindow = 10 * time.Second
+	// throttleErrorRate is the share of requests failed with a 5xx or throttling
+	// error above which the rate is halved.
+	throttleErrorRate = 0.05
+	// throttleMinFraction is the lowest rate as a fraction of --max-rate.
+	throttleMinFraction = 1.0 / 64
+	// throttleIncrease is the fraction of --max-rate added back after a window
+	// under the error rate.
+	throttleIncrease = 0.1
+)
+
+// requestThrottle limits the requests of all clients of the session to a global
+// rate per second. With the auto throttle it observes the 5xx and throttling
+// errors and halves the rate when their share of a window climbs over the
+// error rate, then raises it again in steps up to the maximum rate while the
+// requests succeed, like the congestion control of TCP.
+type requestThrottle struct {
+	limiter *rateLimiter
+	max     float64
+	rate    float64
+
+	requests int64
+	errors   int64
+}
+
+// newRequestThrottle returns the throttle of the maximum rate per second.
+func newRequestThrottle(max float64) *requestThrottle {
+	return &requestThrottle{limiter: newRateLimiter(max), max: max, rate: max}
+}
+
+// wait delays the request until it's allowed by the rate.
+// It is registered as a Send handler of the session, so the retries wait too.
+func (t *requestThrottle) wait(req *request.Request) {
+	if err := t.limiter.wait(req.Context()); err != nil {
+		req.Error = err
+	}
+}
+
+// record counts the completed request and whether it failed with a 5xx or throttling error.
+// It is registered as a Complete handler of the session with the auto throttle.
+func (t *requestThrottle) record(req *request.Request) {
+	atomic.AddInt64(&t.requests, 1)
+	if req.Error != nil && (req.IsErrorThrottle() || (req.HTTPResponse != nil && req.HTTPResponse.StatusCode >= 500)) {
+		atomic.AddInt64(&t.errors, 1)
+	}
+}
+
+// adjust returns the rate after a window of the requests with the errors,
+// halved down to the minimum above the error rate, otherwise raised up to the maximum.
+func (t *requestThrottle) adjust(requests, errors int64) float64 {
+	if requests == 0 {
+		return t.rate
+	}
+	if float64(errors)/float64(requests) > throttleErrorRate {
+		t.rate /= 2
+		if min := t.max * throttleMinFraction; t.rate < min {
+			t.rate = min
+		}
+	} else if t.rate < t.max {
+		t.rate += t.max * throttleIncrease
+		if t.rate > t.max {
+			t.rate = t.max
+		}
+	}
+	return t.rate
+}
+
+// run adjusts the rate of the limiter after every window until the process exits
+// and reports the changed rates with the error rate of the window.
+func (t *requestThrottle) run(window time.Duration, report func(rate, errorRate float64)) {
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	for range ticker.C {
+		requests, errors := atomic.SwapInt64(&t.requests, 0), atomic.SwapInt64(&t.errors, 0)
+		previous := t.rate
+		if rate := t.adjust(requests, errors); rate != previous {
+			t.limiter.setRate(rate)
+			report(rate, float64(errors)/float64(requests))
+		}
+	}
+}
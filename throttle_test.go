@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func TestRequestThrottleAdjust(t *testing.T) {
+	th := newRequestThrottle(64)
+	windows := []struct {
+		requests, errors int64
+		want             float64
+	}{
+		{0, 0, 64},
+		{100, 5, 64},
+		{100, 6, 32},
+		{100, 50, 16},
+		{10, 10, 8},
+		{10, 10, 4},
+		{10, 10, 2},
+		{10, 10, 1},
+		{10, 10, 1},
+		{100, 0, 7.4},
+		{100, 0, 13.8},
+	}
+	for i, w := range windows {
+		if got := th.adjust(w.requests, w.errors); got < w.want-1e-9 || got > w.want+1e-9 {
+			t.Errorf("adjust(%d, %d) #%d = %v, want %v", w.requests, w.errors, i+1, got, w.want)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		th.adjust(100, 0)
+	}
+	if th.rate != 64 {
+		t.Errorf("rate = %v after the successful windows, want the maximum 64", th.rate)
+	}
+}
+
+func TestRequestThrottleRecord(t *testing.T) {
+	th := newRequestThrottle(10)
+	th.record(&request.Request{})
+	th.record(failedRequest("SlowDown", http.StatusServiceUnavailable))
+	th.record(failedRequest("InternalError", http.StatusInternalServerError))
+	th.record(failedRequest("AccessDenied", http.StatusForbidden))
+	if th.requests != 4 || th.errors != 2 {
+		t.Errorf("record() counted %d requests and %d errors, want 4 and 2", th.requests, th.errors)
+	}
+}
+
+func TestRequestThrottleRun(t *testing.T) {
+	th := newRequestThrottle(10)
+	for i := 0; i < 10; i++ {
+		th.record(failedRequest("SlowDown", http.StatusServiceUnavailable))
+	}
+	reports := make(chan [2]float64, 1)
+	go th.run(10*time.Millisecond, func(rate, errorRate float64) {
+		select {
+		case reports <- [2]float64{rate, errorRate}:
+		default:
+		}
+	})
+	select {
+	case got := <-reports:
+		if got != [2]float64{5, 1} {
+			t.Errorf("report = %v, want the rate 5 at the error rate 1", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run() didn't report the changed rate")
+	}
+	th.limiter.mu.Lock()
+	interval := th.limiter.interval
+	th.limiter.mu.Unlock()
+	if interval != 200*time.Millisecond {
+		t.Errorf("limiter interval = %s, want 200ms of the rate 5", interval)
+	}
+}
+
+func TestRequestThrottleWait(t *testing.T) {
+	th := newRequestThrottle(0.001)
+	req := &request.Request{HTTPRequest: &http.Request{}}
+	req.SetContext(context.Background())
+	th.wait(req)
+	if req.Error != nil {
+		t.Fatalf("wait() of the first request: %v", req.Error)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req = &request.Request{HTTPRequest: &http.Request{}}
+	req.SetContext(ctx)
+	th.wait(req)
+	if req.Error != context.Canceled {
+		t.Errorf("wait() of a canceled request = %v, want %v", req.Error, context.Canceled)
+	}
+}
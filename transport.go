@@ -0,0 +1,28 @@
+package main
+
+import "net/http"
+
+// connectionLimits returns the idle and per-host connection limits of the
+// HTTP transport. Unset limits follow --concurrency: every worker keeps its
+// connection idle between the copies, and as many again are allowed for the
+// listings, HEAD and tagging requests running alongside the copies.
+func connectionLimits(maxIdle, maxPerHost, concurrency int) (int, int) {
+	if maxIdle == 0 {
+		maxIdle = 2 * concurrency
+	}
+	if maxPerHost == 0 {
+		maxPerHost = 2 * concurrency
+	}
+	return maxIdle, maxPerHost
+}
+
+// newTransport returns a copy of the default HTTP transport with the
+// connection pool sized to the limits. All requests go to the S3 endpoint,
+// so the idle connections of a single host are limited only by maxIdle.
+func newTransport(maxIdle, maxPerHost int) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdle
+	transport.MaxIdleConnsPerHost = maxIdle
+	transport.MaxConnsPerHost = maxPerHost
+	return transport
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestConnectionLimits(t *testing.T) {
+	tests := []struct {
+		maxIdle, maxPerHost, concurrency int
+		wantIdle, wantPerHost            int
+	}{
+		{0, 0, 10, 20, 20},
+		{5, 0, 10, 5, 20},
+		{0, 7, 10, 20, 7},
+		{50, 100, 10, 50, 100},
+	}
+	for _, test := range tests {
+		idle, perHost := connectionLimits(test.maxIdle, test.maxPerHost, test.concurrency)
+		if idle != test.wantIdle || perHost != test.wantPerHost {
+			t.Errorf("connectionLimits(%d, %d, %d) = %d, %d, want %d, %d",
+				test.maxIdle, test.maxPerHost, test.concurrency, idle, perHost, test.wantIdle, test.wantPerHost)
+		}
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	transport := newTransport(20, 30)
+	if transport.MaxIdleConns != 20 || transport.MaxIdleConnsPerHost != 20 || transport.MaxConnsPerHost != 30 {
+		t.Errorf("newTransport(20, 30) = %d idle, %d idle per host, %d per host",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+	if transport == http.DefaultTransport || http.DefaultTransport.(*http.Transport).MaxConnsPerHost == 30 {
+		t.Error("newTransport() changed the default transport")
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Modes of the --skip-existing-mode flag.
+const (
+	skipExistingHead = "head"
+	skipExistingList = "list"
+)
+
+// Modes of the --wait-mode flag.
+const (
+	waitModeWaiter = "waiter"
+	waitModeHead   = "head"
+)
+
+// validateArgs checks the combinations of the arguments before any API call
+// and returns a usage error describing how to fix them.
+func validateArgs(source, target *url.URL) error {
+	if args.Pairs == "" && source.Host == "" {
+		return errors.New("source must include a bucket name, e.g. s3://bucket/key")
+	}
+	if args.Pairs == "" && target.Host == "" {
+		return errors.New("destination must include a bucket name, e.g. s3://bucket/prefix/")
+	}
+	if args.CreateDestBucket && accessPoint(target.Host) {
+		return errors.New("--create-dest-bucket can't create the bucket of an access point")
+	}
+	if err := validStorageClass(args.StorageClass); err != nil {
+		return fmt.Errorf("--storage-class: %v", err)
+	}
+	if args.EncodingType != "" && args.EncodingType != "url" {
+		return errors.New("--encoding-type must be url")
+	}
+	if args.RequestPayer != "" && args.RequestPayer != "requester" {
+		return errors.New("--request-payer must be requester")
+	}
+	if args.RequestPayer != "" && !args.AcceptRequesterPays {
+		return errors.New("--request-payer requester charges the requests and transfers of the Requester Pays buckets " +
+			"to your account, add --accept-requester-pays to proceed")
+	}
+	bulk := bulkMode()
+	modes := 0
+	for _, set := range []bool{args.Recursive, args.InventoryManifest != "", args.Jobs != "", args.Manifest != "", args.Pairs != "", args.RetryFailed != ""} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return errors.New("only one of --recursive, --inventory-manifest, --jobs, --manifest, --pairs and --retry-failed can be used")
+	}
+	if args.AllVersions && !args.Recursive {
+		return errors.New("--all-versions lists the versions of --recursive")
+	}
+	if args.AllVersions && (args.SkipExisting || args.CopyIfNewer || args.OverwriteOnlyIfOlderDest || args.DeleteSource ||
+		args.DryRunDiff || args.CountOnly || args.ParallelListing || args.ListCache != "") {
+		return errors.New("--all-versions copies the history of every key and can't be used with --skip-existing, " +
+			"--copy-if-newer, --overwrite-only-if-older-dest, --delete-source, --dry-run-diff, --count-only, --parallel-listing or --list-cache")
+	}
+	if args.ObjectCountEstimate && (args.ProgressFile == "" || !args.Recursive) {
+		return errors.New("--object-count-estimate estimates the total of --progress-file for --recursive")
+	}
+	switch args.Sort {
+	case sortNone, sortLexical, sortSize, sortModified:
+	default:
+		return errors.New("--sort must be none, lexical, size or modified")
+	}
+	if args.Sort != sortNone && !bulk {
+		return errors.New("--sort needs --recursive, --inventory-manifest, --jobs, --manifest, --pairs or --retry-failed")
+	}
+	if args.PageAtATime && (!args.Recursive || args.ParallelListing || args.AllVersions || args.UseListCache || args.ExpectCount > 0 || args.Sort != sortNone ||
+		args.OnConflict == conflictSkip || args.OnConflict == conflictHash) {
+		return errors.New("--page-at-a-time copies the pages of the --recursive listing and can't be used with " +
+			"--parallel-listing, --all-versions, --use-list-cache, --expect-count, --sort or --on-conflict skip or hash")
+	}
+	if args.Traversal != traversalBreadth && args.Traversal != traversalDepth {
+		return errors.New("--traversal must be breadth or depth")
+	}
+	if args.Delimiter != "" && (!args.Recursive || args.ParallelListing || args.PageAtATime || args.AllVersions) {
+		return errors.New("--delimiter descends the --recursive listing and can't be used with " +
+			"--parallel-listing, --page-at-a-time or --all-versions")
+	}
+	if (args.FetchOwner || args.OwnerID != "") && (!args.Recursive || args.UseListCache) {
+		return errors.New("--fetch-owner and --owner-id need the owners of the --recursive listing and can't be used with --use-list-cache")
+	}
+	if args.ListOnly && (args.DeleteSource || args.Verify || args.VerifyCount || args.DryRunDiff || args.CountOnly) {
+		return errors.New("--list-only doesn't copy and can't be used with --delete-source, --verify, --verify-count, --dry-run-diff or --count-only")
+	}
+	if args.ReplicaRegion != "" && args.ReplicaBucket == "" {
+		return errors.New("--replica-region needs --replica-bucket")
+	}
+	if args.ReplicaBucket != "" && (args.Range != "" || args.DryRunDiff || args.CountOnly || args.ListOnly || args.DeleteSource) {
+		// The replicas are copied from the source after the primary copies, when --delete-source already deleted it.
+		return errors.New("--replica-bucket can't be used with --range, --dry-run-diff, --count-only, --list-only or --delete-source")
+	}
+	if args.SkipIfDestMatches && args.Range != "" {
+		return errors.New("--skip-if-dest-matches compares whole objects and can't be used with --range")
+	}
+	if args.SkipDirectoryMarkers && args.OnlyDirectoryMarkers {
+		return errors.New("--skip-directory-markers and --only-directory-markers can't be used together")
+	}
+	if (args.SkipDirectoryMarkers || args.OnlyDirectoryMarkers) && !args.Recursive {
+		return errors.New("--skip-directory-markers and --only-directory-markers need the sizes of the --recursive listing")
+	}
+	if args.EnableDestVersioning && !args.AllVersions {
+		return errors.New("--enable-dest-versioning enables the versioning for the history of --all-versions")
+	}
+	if args.IncludeDeleteMarkers && !args.AllVersions {
+		return errors.New("--include-delete-markers needs --all-versions")
+	}
+	if args.FailOnEmpty && !bulk {
+		return errors.New("--fail-on-empty needs --recursive, --inventory-manifest, --jobs, --manifest, --pairs or --retry-failed")
+	}
+	if args.RegionMap != "" && args.Pairs == "" {
+		return errors.New("--region-map needs --pairs, use --region for the buckets of SOURCE and DESTINATION")
+	}
+	if args.ExpectCount > 0 && !bulk {
+		return errors.New("--expect-count needs --recursive, --inventory-manifest, --jobs, --manifest, --pairs or --retry-failed")
+	}
+	if args.CountTolerance != 0 && args.ExpectCount == 0 {
+		return errors.New("--count-tolerance needs --expect-count")
+	}
+	if args.SplitBySize && !bulk {
+		return errors.New("--split-by-size needs --recursive, --inventory-manifest, --jobs, --manifest, --pairs or --retry-failed")
+	}
+	if args.UseListCache && args.ListCache == "" {
+		return errors.New("--use-list-cache needs --list-cache")
+	}
+	if args.ListCache != "" && !args.Recursive {
+		return errors.New("--list-cache saves the listing of --recursive")
+	}
+	if args.DestKeyTemplate != "" && (args.StripPrefix != "" || args.Flatten || args.AddPrefix != "") {
+		return errors.New("--dest-key-template can't be used with --strip-prefix, --flatten or --add-prefix, " +
+			"the template rewrites the whole key")
+	}
+	if args.Pairs != "" && rewritesKeys() {
+		return errors.New("--pairs sets the destination key of every copy and can't be used with --dest-key-template, " +
+			"--strip-prefix, --flatten or --add-prefix")
+	}
+	if args.Pairs != "" && (args.DryRunDiff || args.CountOnly || args.VerifyCount || args.Preflight || args.CreateDestBucket) {
+		return errors.New("--pairs copies to many destinations and can't be used with --dry-run-diff, --count-only, " +
+			"--verify-count, --preflight or --create-dest-bucket")
+	}
+	if !bulk && strings.TrimPrefix(source.Path, "/") == "" {
+		return errors.New("source must include an object key, e.g. s3://bucket/key, or use --recursive to copy all objects of the bucket")
+	}
+	if !args.AllowSame && !args.CountOnly && sameKeySpace(source, target) {
+		return errors.New("source and destination resolve to the same bucket and keys, the copies would overwrite " +
+			"the source objects or be listed and copied again (use --allow-same to copy in place, e.g. to change the storage class)")
+	}
+	if args.OnNotFound != onNotFoundFail && args.OnNotFound != onNotFoundSkip {
+		return errors.New("--on-not-found must be fail or skip")
+	}
+	if args.OnNotFound == onNotFoundSkip && args.Manifest == "" && args.Jobs == "" && args.RetryFailed == "" {
+		return errors.New("--on-not-found skip applies to the keys of --manifest, --jobs or --retry-failed")
+	}
+	if args.OnOversize != oversizeSkip && args.OnOversize != oversizeFail {
+		return errors.New("--on-oversize must be skip or fail")
+	}
+	if args.SkipExistingMode != skipExistingHead && args.SkipExistingMode != skipExistingList {
+		return errors.New("--skip-existing-mode must be head or list")
+	}
+	if bulk && args.Range != "" {
+		return errors.New("--range copies a part of a single object and can't be used with --recursive, --inventory-manifest, --jobs, --manifest, --pairs or --retry-failed")
+	}
+	if args.CountOnly && !bulk {
+		return errors.New("--count-only needs --recursive, --inventory-manifest, --jobs, --manifest, --pairs or --retry-failed")
+	}
+	if args.Filter != "" && (args.Jobs != "" || args.Manifest != "" || args.Pairs != "" || args.RetryFailed != "") {
+		// The invalid expressions are reported when the filter is parsed.
+		if filter, err := parseFilter(args.Filter); err == nil {
+			fields := filterFields(filter)
+			for _, field := range []string{"size", "storageClass", "lastModified", "etag", "owner"} {
+				if fields[field] {
+					return fmt.Errorf("--filter on %s needs a listing of --recursive or --inventory-manifest, "+
+						"the objects of --jobs, --manifest, --pairs and --retry-failed have only their keys", field)
+				}
+			}
+		}
+	}
+	if args.CountOnly && len(args.MetadataFilter) > 0 {
+		return errors.New("--count-only only counts the listing and can't be used with --metadata-filter")
+	}
+	if args.DeleteSource && args.Range != "" {
+		return errors.New("--delete-source can't be used with --range, the copy holds only a part of the source")
+	}
+	if args.DeleteSource && args.AllowSame {
+		return errors.New("--delete-source can't be used with --allow-same, the source would be deleted after copying in place")
+	}
+	if args.ACL != "" && (args.GrantFullControl != "" || args.GrantRead != "" || args.GrantReadACP != "" || args.GrantWriteACP != "") {
+		return errors.New("--acl can't be combined with the --grant-* flags, S3 accepts either a canned ACL or explicit grants")
+	}
+	if args.WaitMode != waitModeWaiter && args.WaitMode != waitModeHead {
+		return errors.New("--wait-mode must be waiter or head")
+	}
+	if args.WaitInterval <= 0 {
+		return errors.New("--wait-interval must be positive")
+	}
+	if args.WaitMaxAttempts < 1 {
+		return errors.New("--wait-max-attempts must be at least 1")
+	}
+	if args.Concurrency < 1 {
+		return errors.New("--concurrency must be at least 1")
+	}
+	if args.MaxIdleConns < 0 {
+		return errors.New("--max-idle-conns must not be negative")
+	}
+	if args.MaxConnsPerHost < 0 {
+		return errors.New("--max-conns-per-host must not be negative")
+	}
+	if args.ListWorkers < 1 {
+		return errors.New("--list-workers must be at least 1")
+	}
+	if args.OlderThan < 0 {
+		return errors.New("--older-than must not be negative")
+	}
+	if args.RampUp < 0 {
+		return errors.New("--ramp-up must not be negative")
+	}
+	if args.NewerGrace < 0 {
+		return errors.New("--newer-grace must not be negative")
+	}
+	if args.WorkerErrorBackoff < 0 {
+		return errors.New("--worker-error-backoff must not be negative")
+	}
+	if args.WorkerErrorThreshold < 1 {
+		return errors.New("--worker-error-threshold must be at least 1")
+	}
+	if args.PerPrefixRate < 0 {
+		return errors.New("--per-prefix-rate must not be negative")
+	}
+	if args.Webhook != "" {
+		if u, err := url.Parse(args.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("--webhook must be an http:// or https:// url")
+		}
+	}
+	if args.WebhookRequired && args.Webhook == "" {
+		return errors.New("--webhook-required needs --webhook")
+	}
+	if args.MaxRate < 0 {
+		return errors.New("--max-rate must not be negative")
+	}
+	if args.AutoThrottle && args.MaxRate == 0 {
+		return errors.New("--auto-throttle adjusts the rate of --max-rate")
+	}
+	if args.SlowObjectWarn < 0 {
+		return errors.New("--slow-object-warn must not be negative")
+	}
+	if args.ShutdownTimeout < 0 {
+		return errors.New("--shutdown-timeout must not be negative")
+	}
+	if args.ReportInterval <= 0 {
+		return errors.New("--report-interval must be positive")
+	}
+	if args.ExpectCount < 0 {
+		return errors.New("--expect-count must not be negative")
+	}
+	if args.CountTolerance < 0 {
+		return errors.New("--count-tolerance must not be negative")
+	}
+	if args.ListCacheTTL < 0 {
+		return errors.New("--list-cache-ttl must not be negative")
+	}
+	if args.TimeBudget < 0 {
+		return errors.New("--time-budget must not be negative")
+	}
+	if args.TimeBudget > 0 && args.Timeout > 0 && args.TimeBudget >= time.Duration(args.Timeout)*time.Second {
+		// The timeout cancels the whole run, including the copies started within the budget.
+		return errors.New("--time-budget must be shorter than --timeout, raise --timeout or set it to 0")
+	}
+	if args.RemainingManifest != "" && (args.TimeBudget == 0 || args.Pairs != "" || args.AllVersions) {
+		return errors.New("--remaining-manifest writes the keys left by --time-budget and can't be used with --pairs or --all-versions")
+	}
+	return nil
+}
+
+// bulkMode reports whether many objects are copied by the worker pool,
+// listed from the source bucket or taken from an inventory, jobs, manifest, pairs or summary file.
+func bulkMode() bool {
+	return args.Recursive || args.InventoryManifest != "" || args.Jobs != "" || args.Manifest != "" || args.Pairs != "" ||
+		args.RetryFailed != ""
+}
+
+// sameKeySpace reports whether the copies would be written over the source
+// objects, or, in the recursive mode, under the listed source prefix where
+// they could be listed and copied again.
+func sameKeySpace(source, target *url.URL) bool {
+	if source.Host != target.Host || rewritesKeys() || args.Jobs != "" || args.Manifest != "" || args.Pairs != "" || args.RetryFailed != "" {
+		return false
+	}
+	sourceKey := strings.TrimPrefix(source.Path, "/")
+	targetPrefix := strings.TrimPrefix(path.Clean("/"+target.Path), "/")
+	if !bulkMode() {
+		return joinKey(targetPrefix, sourceKey) == sourceKey
+	}
+	return targetPrefix == "" || strings.HasPrefix(targetPrefix+"/", sourceKey)
+}
+
+// rewritesKeys reports whether the destination keys are rewritten
+// from the source keys by the template or the key flags.
+func rewritesKeys() bool {
+	return args.DestKeyTemplate != "" || args.StripPrefix != "" || args.Flatten || args.AddPrefix != ""
+}
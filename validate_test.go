@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	arg "github.com/alexflint/go-arg"
+)
+
+// parseArgs sets the flags to the parsed command line with the defaults
+// until the test ends.
+func parseArgs(t *testing.T, argv ...string) {
+	t.Helper()
+	saveArgs(t)
+	reflect.ValueOf(&args).Elem().Set(reflect.Zero(reflect.TypeOf(args)))
+	p, err := arg.NewParser(arg.Config{}, &args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Parse(argv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustParseURL(t *testing.T, rawurl string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestValidateArgs(t *testing.T) {
+	tests := []struct {
+		argv   []string
+		source string
+		target string
+		err    string
+	}{
+		{nil, "s3://bucket1/key", "s3://bucket2/", ""},
+		{[]string{"--recursive"}, "s3://bucket1/", "s3://bucket2/backup/", ""},
+		{nil, "s3://bucket1/", "s3://bucket2/", "source must include an object key"},
+		{nil, "s3:///key", "s3://bucket2/", "source must include a bucket name"},
+		{nil, "s3://bucket1/key", "s3:///", "destination must include a bucket name"},
+		{[]string{"--recursive", "--manifest", "keys.txt"}, "s3://bucket1/", "s3://bucket2/", "only one of"},
+		{[]string{"--count-only"}, "s3://bucket1/key", "s3://bucket2/", "--count-only needs --recursive"},
+		{[]string{"--recursive", "--count-only", "--metadata-filter", "team=data"}, "s3://bucket1/", "s3://bucket2/", "--count-only only counts the listing"},
+		{[]string{"--recursive", "--filter", "size > 10MB"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--manifest", "keys.txt", "--filter", `key ~ "^logs/"`}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--manifest", "keys.txt", "--filter", `key ~ "^logs/" && !(size > 10MB)`}, "s3://bucket1/", "s3://bucket2/", "--filter on size needs a listing"},
+		{[]string{"--jobs", "jobs.csv", "--filter", "lastModified < 2024-01-01"}, "s3://bucket1/", "s3://bucket2/", "--filter on lastModified needs a listing"},
+		{[]string{"--retry-failed", "summary.json", "--filter", `etag == "abc"`}, "s3://bucket1/", "s3://bucket2/", "--filter on etag needs a listing"},
+		{[]string{"--recursive", "--range", "bytes=0-9"}, "s3://bucket1/", "s3://bucket2/", "--range copies a part"},
+		{[]string{"--delete-source", "--range", "bytes=0-9"}, "s3://bucket1/key", "s3://bucket2/", "--delete-source can't be used with --range"},
+		{[]string{"--acl", "private", "--grant-read", "id=1"}, "s3://bucket1/key", "s3://bucket2/", "--acl can't be combined"},
+		{[]string{"--recursive", "--flatten", "--dest-key-template", "{{.Base}}"}, "s3://bucket1/", "s3://bucket2/", "--dest-key-template can't be used with"},
+		{[]string{"--expect-count", "10"}, "s3://bucket1/key", "s3://bucket2/", "--expect-count needs --recursive"},
+		{[]string{"--recursive", "--count-tolerance", "5"}, "s3://bucket1/", "s3://bucket2/", "--count-tolerance needs --expect-count"},
+		{[]string{"--recursive", "--expect-count", "10", "--count-tolerance", "5"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--fail-on-empty"}, "s3://bucket1/key", "s3://bucket2/", "--fail-on-empty needs --recursive"},
+		{[]string{"--manifest", "keys.txt", "--fail-on-empty"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--all-versions"}, "s3://bucket1/key", "s3://bucket2/", "--all-versions lists the versions of --recursive"},
+		{[]string{"--recursive", "--all-versions", "--skip-existing"}, "s3://bucket1/", "s3://bucket2/", "--all-versions copies the history"},
+		{[]string{"--recursive", "--include-delete-markers"}, "s3://bucket1/", "s3://bucket2/", "--include-delete-markers needs --all-versions"},
+		{[]string{"--recursive", "--all-versions", "--include-delete-markers"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--recursive", "--page-at-a-time"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--page-at-a-time"}, "s3://bucket1/key", "s3://bucket2/", "--page-at-a-time copies the pages"},
+		{[]string{"--recursive", "--page-at-a-time", "--parallel-listing"}, "s3://bucket1/", "s3://bucket2/", "--page-at-a-time copies the pages"},
+		{[]string{"--owner-id", "1234"}, "s3://bucket1/key", "s3://bucket2/", "--fetch-owner and --owner-id need the owners"},
+		{[]string{"--recursive", "--owner-id", "1234"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--recursive", "--skip-directory-markers", "--only-directory-markers"}, "s3://bucket1/", "s3://bucket2/", "can't be used together"},
+		{[]string{"--skip-directory-markers"}, "s3://bucket1/key", "s3://bucket2/", "need the sizes of the --recursive listing"},
+		{[]string{"--recursive", "--max-object-size", "10GB", "--on-oversize", "fail"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--recursive", "--on-oversize", "warn"}, "s3://bucket1/", "s3://bucket2/", "--on-oversize must be skip or fail"},
+		{[]string{"--recursive", "--sort", "size"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--recursive", "--sort", "random"}, "s3://bucket1/", "s3://bucket2/", "--sort must be none, lexical, size or modified"},
+		{[]string{"--sort", "lexical"}, "s3://bucket1/key", "s3://bucket2/", "--sort needs --recursive"},
+		{[]string{"--slow-object-warn", "5m"}, "s3://bucket1/key", "s3://bucket2/", ""},
+		{[]string{"--slow-object-warn", "-1s"}, "s3://bucket1/key", "s3://bucket2/", "--slow-object-warn must not be negative"},
+		{[]string{"--request-payer", "owner", "--accept-requester-pays"}, "s3://bucket1/key", "s3://bucket2/", "--request-payer must be requester"},
+		{[]string{"--request-payer", "requester"}, "s3://bucket1/key", "s3://bucket2/", "add --accept-requester-pays to proceed"},
+		{[]string{"--request-payer", "requester", "--accept-requester-pays"}, "s3://bucket1/key", "s3://bucket2/", ""},
+		{[]string{"--encoding-type", "base64"}, "s3://bucket1/key", "s3://bucket2/", "--encoding-type must be url"},
+		{[]string{"--recursive", "--all-versions", "--overwrite-only-if-older-dest"}, "s3://bucket1/", "s3://bucket2/", "--all-versions copies the history"},
+		{[]string{"--recursive", "--object-count-estimate"}, "s3://bucket1/", "s3://bucket2/", "--object-count-estimate estimates the total of --progress-file"},
+		{[]string{"--object-count-estimate", "--progress-file", "progress.json"}, "s3://bucket1/key", "s3://bucket2/", "--object-count-estimate estimates the total of --progress-file"},
+		{[]string{"--recursive", "--object-count-estimate", "--progress-file", "progress.json"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--storage-class", "ARCHIVE_ACCESS"}, "s3://bucket1/key", "s3://bucket2/", "--storage-class: unknown storage class \"ARCHIVE_ACCESS\""},
+		{[]string{"--recursive", "--traversal", "random"}, "s3://bucket1/", "s3://bucket2/", "--traversal must be breadth or depth"},
+		{[]string{"--delimiter", "/"}, "s3://bucket1/key", "s3://bucket2/", "--delimiter descends the --recursive listing"},
+		{[]string{"--recursive", "--delimiter", "/", "--parallel-listing"}, "s3://bucket1/", "s3://bucket2/", "--delimiter descends the --recursive listing"},
+		{[]string{"--recursive", "--delimiter", "/", "--traversal", "breadth"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--recursive", "--enable-dest-versioning"}, "s3://bucket1/", "s3://bucket2/", "--enable-dest-versioning enables the versioning"},
+		{[]string{"--recursive", "--all-versions", "--enable-dest-versioning"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--concurrency", "0"}, "s3://bucket1/key", "s3://bucket2/", "--concurrency must be at least 1"},
+		{[]string{"--ramp-up", "-1s"}, "s3://bucket1/key", "s3://bucket2/", "--ramp-up must not be negative"},
+		{[]string{"--recursive", "--time-budget", "-1s"}, "s3://bucket1/", "s3://bucket2/", "--time-budget must not be negative"},
+		{[]string{"--wait-mode", "poll"}, "s3://bucket1/key", "s3://bucket2/", "--wait-mode must be"},
+		{[]string{"--wait", "--wait-mode", "head"}, "s3://bucket1/key", "s3://bucket2/", ""},
+		{[]string{"--wait-interval", "0s"}, "s3://bucket1/key", "s3://bucket2/", "--wait-interval must be positive"},
+		{[]string{"--wait-max-attempts", "0"}, "s3://bucket1/key", "s3://bucket2/", "--wait-max-attempts must be at least 1"},
+		{[]string{"--on-not-found", "ignore"}, "s3://bucket1/key", "s3://bucket2/", "--on-not-found must be fail or skip"},
+		{[]string{"--manifest", "keys.txt", "--on-not-found", "skip"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--on-not-found", "skip"}, "s3://bucket1/key", "s3://bucket2/", "--on-not-found skip applies"},
+		{[]string{"--recursive"}, "s3://bucket1/", "s3://bucket1/backup/", "resolve to the same bucket and keys"},
+		{[]string{"--allow-same"}, "s3://bucket1/key", "s3://bucket1/", ""},
+		{[]string{"--recursive", "--time-budget", "1h", "--timeout", "3600"}, "s3://bucket1/", "s3://bucket2/", "--time-budget must be shorter than --timeout"},
+		{[]string{"--recursive", "--time-budget", "1h", "--timeout", "7200", "--remaining-manifest", "rest.txt"}, "s3://bucket1/", "s3://bucket2/", ""},
+		{[]string{"--recursive", "--remaining-manifest", "rest.txt"}, "s3://bucket1/", "s3://bucket2/", "--remaining-manifest writes the keys left by --time-budget"},
+		{[]string{"--allow-same", "--delete-source"}, "s3://bucket1/key", "s3://bucket1/", "--delete-source can't be used with --allow-same"},
+	}
+	for _, test := range tests {
+		parseArgs(t, append(test.argv, test.source, test.target)...)
+		err := validateArgs(mustParseURL(t, test.source), mustParseURL(t, test.target))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("validateArgs(%q, %s, %s): %v", test.argv, test.source, test.target, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("validateArgs(%q, %s, %s) = %v, want %q", test.argv, test.source, test.target, err, test.err)
+		}
+	}
+}
+
+func TestSameKeySpace(t *testing.T) {
+	tests := []struct {
+		argv   []string
+		source string
+		target string
+		want   bool
+	}{
+		{nil, "s3://bucket1/key", "s3://bucket1/", true},
+		{nil, "s3://bucket1/dir/key", "s3://bucket1/dir/", false},
+		{nil, "s3://bucket1/key", "s3://bucket2/", false},
+		{nil, "s3://bucket1/key", "s3://bucket1//", true},
+		{[]string{"--recursive"}, "s3://bucket1/", "s3://bucket1/backup/", true},
+		{[]string{"--recursive"}, "s3://bucket1/data/", "s3://bucket1/data/copy/", true},
+		{[]string{"--recursive"}, "s3://bucket1/data/", "s3://bucket1/backup/", false},
+		{[]string{"--recursive"}, "s3://bucket1/data", "s3://bucket1/data-copy/", true},
+		{[]string{"--manifest", "keys.txt"}, "s3://bucket1/", "s3://bucket1/", false},
+		{[]string{"--recursive", "--add-prefix", "copy/"}, "s3://bucket1/", "s3://bucket1/", false},
+		{[]string{"--recursive", "--dest-key-template", "copy/{{.Key}}"}, "s3://bucket1/", "s3://bucket1/", false},
+	}
+	for _, test := range tests {
+		parseArgs(t, append(test.argv, test.source, test.target)...)
+		if got := sameKeySpace(mustParseURL(t, test.source), mustParseURL(t, test.target)); got != test.want {
+			t.Errorf("sameKeySpace(%s, %s) with %q = %v, want %v", test.source, test.target, test.argv, got, test.want)
+		}
+	}
+}
+
+func TestBulkMode(t *testing.T) {
+	tests := map[string]bool{
+		"":                     false,
+		"--recursive":          true,
+		"--manifest":           true,
+		"--jobs":               true,
+		"--pairs":              true,
+		"--inventory-manifest": true,
+		"--retry-failed":       true,
+	}
+	for flag, want := range tests {
+		if flag == "" || flag == "--recursive" {
+			parseArgs(t, strings.Fields(flag)...)
+		} else {
+			parseArgs(t, flag, "file")
+		}
+		if got := bulkMode(); got != want {
+			t.Errorf("bulkMode() with %q = %v, want %v", flag, got, want)
+		}
+	}
+}
+
+func TestRewritesKeys(t *testing.T) {
+	tests := map[string]bool{
+		"":                              false,
+		"--strip-prefix data/":          true,
+		"--flatten":                     true,
+		"--add-prefix archive/":         true,
+		"--dest-key-template {{.Base}}": true,
+		"--recursive --skip-existing":   false,
+	}
+	for argv, want := range tests {
+		parseArgs(t, strings.Fields(argv)...)
+		if got := rewritesKeys(); got != want {
+			t.Errorf("rewritesKeys() with %q = %v, want %v", argv, got, want)
+		}
+	}
+}
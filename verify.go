@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// keySet is a concurrency-safe set of object keys.
+type keySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// add puts the key into the set.
+func (s *keySet) add(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string]struct{})
+	}
+	s.keys[key] = struct{}{}
+}
+
+// len returns the number of keys in the set.
+func (s *keySet) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys)
+}
+
+// missing returns the sorted keys of the set that are not in the listing.
+func (s *keySet) missing(existing map[string]*s3.Object) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var missing []string
+	for key := range s.keys {
+		if _, ok := existing[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// verifyCopy checks the destination object against the source size and the ETag
+// returned by the copy. The sizes or ETags that are unknown are not compared.
+func verifyCopy(source *s3.Object, etag *string, dest *s3.HeadObjectOutput) error {
+	if source.Size != nil && aws.Int64Value(dest.ContentLength) != *source.Size {
+		return fmt.Errorf("size %d doesn't match the source size %d", aws.Int64Value(dest.ContentLength), *source.Size)
+	}
+	if etag != nil && aws.StringValue(dest.ETag) != *etag {
+		return fmt.Errorf("ETag %s doesn't match the copied ETag %s", aws.StringValue(dest.ETag), *etag)
+	}
+	return nil
+}
+
+// multipartETagPattern matches the ETag of a multipart upload, the MD5 of
+// the part MD5s followed by the number of parts.
+var multipartETagPattern = regexp.MustCompile(`^"?[0-9a-fA-F]{32}-\d+"?$`)
+
+// md5ETag reports whether the ETag of a single-part object stored with the
+// encryption is the MD5 of its content, which it isn't with SSE-KMS or SSE-C.
+func md5ETag(serverSideEncryption, customerAlgorithm *string) bool {
+	return !strings.HasPrefix(aws.StringValue(serverSideEncryption), s3.ServerSideEncryptionAwsKms) &&
+		aws.StringValue(customerAlgorithm) == ""
+}
+
+// errUnverifiable is returned by verifyContent when neither the ETags nor the
+// SHA256 checksums of the copy can be compared with the source, so only the
+// size was checked.
+var errUnverifiable = errors.New("the content can't be compared, the ETags aren't MD5s and the objects have no comparable SHA256 checksums")
+
+// verifyContent compares the copy with the source object. The ETag of a single-part
+// object is the MD5 of its content unless it's encrypted with SSE-KMS or SSE-C, at
+// the source or the destination. The other objects are compared by the SHA256
+// checksums of GetObjectAttributes: the copies of --verify are made with a SHA256
+// checksum, which matches the full-object checksum of a single-part source uploaded
+// with one. A multipart source has a checksum of its part checksums, which depends on
+// the part sizes, so it's compared only with a copy of the same number of parts.
+// Otherwise errUnverifiable is returned.
+func verifyContent(ctx context.Context, sourceSvc, svc *s3.S3, source, target *s3.GetObjectAttributesInput, sourceETag string, dest *s3.HeadObjectOutput) error {
+	destETag := aws.StringValue(dest.ETag)
+	if !multipartETagPattern.MatchString(sourceETag) && !multipartETagPattern.MatchString(destETag) &&
+		md5ETag(dest.ServerSideEncryption, dest.SSECustomerAlgorithm) {
+		head, err := sourceSvc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: source.Bucket, Key: source.Key, VersionId: source.VersionId})
+		if err != nil {
+			return fmt.Errorf("failed to head the source %s: %w", aws.StringValue(source.Key), err)
+		}
+		if md5ETag(head.ServerSideEncryption, head.SSECustomerAlgorithm) {
+			if strings.Trim(sourceETag, `"`) != strings.Trim(destETag, `"`) {
+				return fmt.Errorf("ETag %s doesn't match the source ETag %s", destETag, sourceETag)
+			}
+			return nil
+		}
+	}
+	sourceSum, sourceParts, err := objectChecksum(ctx, sourceSvc, source)
+	if err != nil {
+		return err
+	}
+	destSum, destParts, err := objectChecksum(ctx, svc, target)
+	if err != nil {
+		return err
+	}
+	if sourceSum == "" || destSum == "" || sourceParts != destParts {
+		return errUnverifiable
+	}
+	if sourceSum != destSum {
+		return fmt.Errorf("SHA256 checksum %s doesn't match the source checksum %s", destSum, sourceSum)
+	}
+	return nil
+}
+
+// objectChecksum returns the SHA256 checksum of the object and its number of parts,
+// zero for a single-part object, or an empty checksum when it was stored without one.
+func objectChecksum(ctx context.Context, svc *s3.S3, input *s3.GetObjectAttributesInput) (string, int64, error) {
+	input.ObjectAttributes = aws.StringSlice([]string{s3.ObjectAttributesChecksum, s3.ObjectAttributesObjectParts})
+	out, err := svc.GetObjectAttributesWithContext(ctx, input)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get the checksum of %s: %w", aws.StringValue(input.Key), err)
+	}
+	var parts int64
+	if out.ObjectParts != nil {
+		parts = aws.Int64Value(out.ObjectParts.TotalPartsCount)
+	}
+	if out.Checksum == nil {
+		return "", parts, nil
+	}
+	return aws.StringValue(out.Checksum.ChecksumSHA256), parts, nil
+}
+
+// countWithin reports whether the count deviates from the expected
+// count by at most the tolerance in percent.
+func countWithin(count, expected int64, tolerance float64) bool {
+	return math.Abs(float64(count-expected)) <= float64(expected)*tolerance/100
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestKeySetMissing(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	var copied keySet
+	for i, key := range []string{"backup/a", "backup/b", "backup/c", "backup/d", "backup/e"} {
+		copied.add(key)
+		if i%2 == 0 {
+			fake.put("dest", key, 1)
+		}
+	}
+	copied.add("backup/a")
+	if n := copied.len(); n != 5 {
+		t.Errorf("len() = %d, want 5", n)
+	}
+	existing, err := listExisting(context.Background(), svc, "dest", "backup/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := copied.missing(existing), []string{"backup/b", "backup/d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missing() = %q, want %q", got, want)
+	}
+}
+
+func TestVerifyCopy(t *testing.T) {
+	source := &s3.Object{Size: aws.Int64(10)}
+	dest := &s3.HeadObjectOutput{ContentLength: aws.Int64(10), ETag: aws.String(`"abc"`)}
+	if err := verifyCopy(source, aws.String(`"abc"`), dest); err != nil {
+		t.Errorf("verifyCopy() of a matching copy: %v", err)
+	}
+	if err := verifyCopy(&s3.Object{}, nil, dest); err != nil {
+		t.Errorf("verifyCopy() without the size and ETag: %v", err)
+	}
+	if err := verifyCopy(&s3.Object{Size: aws.Int64(11)}, nil, dest); err == nil {
+		t.Error("verifyCopy() of a different size succeeded, want an error")
+	}
+	if err := verifyCopy(source, aws.String(`"abd"`), dest); err == nil {
+		t.Error("verifyCopy() of a different ETag succeeded, want an error")
+	}
+}
+
+func TestMD5ETag(t *testing.T) {
+	tests := []struct {
+		sse, customer *string
+		want          bool
+	}{
+		{nil, nil, true},
+		{aws.String(s3.ServerSideEncryptionAes256), nil, true},
+		{aws.String(s3.ServerSideEncryptionAwsKms), nil, false},
+		{aws.String("aws:kms:dsse"), nil, false},
+		{nil, aws.String("AES256"), false},
+	}
+	for _, test := range tests {
+		if got := md5ETag(test.sse, test.customer); got != test.want {
+			t.Errorf("md5ETag(%v, %v) = %v, want %v", aws.StringValue(test.sse), aws.StringValue(test.customer), got, test.want)
+		}
+	}
+	for etag, want := range map[string]bool{
+		`"9bb58f26192e4ba00f01e2e7b136bbd8-3"`: true,
+		"9bb58f26192e4ba00f01e2e7b136bbd8-12":  true,
+		`"9bb58f26192e4ba00f01e2e7b136bbd8"`:   false,
+	} {
+		if got := multipartETagPattern.MatchString(etag); got != want {
+			t.Errorf("multipart ETag %s = %v, want %v", etag, got, want)
+		}
+	}
+}
+
+func TestCountWithin(t *testing.T) {
+	tests := []struct {
+		count, expected int64
+		tolerance       float64
+		want            bool
+	}{
+		{100, 100, 0, true},
+		{99, 100, 0, false},
+		{95, 100, 5, true},
+		{105, 100, 5, true},
+		{106, 100, 5, false},
+		{0, 0, 0, true},
+		{1, 0, 50, false},
+	}
+	for _, test := range tests {
+		if got := countWithin(test.count, test.expected, test.tolerance); got != test.want {
+			t.Errorf("countWithin(%d, %d, %v) = %v, want %v", test.count, test.expected, test.tolerance, got, test.want)
+		}
+	}
+}
+
+func TestVerifyContent(t *testing.T) {
+	fake, svc := newFakeS3(t)
+	ctx := context.Background()
+	tests := []struct {
+		name         string
+		source, dest fakeObject
+		want         string
+	}{
+		{"same MD5 ETags", fakeObject{etag: `"1"`}, fakeObject{etag: `"1"`}, ""},
+		{"different MD5 ETags", fakeObject{etag: `"1"`}, fakeObject{etag: `"2"`}, "doesn't match the source ETag"},
+		{"same checksums of SSE-KMS", fakeObject{etag: `"1"`, sse: "aws:kms", sha256: "abc"}, fakeObject{etag: `"2"`, sha256: "abc"}, ""},
+		{"different checksums of SSE-KMS", fakeObject{etag: `"1"`, sse: "aws:kms", sha256: "abc"}, fakeObject{etag: `"2"`, sha256: "abd"}, "SHA256 checksum abd doesn't match"},
+		{"no checksums", fakeObject{etag: `"1"`, sse: "aws:kms"}, fakeObject{etag: `"2"`, sha256: "abc"}, errUnverifiable.Error()},
+		{"multipart of the same parts", fakeObject{etag: `"9bb58f26192e4ba00f01e2e7b136bbd8-3"`, sha256: "abc", parts: 3},
+			fakeObject{etag: `"9bb58f26192e4ba00f01e2e7b136bbd8-3"`, sha256: "abc", parts: 3}, ""},
+		{"multipart of other parts", fakeObject{etag: `"9bb58f26192e4ba00f01e2e7b136bbd8-3"`, sha256: "abc", parts: 3},
+			fakeObject{etag: `"2"`, sha256: "abc"}, errUnverifiable.Error()},
+	}
+	for _, test := range tests {
+		source, dest := test.source, test.dest
+		fake.buckets["src"] = map[string]*fakeObject{"a": &source}
+		fake.buckets["dst"] = map[string]*fakeObject{"a": &dest}
+		head := &s3.HeadObjectOutput{ETag: aws.String(dest.etag)}
+		if dest.sse != "" {
+			head.ServerSideEncryption = aws.String(dest.sse)
+		}
+		err := verifyContent(ctx, svc, svc,
+			&s3.GetObjectAttributesInput{Bucket: aws.String("src"), Key: aws.String("a")},
+			&s3.GetObjectAttributesInput{Bucket: aws.String("dst"), Key: aws.String("a")},
+			source.etag, head)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("verifyContent() of %s: %v", test.name, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("verifyContent() of %s = %v, want %q", test.name, err, test.want)
+		}
+	}
+
+	delete(fake.buckets["src"], "a")
+	err := verifyContent(ctx, svc, svc,
+		&s3.GetObjectAttributesInput{Bucket: aws.String("src"), Key: aws.String("a")},
+		&s3.GetObjectAttributesInput{Bucket: aws.String("dst"), Key: aws.String("a")},
+		`"1"`, &s3.HeadObjectOutput{ETag: aws.String(`"1"`)})
+	if err == nil || !strings.Contains(err.Error(), "failed to head the source a") {
+		t.Errorf("verifyContent() of a missing source = %v, want the HEAD error", err)
+	}
+}
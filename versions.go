@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// objectVersion is a version or a delete marker in the history of a key.
+type objectVersion struct {
+	object       *s3.Object
+	versionID    string
+	deleteMarker bool
+	latest       bool
+}
+
+// sortHistory orders the listed history of a key oldest first. The listing returns
+// the versions and the delete markers newest first in separate lists, so they are
+// merged by the modification time, and the current version or marker stays last.
+func sortHistory(history []objectVersion) {
+	// Reversed to the oldest first, the stable sort keeps the listing order of the ties.
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		ti, tj := aws.TimeValue(history[i].object.LastModified), aws.TimeValue(history[j].object.LastModified)
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return !history[i].latest && history[j].latest
+	})
+}
+
+// listVersions lists the versions under the prefix of the bucket, with the delete
+// markers if markers is set, and calls fn with the history of every key oldest first.
+func listVersions(ctx context.Context, svc *s3.S3, bucket, prefix string, markers bool, fn func([]objectVersion)) error {
+	var key string
+	var history []objectVersion
+	flush := func() {
+		if len(history) > 0 {
+			sortHistory(history)
+			fn(history)
+		}
+		history = nil
+	}
+	add := func(v objectVersion) {
+		if k := aws.StringValue(v.object.Key); k != key {
+			flush()
+			key = k
+		}
+		history = append(history, v)
+	}
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	if args.EncodingType != "" {
+		input.EncodingType = aws.String(args.EncodingType)
+	}
+	err := svc.ListObjectVersionsPagesWithContext(ctx, input, func(p *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		// Both lists are sorted by key, walk them together to keep the keys in order.
+		versions, deleteMarkers := p.Versions, p.DeleteMarkers
+		for _, v := range versions {
+			v.Key = decodeKey(v.Key)
+		}
+		for _, m := range deleteMarkers {
+			m.Key = decodeKey(m.Key)
+		}
+		if !markers {
+			deleteMarkers = nil
+		}
+		for len(versions) > 0 || len(deleteMarkers) > 0 {
+			if len(deleteMarkers) == 0 || (len(versions) > 0 && aws.StringValue(versions[0].Key) <= aws.StringValue(deleteMarkers[0].Key)) {
+				v := versions[0]
+				versions = versions[1:]
+				add(objectVersion{
+					object: &s3.Object{
+						Key:          v.Key,
+						Size:         v.Size,
+						ETag:         v.ETag,
+						LastModified: v.LastModified,
+						StorageClass: v.StorageClass,
+						Owner:        v.Owner,
+					},
+					versionID: aws.StringValue(v.VersionId),
+					latest:    aws.BoolValue(v.IsLatest),
+				})
+				continue
+			}
+			m := deleteMarkers[0]
+			deleteMarkers = deleteMarkers[1:]
+			add(objectVersion{
+				object:       &s3.Object{Key: m.Key, Size: aws.Int64(0), LastModified: m.LastModified, Owner: m.Owner},
+				versionID:    aws.StringValue(m.VersionId),
+				deleteMarker: true,
+				latest:       aws.BoolValue(m.IsLatest),
+			})
+		}
+		return true // continue paging
+	})
+	if err != nil {
+		return err
+	}
+	flush()
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// versionsPage returns a ListObjectVersions page of the versions and the delete
+// markers, each KEY/VERSION/HOUR with a trailing * on the latest one, followed by
+// the next page when next isn't empty.
+func versionsPage(versions, markers []string, next string) string {
+	entry := func(kind, s string) string {
+		latest := strings.HasSuffix(s, "*")
+		f := strings.Split(strings.TrimSuffix(s, "*"), "/")
+		var hour int
+		fmt.Sscan(f[2], &hour)
+		modified := time.Date(2020, 1, 1, hour, 0, 0, 0, time.UTC).Format(time.RFC3339)
+		return fmt.Sprintf("<%s><Key>%s</Key><VersionId>%s</VersionId><IsLatest>%v</IsLatest><LastModified>%s</LastModified><Size>1</Size></%s>",
+			kind, f[0], f[1], latest, modified, kind)
+	}
+	var b strings.Builder
+	b.WriteString("<ListVersionsResult>")
+	for _, v := range versions {
+		b.WriteString(entry("Version", v))
+	}
+	for _, m := range markers {
+		b.WriteString(entry("DeleteMarker", m))
+	}
+	if next != "" {
+		fmt.Fprintf(&b, "<IsTruncated>true</IsTruncated><NextKeyMarker>%s</NextKeyMarker><NextVersionIdMarker>x</NextVersionIdMarker>", next)
+	}
+	b.WriteString("</ListVersionsResult>")
+	return b.String()
+}
+
+// historyIDs returns the version IDs of the history, the delete markers prefixed with -.
+func historyIDs(history []objectVersion) string {
+	var ids []string
+	for _, v := range history {
+		id := v.versionID
+		if v.deleteMarker {
+			id = "-" + id
+		}
+		ids = append(ids, id)
+	}
+	return aws.StringValue(history[0].object.Key) + ":" + strings.Join(ids, ",")
+}
+
+func TestListVersions(t *testing.T) {
+	saveArgs(t)
+	args.EncodingType = ""
+	fake, svc := newFakeS3(t)
+	fake.put("bucket1", "unused", 1)
+	fake.versionPages["bucket1"] = []string{
+		versionsPage([]string{"a/v3/3*", "a/v1/1", "b/v2/2*"}, []string{"a/dm2/2"}, "1"),
+		versionsPage([]string{"b/v1/1", "c/v1/1"}, []string{"c/dm3/3*"}, ""),
+	}
+	tests := []struct {
+		markers bool
+		want    []string
+	}{
+		{true, []string{"a:v1,-dm2,v3", "b:v1,v2", "c:v1,-dm3"}},
+		{false, []string{"a:v1,v3", "b:v1,v2", "c:v1"}},
+	}
+	for _, test := range tests {
+		var got []string
+		if err := listVersions(context.Background(), svc, "bucket1", "", test.markers, func(history []objectVersion) {
+			got = append(got, historyIDs(history))
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("listVersions() with markers %v = %q, want %q", test.markers, got, test.want)
+		}
+	}
+}
+
+func TestSortHistory(t *testing.T) {
+	at := aws.Time(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	history := []objectVersion{
+		{object: &s3.Object{Key: aws.String("a"), LastModified: at}, versionID: "dm", deleteMarker: true, latest: true},
+		{object: &s3.Object{Key: aws.String("a"), LastModified: at}, versionID: "v2"},
+		{object: &s3.Object{Key: aws.String("a"), LastModified: at}, versionID: "v1"},
+	}
+	sortHistory(history)
+	if got, want := historyIDs(history), "a:v1,v2,-dm"; got != want {
+		t.Errorf("sortHistory() of the same times = %q, want %q", got, want)
+	}
+}
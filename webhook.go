@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Settings of the --webhook notifications.
+const (
+	// webhookTimeout bounds every notification, so a slow endpoint doesn't stall the copies.
+	webhookTimeout = 5 * time.Second
+	// webhookConcurrency is the number of notifications sent at the same time.
+	webhookConcurrency = 8
+)
+
+// webhookEvent is the JSON body posted for every copied object.
+type webhookEvent struct {
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	Bytes     int64     `json:"bytes"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// webhook posts the events of the copied objects to the URL of --webhook.
+type webhook struct {
+	url    string
+	client *http.Client
+	slots  chan struct{}
+	wg     sync.WaitGroup
+}
+
+// newWebhook returns the webhook of the URL, or nil when the URL is empty.
+func newWebhook(url string) *webhook {
+	if url == "" {
+		return nil
+	}
+	return &webhook{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		slots:  make(chan struct{}, webhookConcurrency),
+	}
+}
+
+// send posts the event and waits for a 2xx response.
+func (w *webhook) send(ctx context.Context, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	w.slots <- struct{}{}
+	defer func() { <-w.slots }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// notify sends the event in the background and reports the failure to the callback.
+func (w *webhook) notify(event webhookEvent, failed func(error)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		if err := w.send(context.Background(), event); err != nil {
+			failed(err)
+		}
+	}()
+}
+
+// wait blocks until the background notifications are sent.
+func (w *webhook) wait() {
+	if w != nil {
+		w.wg.Wait()
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	var mu sync.Mutex
+	var events []webhookEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request %s of %s, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		var event webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+		if event.Source == "s3://src/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	if newWebhook("") != nil {
+		t.Error("newWebhook(\"\") = a webhook, want nil")
+	}
+	var none *webhook
+	none.wait()
+
+	w := newWebhook(srv.URL)
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var failures []string
+	for _, source := range []string{"s3://src/a", "s3://src/fail", "s3://src/b"} {
+		w.notify(webhookEvent{Source: source, Target: "s3://dst/x", Bytes: 10, Timestamp: at}, func(err error) {
+			mu.Lock()
+			failures = append(failures, err.Error())
+			mu.Unlock()
+		})
+	}
+	w.wait()
+
+	var sources []string
+	for _, event := range events {
+		sources = append(sources, event.Source)
+		if event.Target != "s3://dst/x" || event.Bytes != 10 || !event.Timestamp.Equal(at) {
+			t.Errorf("event = %+v", event)
+		}
+	}
+	sort.Strings(sources)
+	if strings.Join(sources, " ") != "s3://src/a s3://src/b s3://src/fail" {
+		t.Errorf("notified %q, want all the copied objects", sources)
+	}
+	if len(failures) != 1 || failures[0] != "webhook responded with 500 Internal Server Error" {
+		t.Errorf("failures = %q, want the 500 response", failures)
+	}
+}
+
+func TestWebhookSendCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newWebhook(srv.URL).send(ctx, webhookEvent{}); err == nil {
+		t.Error("send() of a canceled context succeeded, want an error")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// copyTask is an object queued for the copy workers.
+// The storage class and tags of a --jobs line override the global flags,
+// the version ID of a --manifest line selects the copied version, and the
+// buckets of a --pairs line replace the SOURCE and DESTINATION buckets.
+// With --all-versions the task is the history of a key, oldest first,
+// and with --page-at-a-time done tracks the copies of the listed page.
+type copyTask struct {
+	object       *s3.Object
+	targetPath   string
+	storageClass string
+	tags         map[string]string
+	versionID    string
+	sourceBucket string
+	targetBucket string
+	versions     []objectVersion
+	done         *sync.WaitGroup
+}
+
+// sourceBucketOr returns the source bucket of the task, or the bucket
+// of the SOURCE argument when the task doesn't name its own.
+func (t copyTask) sourceBucketOr(bucket string) string {
+	if t.sourceBucket != "" {
+		return t.sourceBucket
+	}
+	return bucket
+}
+
+// rampUpDelay returns how long the worker waits before taking its first task,
+// so the workers start evenly spread over the ramp-up window.
+func rampUpDelay(worker, workers int, window time.Duration) time.Duration {
+	if window <= 0 || workers <= 1 {
+		return 0
+	}
+	return time.Duration(int64(window) * int64(worker) / int64(workers))
+}
+
+// errorBackoff pauses a worker after a run of consecutive failures,
+// e.g. on a subtree without permissions, while the other workers continue.
+type errorBackoff struct {
+	threshold int
+	pause     time.Duration
+	streak    int
+}
+
+// record counts the result of a task and returns how long the worker pauses,
+// zero until the failures in a row reach the threshold.
+func (b *errorBackoff) record(ok bool) time.Duration {
+	if ok {
+		b.streak = 0
+		return 0
+	}
+	b.streak++
+	if b.pause <= 0 || b.streak < b.threshold {
+		return 0
+	}
+	b.streak = 0
+	return b.pause
+}
+
+// taskCost is the bytes a task adds to the load of a worker beside its size,
+// for the requests of the copy, so the small objects are spread too.
+const taskCost = 1 << 20
+
+// byteBalancer assigns the tasks of --split-by-size to the worker with the fewest
+// bytes assigned and not yet copied, so the tasks don't queue up behind a worker
+// copying a huge object while the other workers idle.
+type byteBalancer struct {
+	mu      sync.Mutex
+	pending []int64
+}
+
+func newByteBalancer(workers int) *byteBalancer {
+	return &byteBalancer{pending: make([]int64, workers)}
+}
+
+// assign returns the least loaded worker and adds the task of the size to its load.
+func (b *byteBalancer) assign(size int64) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	worker := 0
+	for i, bytes := range b.pending {
+		if bytes < b.pending[worker] {
+			worker = i
+		}
+	}
+	b.pending[worker] += size + taskCost
+	return worker
+}
+
+// done removes the finished task of the size from the load of the worker.
+func (b *byteBalancer) done(worker int, size int64) {
+	b.mu.Lock()
+	b.pending[worker] -= size + taskCost
+	b.mu.Unlock()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRampUpDelay(t *testing.T) {
+	window := 10 * time.Second
+	for worker, want := range []time.Duration{0, 2 * time.Second, 4 * time.Second, 6 * time.Second, 8 * time.Second} {
+		if got := rampUpDelay(worker, 5, window); got != want {
+			t.Errorf("rampUpDelay(%d, 5, %s) = %s, want %s", worker, window, got, want)
+		}
+	}
+	if got := rampUpDelay(3, 5, 0); got != 0 {
+		t.Errorf("rampUpDelay() without a window = %s, want 0", got)
+	}
+	if got := rampUpDelay(0, 1, window); got != 0 {
+		t.Errorf("rampUpDelay() of a single worker = %s, want 0", got)
+	}
+}
+
+func TestErrorBackoffRecord(t *testing.T) {
+	b := errorBackoff{threshold: 3, pause: time.Minute}
+	results := []struct {
+		ok   bool
+		want time.Duration
+	}{
+		{false, 0}, {false, 0}, {true, 0},
+		{false, 0}, {false, 0}, {false, time.Minute},
+		{false, 0}, {false, 0}, {false, time.Minute},
+	}
+	for i, r := range results {
+		if got := b.record(r.ok); got != r.want {
+			t.Errorf("record(%v) #%d = %s, want %s", r.ok, i+1, got, r.want)
+		}
+	}
+
+	b = errorBackoff{threshold: 1}
+	for i := 0; i < 3; i++ {
+		if got := b.record(false); got != 0 {
+			t.Errorf("record(false) without a pause = %s, want 0", got)
+		}
+	}
+}
+
+func TestByteBalancer(t *testing.T) {
+	b := newByteBalancer(3)
+	assigned := []struct {
+		size int64
+		want int
+	}{
+		{1 << 30, 0},
+		{10, 1},
+		{10, 2},
+		{10, 1},
+		{10, 2},
+	}
+	for _, a := range assigned {
+		if got := b.assign(a.size); got != a.want {
+			t.Errorf("assign(%d) = %d, want %d", a.size, got, a.want)
+		}
+	}
+	b.done(0, 1<<30)
+	if got := b.assign(10); got != 0 {
+		t.Errorf("assign() after the large task is done = %d, want 0", got)
+	}
+	b.done(1, 10)
+	b.done(1, 10)
+	if got := b.assign(0); got != 1 {
+		t.Errorf("assign() of an empty object = %d, want the idle worker 1", got)
+	}
+	// The empty objects count as tasks, so they are spread too.
+	for i, want := range []int{1, 0} {
+		if got := b.assign(0); got != want {
+			t.Errorf("assign() of another empty object #%d = %d, want %d", i+1, got, want)
+		}
+	}
+}
+
+func TestSourceBucketOr(t *testing.T) {
+	if got := (copyTask{}).sourceBucketOr("bucket1"); got != "bucket1" {
+		t.Errorf("sourceBucketOr() of a task without a bucket = %q, want bucket1", got)
+	}
+	if got := (copyTask{sourceBucket: "bucket2"}).sourceBucketOr("bucket1"); got != "bucket2" {
+		t.Errorf("sourceBucketOr() of a task of bucket2 = %q, want bucket2", got)
+	}
+}